@@ -1,16 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"strings"
 	"time"
-	"unicode/utf8"
 
 	"path/filepath"
 
@@ -22,15 +19,21 @@ import (
 )
 
 var (
-	feedbackCounter = promauto.NewCounter(prometheus.CounterOpts{
+	feedbackCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "feedback_total",
-		Help: "the times feedback was given",
-	})
+		Help: "the times feedback was given, by what became of it",
+	}, []string{"project", "outcome"})
 
-	errorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	// project is empty for errors not tied to a project, e.g. of the read api
+	errorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "feedback_errors",
 		Help: "the times errors occured",
-	})
+	}, []string{"project"})
+
+	feedbackSpamCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedback_spam_total",
+		Help: "the times feedback was classified as spam",
+	}, []string{"project"})
 )
 
 // openapi.yaml will be located and read at startup from either the
@@ -39,19 +42,40 @@ var (
 // the binary.
 
 type ApiHandler struct {
-	databaseHandler *DatabaseHandler
+	store    Store
+	projects *ProjectRegistry
+	config   *Config
 }
 
-func NewApiHandler(databaseHandler *DatabaseHandler) *ApiHandler {
+func NewApiHandler(store Store, projects *ProjectRegistry, cfg *Config) *ApiHandler {
 	return &ApiHandler{
-		databaseHandler: databaseHandler,
+		store:    store,
+		projects: projects,
+		config:   cfg,
+	}
+}
+
+// startApi serves the api on the configured address until lc stops.
+func (h *ApiHandler) startApi(lc *Lifecycle) error {
+	app, contact, err := h.newApp()
+	if err != nil {
+		return err
 	}
+	lc.Go("contact replay cache cleanup", contact.cleanupLoop)
+	lc.Serve("api", func() error {
+		return app.Listen(h.config.HTTP.Addr)
+	}, app.ShutdownWithContext)
+	return nil
 }
 
-func (h *ApiHandler) startApi() error {
+// newApp registers the routes. The caller runs the contact handler's
+// cleanupLoop.
+func (h *ApiHandler) newApp() (*fiber.App, *ContactHandler, error) {
 	app := fiber.New()
+	app.Use(requestTracing)
+	app.Use(requestMetrics)
 	app.Use(cors.New(cors.Config{
-		AllowOrigins:     "https://pro.skyblock.bz,https://songvoter.coflnet.com,https://sky.coflnet.com,https://coflnet.com,https://www.coflnet.com",
+		AllowOriginsFunc: h.projects.originAllowed,
 		AllowMethods:     "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
 		AllowHeaders:     "*",
 		AllowCredentials: false,
@@ -78,12 +102,47 @@ func (h *ApiHandler) startApi() error {
 	}
 
 	app.Get("/health", h.healthRequest)
-	app.Post("/api", h.feedbackPostRequest)
-	app.Post("/api/songvoter-feedback", h.feedbackSongvoterPostRequest)
-	app.Post("/api/pro-skyblock-feedback", h.feedbackProSkyblocPostRequest)
+	app.Post("/api/:project/feedback", h.projectFeedbackPostRequest)
+	for _, p := range h.projects.All() {
+		for _, alias := range p.Aliases {
+			app.Post(alias, h.projectFeedbackAlias(p))
+		}
+	}
+
+	// Read access to stored feedback for the support team.
+	viewer, triager, admin := h.requireRole(RoleViewer), h.requireRole(RoleTriager), h.requireRole(RoleAdmin)
+	app.Get("/api/feedback", viewer, h.listFeedbackRequest)
+	app.Get("/api/feedback/export", viewer, h.exportFeedbackRequest)
+	app.Get("/api/feedback/search", viewer, h.searchFeedbackRequest)
+	app.Get("/api/feedback/:id", viewer, h.getFeedbackRequest)
+	app.Patch("/api/feedback/:id", triager, h.patchFeedbackRequest)
+	app.Get("/api/issues", viewer, h.listIssuesRequest)
+	app.Get("/api/issues/:id", viewer, h.getIssueRequest)
+	app.Patch("/api/issues/:id", triager, h.patchIssueRequest)
+	app.Get("/api/errors", viewer, h.listErrorGroupsRequest)
+	app.Get("/api/errors/:id", viewer, h.getErrorGroupRequest)
+	app.Get("/api/stats/summary", viewer, h.statsSummaryRequest)
+	app.Get("/api/stats/volume", viewer, h.statsVolumeRequest)
+	app.Get("/api/stats/pages", viewer, h.statsPagesRequest)
+	app.Get("/api/admin/outbox", viewer, h.listOutboxRequest)
+	app.Post("/api/admin/outbox/replay", admin, h.replayOutboxRequest)
+	app.Post("/api/admin/outbox/:id/replay", admin, h.replayOutboxRequest)
+	app.Get("/api/admin/keys", admin, h.listAPIKeysRequest)
+	app.Post("/api/admin/keys", admin, h.createAPIKeyRequest)
+	app.Delete("/api/admin/keys/:id", admin, h.revokeAPIKeyRequest)
+	app.Post("/api/admin/gdpr/export", admin, h.gdprExportRequest)
+	app.Post("/api/admin/gdpr/erase", admin, h.gdprEraseRequest)
+	app.Get("/api/admin/audit", admin, h.listAuditRequest)
+
+	// Server rendered triage ui for the support team.
+	h.registerDashboard(app)
 
 	// Contact form (landing page) with multi-layered anti-spam.
-	contact := NewContactHandler()
+	contactNotifiers, err := h.projects.ContactNotifiers(h.config.Contact.Project)
+	if err != nil {
+		return nil, nil, err
+	}
+	contact := NewContactHandler(h.store, contactNotifiers, h.config)
 	app.Get("/api/contact-form/challenge", contact.getChallenge)
 	app.Post("/api/contact-form", contact.postContact)
 
@@ -123,95 +182,98 @@ func (h *ApiHandler) startApi() error {
 		return c.SendString(html)
 	})
 
-	return app.Listen(":3000")
+	return app, contact, nil
 }
 
 func (h *ApiHandler) healthRequest(c *fiber.Ctx) error {
 	return c.SendString("ok")
 }
 
-func (h *ApiHandler) feedbackPostRequest(c *fiber.Ctx) error {
-	feedback, err := parseFeedbackFromRequest(c)
-	if err != nil {
-		slog.Error("there was an error when parsing feedback", err)
-		errorsCounter.Inc()
-		return err
+// projectFeedbackPostRequest serves POST /api/{project}/feedback.
+func (h *ApiHandler) projectFeedbackPostRequest(c *fiber.Ctx) error {
+	project := h.projects.Get(c.Params("project"))
+	if project == nil {
+		return fiber.NewError(http.StatusNotFound, "unknown project")
 	}
+	return h.handleFeedback(c, project)
+}
 
-	err = h.saveFeedback(feedback)
-	if err != nil {
-		if errors.Is(err, ErrDuplicateFeedback) {
-			slog.Warn("duplicate feedback received; skipping webhook and storage")
-			c.Status(204)
-			return nil
-		}
-
-		slog.Error("there was an error when saving feedback in db", err)
-		errorsCounter.Inc()
-		return err
+// projectFeedbackAlias binds a legacy url to a fixed project.
+func (h *ApiHandler) projectFeedbackAlias(project *Project) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return h.handleFeedback(c, project)
 	}
+}
 
-	err = sendMessageToDiscordBot(feedback)
+func (h *ApiHandler) handleFeedback(c *fiber.Ctx, project *Project) error {
+	key, err := h.authenticateSubmission(c, project)
 	if err != nil {
-		slog.Error("sending message to discord failed", "err", err)
+		feedbackCounter.WithLabelValues(project.Slug, outcomeRejected).Inc()
 		return err
 	}
+	// a project key replaces the origin check, server side callers have no
+	// meaningful origin
+	if origin := c.Get(fiber.HeaderOrigin); key == nil && origin != "" && !project.allowsOrigin(origin) {
+		feedbackCounter.WithLabelValues(project.Slug, outcomeRejected).Inc()
+		return fiber.NewError(http.StatusForbidden, "origin not allowed for this project")
+	}
 
-	feedbackCounter.Inc()
-	c.Status(204)
-	return nil
-}
-
-func (h *ApiHandler) feedbackSongvoterPostRequest(c *fiber.Ctx) error {
+	_, parseSpan := tracer.Start(c.UserContext(), "feedback.parse")
 	feedback, err := parseFeedbackFromRequest(c)
+	parseSpan.End()
 	if err != nil {
-		slog.Error("there was an error when parsing feedback", err)
-		errorsCounter.Inc()
-		return err
+		slog.Error("there was an error when parsing feedback", "project", project.Slug, "err", err)
+		feedbackCounter.WithLabelValues(project.Slug, outcomeParseError).Inc()
+		errorsCounter.WithLabelValues(project.Slug).Inc()
+		return fiber.NewError(http.StatusBadRequest, err.Error())
 	}
+	feedback.Project = project.Slug
 
-	err = h.saveFeedback(feedback)
-	if err != nil {
-		if errors.Is(err, ErrDuplicateFeedback) {
-			slog.Warn("duplicate feedback received; skipping storage")
-			c.Status(204)
-			return nil
-		}
-
-		slog.Error("there was an error when saving feedback in db", err)
-		errorsCounter.Inc()
-		return err
+	if err := project.Validation.check(feedback); err != nil {
+		slog.Warn("feedback rejected by validation policy", "project", project.Slug, "err", err)
+		feedbackCounter.WithLabelValues(project.Slug, outcomeRejected).Inc()
+		errorsCounter.WithLabelValues(project.Slug).Inc()
+		return fiber.NewError(http.StatusBadRequest, err.Error())
 	}
 
-	feedbackCounter.Inc()
+	// secrets and personal data never reach the database or a notifier
+	for detector, n := range project.Redaction.apply(feedback) {
+		redactionCounter.WithLabelValues(project.Slug, detector).Add(float64(n))
+	}
 
-	c.Status(204)
-	return nil
-}
+	notifications := outboxMessagesFor(project)
+	if project.Spam.classify(feedback) {
+		// keep it for review, but don't bother anyone with it
+		slog.Warn("feedback classified as spam", "project", project.Slug, "score", feedback.SpamScore, "reasons", feedback.SpamReasons)
+		feedbackSpamCounter.WithLabelValues(project.Slug).Inc()
+		notifications = nil
+	}
 
-func (h *ApiHandler) feedbackProSkyblocPostRequest(c *fiber.Ctx) error {
-	feedback, err := parseFeedbackFromRequest(c)
-	if err != nil {
-		slog.Error("there was an error when parsing feedback", err)
-		errorsCounter.Inc()
-		return err
+	var errs *ErrorCapture
+	if !feedback.Spam {
+		errs = project.Errors.capture(project, feedback)
 	}
 
-	err = h.saveFeedback(feedback)
+	err = h.saveFeedback(c.UserContext(), feedback, notifications, errs)
 	if err != nil {
 		if errors.Is(err, ErrDuplicateFeedback) {
-			slog.Warn("duplicate feedback received; skipping storage")
+			slog.Warn("duplicate feedback received; skipping notification and storage", "project", project.Slug)
+			feedbackCounter.WithLabelValues(project.Slug, outcomeDuplicate).Inc()
 			c.Status(204)
 			return nil
 		}
 
-		slog.Error("there was an error when saving feedback in db", err)
-		errorsCounter.Inc()
+		slog.Error("there was an error when saving feedback in db", "project", project.Slug, "err", err)
+		feedbackCounter.WithLabelValues(project.Slug, outcomeStoreFailed).Inc()
+		errorsCounter.WithLabelValues(project.Slug).Inc()
 		return err
 	}
 
-	feedbackCounter.Inc()
-
+	outcome := outcomeStored
+	if feedback.Spam {
+		outcome = outcomeSpam
+	}
+	feedbackCounter.WithLabelValues(project.Slug, outcome).Inc()
 	c.Status(204)
 	return nil
 }
@@ -222,8 +284,6 @@ func parseFeedbackFromRequest(c *fiber.Ctx) (*Feedback, error) {
 	var feedback FeedbackRequest
 	if err := c.BodyParser(&feedback); err != nil {
 		slog.Error("could not parse request")
-		errorsCounter.Inc()
-
 		return nil, err
 	}
 
@@ -231,47 +291,26 @@ func parseFeedbackFromRequest(c *fiber.Ctx) (*Feedback, error) {
 	var d interface{}
 	err := json.Unmarshal([]byte(feedback.Feedback), &d)
 	if err != nil {
-		slog.Error("could not parse feedback", err)
-		errorsCounter.Inc()
-
+		slog.Error("could not parse feedback", "err", err)
 		return nil, err
 	}
 	feedback.Data = d
 	feedback.Timestamp = time.Now()
 
-	content := ""
-
-	if feedback.Data != nil {
-		// try to extract additionalInformation
-		additionalInformation, ok := feedback.Data.(map[string]interface{})["additionalInformation"]
-		if ok {
-			// check if additionalInformation is a string
-			if _, ok = additionalInformation.(string); !ok {
-				slog.Warn("additionalInformation is not a string, can't use it")
-			}
-			content = additionalInformation.(string)
-			slog.Warn("found additionalInformation in feedback data")
-		} else {
-			slog.Warn("could not find additionalInformation in feedback data")
-		}
-	}
-
-	if content == "" {
-		return nil, &AdditionalInformationIsEmptyError{}
-	}
-
 	return &Feedback{
 		Feedback:               feedback.Feedback,
-		AdditionalInformations: content,
+		AdditionalInformations: additionalInformationOf(feedback.Data),
 		User:                   feedback.User,
 		Context:                feedback.Context,
 		FeedbackName:           feedback.FeedbackName,
 		Timestamp:              feedback.Timestamp,
+		Payload:                JSONB(feedback.Feedback),
+		TriageState:            TriageState{Status: StatusNew},
 	}, nil
 }
 
-func (h *ApiHandler) saveFeedback(f *Feedback) error {
-	err := h.databaseHandler.SaveFeedback(f)
+func (h *ApiHandler) saveFeedback(ctx context.Context, f *Feedback, notifications []OutboxMessage, errs *ErrorCapture) error {
+	err := h.store.SaveFeedback(ctx, f, notifications, errs)
 	if err != nil {
 		return err
 	}
@@ -279,166 +318,6 @@ func (h *ApiHandler) saveFeedback(f *Feedback) error {
 	return nil
 }
 
-func sendMessageToDiscordBot(feedback *Feedback) error {
-	webhookUrl := os.Getenv("WEBHOOK_URL")
-
-	if webhookUrl == "YOUR_WEBHOOK_URL_HERE" {
-		return fmt.Errorf("please replace 'YOUR_WEBHOOK_URL_HERE' with your actual Discord webhook URL")
-	}
-
-	// If additional information is provided but it's too short, don't send the message.
-	// This prevents sending trivial additional info (shorter than 5 characters).
-	trimmed := strings.TrimSpace(feedback.AdditionalInformations)
-	if trimmed != "" && utf8.RuneCountInString(trimmed) < 5 {
-		slog.Warn("additionalInformation is too short; not sending message to Discord")
-		return nil
-	}
-
-	// try to parse the raw feedback JSON into a map
-	var parsed map[string]interface{}
-	if err := json.Unmarshal([]byte(feedback.Feedback), &parsed); err != nil {
-		slog.Error("could not parse feedback JSON", "err", err)
-		return err
-	}
-
-	// helper to read boolean safely
-	getBool := func(k string) bool {
-		if v, ok := parsed[k]; ok {
-			if b, ok := v.(bool); ok {
-				return b
-			}
-		}
-		return false
-	}
-
-	// extract fields
-	loadNew := getBool("loadNewInformation")
-
-	var additional string
-	if v, ok := parsed["additionalInformation"]; ok && v != nil {
-		if s, ok := v.(string); ok {
-			additional = s
-		} else {
-			// fallback: marshal non-string additionalInformation to string
-			if b, err := json.Marshal(v); err == nil {
-				additional = string(b)
-			}
-		}
-	}
-
-	// ignore feedback when loadNewInformation is false and additionalInformation is empty or too short
-	if !loadNew && len(additional) < 10 {
-		slog.Warn("ignoring feedback: loadNewInformation is false and additionalInformation is too short")
-		return nil
-	}
-
-	// format properties nicely into a message
-	var buf bytes.Buffer
-	buf.WriteString("New feedback received\n\n")
-
-	// helper to check if a value is empty/false
-	isEmpty := func(v interface{}) bool {
-		switch val := v.(type) {
-		case bool:
-			return !val
-		case string:
-			return val == ""
-		case nil:
-			return true
-		default:
-			return false
-		}
-	}
-
-	// iterate through all fields in parsed feedback and include non-empty ones
-	for key, value := range parsed {
-		if isEmpty(value) {
-			continue
-		}
-
-		switch key {
-		case "additionalInformation":
-			if s, ok := value.(string); ok && s != "" {
-				buf.WriteString(fmt.Sprintf("**additionalInformation:**\n%s\n\n", s))
-			}
-		case "errorLog":
-			// pretty-print errorLog
-			if b, err := json.MarshalIndent(value, "", "  "); err == nil {
-				buf.WriteString(fmt.Sprintf("**errorLog:**\n```\n%s\n```\n\n", string(b)))
-			}
-		case "href":
-			if s, ok := value.(string); ok && s != "" {
-				buf.WriteString(fmt.Sprintf("**href:** %s\n\n", s))
-			}
-		case "reason":
-			if s, ok := value.(string); ok && s != "" {
-				buf.WriteString(fmt.Sprintf("**reason:** %s\n", s))
-			}
-		case "rating":
-			if num, ok := value.(float64); ok {
-				buf.WriteString(fmt.Sprintf("**rating:** %.0f\n", num))
-			}
-		case "subscriptionStatus":
-			if s, ok := value.(string); ok && s != "" {
-				buf.WriteString(fmt.Sprintf("**subscriptionStatus:** %s\n", s))
-			}
-		case "timestamp":
-			if s, ok := value.(string); ok && s != "" {
-				buf.WriteString(fmt.Sprintf("**timestamp:** %s\n", s))
-			}
-		default:
-			// include any other non-empty fields
-			switch val := value.(type) {
-			case string:
-				if val != "" {
-					buf.WriteString(fmt.Sprintf("**%s:** %s\n", key, val))
-				}
-			case bool:
-				if val {
-					buf.WriteString(fmt.Sprintf("**%s:** true\n", key))
-				}
-			case float64:
-				buf.WriteString(fmt.Sprintf("**%s:** %.0f\n", key, val))
-			default:
-				if b, err := json.Marshal(value); err == nil && string(b) != "null" {
-					buf.WriteString(fmt.Sprintf("**%s:** %s\n", key, string(b)))
-				}
-			}
-		}
-	}
-	buf.WriteString("\n")
-
-	// use the formatted text as the Discord message content
-	payload := map[string]string{
-		"content": buf.String(),
-	}
-
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("error creating JSON payload: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", webhookUrl, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return fmt.Errorf("error creating HTTP request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error sending HTTP request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("received non-204 status code: %s", resp.Status)
-	}
-
-	return nil
-}
-
 type AdditionalInformationIsEmptyError struct{}
 
 func (e *AdditionalInformationIsEmptyError) Error() string {
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testApi serves the api from an in-memory store.
+type testApi struct {
+	t     *testing.T
+	app   *fiber.App
+	store Store
+	// admin is an admin key
+	admin string
+}
+
+func newTestApi(t *testing.T) *testApi {
+	t.Helper()
+	return newTestApiFor(t, nil)
+}
+
+// newTestApiFor serves the given projects, or the built-in ones for nil.
+func newTestApiFor(t *testing.T, projects []*Project) *testApi {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Database.Driver = driverMemory
+	cfg.Contact.ChallengeSecret = "test"
+
+	db := NewDatabaseHandler(cfg)
+	if err := db.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	registry, err := LoadProjectRegistry(cfg)
+	if projects != nil {
+		registry, err = NewProjectRegistry(projects)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, _, err := NewApiHandler(db, registry, cfg).newApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := bootstrapAdminKey(db, "test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testApi{t: t, app: app, store: db, admin: admin}
+}
+
+// do sends a request with the key and body, encoded as JSON unless it is
+// a string, and returns the status and the response body.
+func (a *testApi) do(method, path, key string, body interface{}) (int, []byte) {
+	a.t.Helper()
+	var r io.Reader
+	contentType := fiber.MIMEApplicationJSON
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+		contentType = fiber.MIMEApplicationForm
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		r = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := a.app.Test(req, -1)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return resp.StatusCode, b
+}
+
+// get expects a 200 and decodes the JSON answer into v.
+func (a *testApi) get(path string, v interface{}) {
+	a.t.Helper()
+	status, body := a.do("GET", path, a.admin, nil)
+	if status != http.StatusOK {
+		a.t.Fatalf("GET %s = %d: %s", path, status, body)
+	}
+	if v != nil {
+		if err := json.Unmarshal(body, v); err != nil {
+			a.t.Fatalf("GET %s: %v: %s", path, err, body)
+		}
+	}
+}
+
+// submit posts feedback with the payload to the sky project.
+func (a *testApi) submit(user string, payload map[string]interface{}) int {
+	a.t.Helper()
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	status, body := a.do("POST", "/api/sky/feedback", "", map[string]string{
+		"feedback":    string(raw),
+		"user":        user,
+		"context":     "flipper",
+		"fedbackName": "flipperFeedback",
+	})
+	if status >= 300 {
+		a.t.Fatalf("submitting feedback = %d: %s", status, body)
+	}
+	return status
+}
+
+func TestApiOriginCheck(t *testing.T) {
+	a := newTestApiFor(t, []*Project{
+		{Slug: "mod", AllowedOrigins: []string{"https://mod.example"}},
+		{Slug: "open"},
+	})
+	for _, tc := range []struct {
+		project, origin string
+		want            int
+	}{
+		{"mod", "https://mod.example", http.StatusNoContent},
+		{"mod", "https://evil.example", http.StatusForbidden},
+		// servers and apps send no origin
+		{"mod", "", http.StatusNoContent},
+		{"open", "https://evil.example", http.StatusNoContent},
+		{"open", "", http.StatusNoContent},
+	} {
+		form := url.Values{"feedback": {`{"additionalInformation":"origin ` + tc.project + tc.origin + `"}`}, "user": {"u"}}
+		req := httptest.NewRequest("POST", "/api/"+tc.project+"/feedback", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", fiber.MIMEApplicationForm)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		resp, err := a.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s from %q = %d, want %d", tc.project, tc.origin, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestApiFeedback(t *testing.T) {
+	a := newTestApi(t)
+	crash := map[string]interface{}{
+		"additionalInformation": "The flipper page crashes when sorting by profit",
+		"rating":                2,
+		"somethingBroke":        true,
+		"href":                  "https://sky.coflnet.com/flipper?sort=profit#top",
+		"errorLog":              []string{"TypeError: x is undefined\n    at sort (https://sky.coflnet.com/main.js:1:2)"},
+		"clientVersion":         "1.4.2",
+	}
+	a.submit("alice", crash)
+	a.submit("bob", map[string]interface{}{
+		"additionalInformation": "Die Auktionsseite lädt sehr schnell, danke",
+		"rating":                5,
+		"somethingBroke":        false,
+		"href":                  "https://sky.coflnet.com/auction",
+	})
+	// the same content again is a duplicate and not stored
+	if status := a.submit("alice", crash); status != http.StatusNoContent {
+		t.Fatalf("duplicate = %d", status)
+	}
+
+	var page FeedbackPage
+	a.get("/api/feedback", &page)
+	if len(page.Items) != 2 || page.Items[0].User != "bob" {
+		t.Fatalf("listing = %+v", page.Items)
+	}
+	a.get("/api/feedback?limit=1", &page)
+	if len(page.Items) != 1 || page.NextCursor == "" {
+		t.Fatalf("first page = %d items, cursor %q", len(page.Items), page.NextCursor)
+	}
+	a.get("/api/feedback?limit=1&cursor="+page.NextCursor, &page)
+	if len(page.Items) != 1 || page.Items[0].User != "alice" || page.Items[0].DuplicateCount != 1 {
+		t.Fatalf("second page = %+v", page.Items)
+	}
+	id := page.Items[0].ID
+
+	for query, want := range map[string]string{
+		"payload.rating=lt:3":                           "alice",
+		"payload.somethingBroke=true":                   "alice",
+		"payload.href=contains:auction":                 "bob",
+		"payload.href=prefix:https://sky.coflnet.com/f": "alice",
+		"payload.rating=5":                              "bob",
+		"payload.clientVersion=exists:false":            "bob",
+	} {
+		a.get("/api/feedback?"+query, &page)
+		if len(page.Items) != 1 || page.Items[0].User != want {
+			t.Errorf("%s: got %d items, want %s", query, len(page.Items), want)
+		}
+	}
+
+	var f Feedback
+	a.get(fmt.Sprintf("/api/feedback/%d", id), &f)
+	if f.User != "alice" || f.Project != "sky" {
+		t.Fatalf("feedback = %+v", f)
+	}
+	if status, _ := a.do("GET", "/api/feedback/999", a.admin, nil); status != http.StatusNotFound {
+		t.Errorf("unknown feedback = %d, want 404", status)
+	}
+
+	status, body := a.do("PATCH", fmt.Sprintf("/api/feedback/%d", id), a.admin, map[string]string{"status": "in_progress", "assignee": "carol"})
+	if status != http.StatusOK {
+		t.Fatalf("PATCH = %d: %s", status, body)
+	}
+	a.get("/api/feedback?status=in_progress&assignee=carol", &page)
+	if len(page.Items) != 1 || page.Items[0].ID != id {
+		t.Fatalf("triaged listing = %+v", page.Items)
+	}
+
+	var hits FeedbackSearchPage
+	a.get("/api/feedback/search?q=flipping+crash", &hits)
+	if len(hits.Items) != 1 || hits.Items[0].Feedback.ID != id || len(hits.Items[0].Highlights) == 0 {
+		t.Fatalf("search = %+v", hits.Items)
+	}
+	a.get("/api/feedback/search?q=auktionsseite&lang=de", &hits)
+	if len(hits.Items) != 1 || hits.Items[0].Feedback.User != "bob" {
+		t.Fatalf("german search = %+v", hits.Items)
+	}
+
+	status, body = a.do("GET", "/api/feedback/export?format=ndjson", a.admin, nil)
+	if status != http.StatusOK || bytes.Count(body, []byte("\n")) != 2 {
+		t.Fatalf("export = %d: %s", status, body)
+	}
+}
+
+func TestApiStats(t *testing.T) {
+	a := newTestApi(t)
+	for i, rating := range []int{1, 2, 9, 10} {
+		a.submit(fmt.Sprintf("user%d", i), map[string]interface{}{
+			"additionalInformation": fmt.Sprintf("feedback number %d", i),
+			"rating":                rating,
+			"somethingBroke":        i == 0,
+			"href":                  "https://sky.coflnet.com/flipper?x=" + fmt.Sprint(i),
+		})
+	}
+
+	var summary StatsSummary
+	a.get("/api/stats/summary", &summary)
+	if summary.Total != 4 || summary.SomethingBroke != 1 || summary.Ratings.Count != 4 || summary.Ratings.Scale != 10 {
+		t.Fatalf("summary = %+v", summary)
+	}
+	if summary.NPS == nil || summary.NPS.Promoters != 2 || summary.NPS.Detractors != 2 {
+		t.Fatalf("nps = %+v", summary.NPS)
+	}
+
+	for _, bucket := range []string{"hour", "day", "week"} {
+		var buckets []VolumeBucket
+		a.get("/api/stats/volume?bucket="+bucket, &buckets)
+		var total int64
+		for _, b := range buckets {
+			total += b.Count
+		}
+		if total != 4 || buckets[len(buckets)-1].Count != 4 {
+			t.Errorf("%s volume = %d in total, %+v last", bucket, total, buckets[len(buckets)-1])
+		}
+	}
+
+	var pages []PageStats
+	a.get("/api/stats/pages", &pages)
+	if len(pages) != 1 || pages[0].Href != "https://sky.coflnet.com/flipper" || pages[0].Negative != 2 || pages[0].SomethingBroke != 1 {
+		t.Fatalf("pages = %+v", pages)
+	}
+}
+
+func TestApiIssuesAndErrors(t *testing.T) {
+	a := newTestApi(t)
+	for _, user := range []string{"alice", "bob"} {
+		a.submit(user, map[string]interface{}{
+			"additionalInformation": "The flipper page shows no flips since the last update " + user,
+			"href":                  "https://sky.coflnet.com/flipper",
+			"errorLog":              []string{"TypeError: flips is undefined\n    at render (https://sky.coflnet.com/main.js:10:20)"},
+			"clientVersion":         "1.4.2",
+		})
+	}
+
+	var issues IssuePage
+	a.get("/api/issues", &issues)
+	if len(issues.Items) != 1 || issues.Items[0].Occurrences != 2 {
+		t.Fatalf("issues = %+v", issues.Items)
+	}
+	id := issues.Items[0].ID
+	var issue Issue
+	a.get(fmt.Sprintf("/api/issues/%d", id), &issue)
+	status, body := a.do("PATCH", fmt.Sprintf("/api/issues/%d", id), a.admin, map[string]string{"status": "resolved", "resolutionNote": "fixed in 1.4.3"})
+	if status != http.StatusOK {
+		t.Fatalf("PATCH issue = %d: %s", status, body)
+	}
+	var result IssueTriageResult
+	if err := json.Unmarshal(body, &result); err != nil || result.FeedbackUpdated != 2 {
+		t.Fatalf("triage result = %s, %v", body, err)
+	}
+
+	var groups ErrorGroupPage
+	a.get("/api/errors", &groups)
+	if len(groups.Items) != 1 || groups.Items[0].Occurrences != 2 {
+		t.Fatalf("error groups = %+v", groups.Items)
+	}
+	var detail ErrorGroupDetail
+	a.get(fmt.Sprintf("/api/errors/%d", groups.Items[0].ID), &detail)
+	if len(detail.Versions) != 1 || detail.Versions[0].Occurrences != 2 {
+		t.Fatalf("error group detail = %s", body)
+	}
+	var page FeedbackPage
+	a.get(fmt.Sprintf("/api/feedback?error=%d", groups.Items[0].ID), &page)
+	if len(page.Items) != 2 {
+		t.Fatalf("feedback of the error group = %d", len(page.Items))
+	}
+}
+
+func TestApiAdmin(t *testing.T) {
+	a := newTestApi(t)
+	a.submit("alice", map[string]interface{}{"additionalInformation": "hello", "email": "alice@example.com"})
+
+	status, body := a.do("POST", "/api/admin/keys", a.admin, map[string]string{"name": "grafana", "role": "viewer"})
+	if status != http.StatusCreated && status != http.StatusOK {
+		t.Fatalf("creating a key = %d: %s", status, body)
+	}
+	var created createdAPIKey
+	if err := json.Unmarshal(body, &created); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := a.do("GET", "/api/feedback", created.Key, nil); status != http.StatusOK {
+		t.Errorf("viewer listing = %d", status)
+	}
+	if status, _ := a.do("GET", "/api/admin/keys", created.Key, nil); status != http.StatusForbidden {
+		t.Errorf("viewer on an admin route = %d, want 403", status)
+	}
+	if status, _ := a.do("GET", "/api/feedback", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("no key = %d, want 401", status)
+	}
+
+	var keys []APIKey
+	a.get("/api/admin/keys", &keys)
+	if len(keys) != 2 {
+		t.Fatalf("keys = %+v", keys)
+	}
+	if status, body := a.do("DELETE", fmt.Sprintf("/api/admin/keys/%d", created.ID), a.admin, nil); status != http.StatusNoContent {
+		t.Fatalf("revoking = %d: %s", status, body)
+	}
+	if status, _ := a.do("GET", "/api/feedback", created.Key, nil); status != http.StatusUnauthorized {
+		t.Errorf("revoked key = %d, want 401", status)
+	}
+
+	var msgs []OutboxMessage
+	a.get("/api/admin/outbox?status=pending", &msgs)
+	if len(msgs) != 1 {
+		t.Fatalf("pending notifications = %+v", msgs)
+	}
+	if status, _ := a.do("POST", fmt.Sprintf("/api/admin/outbox/%d/replay", msgs[0].ID), a.admin, nil); status != http.StatusNotFound {
+		t.Errorf("replaying a pending message = %d, want 404", status)
+	}
+	if status, body := a.do("POST", "/api/admin/outbox/replay", a.admin, nil); status != http.StatusOK {
+		t.Errorf("replaying all = %d: %s", status, body)
+	}
+
+	status, body = a.do("POST", "/api/admin/gdpr/export", a.admin, map[string]string{"user": "alice"})
+	var bundle DataSubjectBundle
+	if status != http.StatusOK || json.Unmarshal(body, &bundle) != nil || len(bundle.Feedback) != 1 {
+		t.Fatalf("gdpr export = %d: %s", status, body)
+	}
+	status, body = a.do("POST", "/api/admin/gdpr/erase", a.admin, map[string]string{"user": "alice", "mode": "delete"})
+	var erased ErasureResult
+	if status != http.StatusOK || json.Unmarshal(body, &erased) != nil || erased.FeedbackDeleted != 1 {
+		t.Fatalf("gdpr erase = %d: %s", status, body)
+	}
+	var page FeedbackPage
+	a.get("/api/feedback", &page)
+	if len(page.Items) != 0 {
+		t.Fatalf("feedback left after erasure: %+v", page.Items)
+	}
+
+	var events []AuditEvent
+	a.get("/api/admin/audit", &events)
+	if len(events) < 2 {
+		t.Fatalf("audit events = %+v", events)
+	}
+}
+
+func TestApiPublicRoutes(t *testing.T) {
+	a := newTestApi(t)
+	if status, body := a.do("GET", "/health", "", nil); status != http.StatusOK || string(body) != "ok" {
+		t.Errorf("health = %d %s", status, body)
+	}
+	if status, _ := a.do("POST", "/api/unknown/feedback", "", map[string]string{"feedback": "{}"}); status != http.StatusNotFound {
+		t.Errorf("unknown project = %d, want 404", status)
+	}
+	if status, _ := a.do("POST", "/api/sky/feedback", "", map[string]string{"feedback": "not json"}); status != http.StatusBadRequest {
+		t.Errorf("invalid feedback = %d, want 400", status)
+	}
+	// the legacy url of the sky project
+	raw, _ := json.Marshal(map[string]string{"additionalInformation": "via the alias"})
+	if status, body := a.do("POST", "/api", "", map[string]string{"feedback": string(raw)}); status != http.StatusNoContent {
+		t.Errorf("alias = %d: %s", status, body)
+	}
+	if status, _ := a.do("GET", "/api/contact-form/challenge", "", nil); status != http.StatusOK {
+		t.Errorf("challenge = %d", status)
+	}
+}
+
+func TestFixedRoutePrefixesCoverRoutes(t *testing.T) {
+	a := newTestApi(t)
+	projectRoutes := map[string]bool{"/api/:project/feedback": true, "/api": true}
+	for _, p := range defaultProjects() {
+		for _, alias := range p.Aliases {
+			projectRoutes[alias] = true
+		}
+	}
+	for _, r := range a.app.GetRoutes(true) {
+		if r.Path == "/" || projectRoutes[r.Path] {
+			continue
+		}
+		if !reservedRoute(r.Path) {
+			t.Errorf("%s %s is not below any of fixedRoutePrefixes", r.Method, r.Path)
+		}
+	}
+}
+
+func TestApiDashboard(t *testing.T) {
+	a := newTestApi(t)
+	a.submit("alice", map[string]interface{}{"additionalInformation": "the dashboard should show this"})
+	if err := a.store.SaveContactMessage(&ContactSubmission{Name: "Dana", Email: "dana@example.com", Message: "hi"}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	session := a.dashboardLogin(a.admin)
+	if session == a.admin || !strings.HasPrefix(session, sessionPrefix) {
+		t.Fatalf("the cookie should hold a session id, got %q", session)
+	}
+	for _, path := range []string{"/admin/feedback", "/admin/feedback/1", "/admin/contact", "/admin/contact/1"} {
+		status, b := a.dashboardGet(path, session)
+		if status != http.StatusOK {
+			t.Errorf("%s = %d", path, status)
+		}
+		if path == "/admin/feedback/1" && !strings.Contains(string(b), "the dashboard should show this") {
+			t.Errorf("%s lacks the feedback", path)
+		}
+	}
+	// the key itself is no session
+	if status, _ := a.dashboardGet("/admin/feedback", a.admin); status != http.StatusSeeOther {
+		t.Errorf("key as cookie = %d, want a redirect to sign in", status)
+	}
+}
+
+func TestApiDashboardSessionEnds(t *testing.T) {
+	a := newTestApi(t)
+	status, body := a.do("POST", "/api/admin/keys", a.admin, map[string]string{"name": "bob", "role": "viewer"})
+	var created createdAPIKey
+	if err := json.Unmarshal(body, &created); err != nil || created.Key == "" {
+		t.Fatalf("creating a key = %d: %s", status, body)
+	}
+
+	// signing out ends the session on the server, not only in the browser
+	session := a.dashboardLogin(created.Key)
+	if status := a.dashboardLogout(session, csrfToken(session)); status != http.StatusSeeOther {
+		t.Fatalf("sign out = %d", status)
+	}
+	if status, _ := a.dashboardGet("/admin/feedback", session); status != http.StatusSeeOther {
+		t.Errorf("after sign out = %d, want a redirect to sign in", status)
+	}
+
+	// so does revoking the key it was opened with
+	session = a.dashboardLogin(created.Key)
+	if status, _ := a.dashboardGet("/admin/feedback", session); status != http.StatusOK {
+		t.Fatalf("new session = %d", status)
+	}
+	if status, body := a.do("DELETE", fmt.Sprintf("/api/admin/keys/%d", created.ID), a.admin, nil); status != http.StatusNoContent {
+		t.Fatalf("revoke = %d: %s", status, body)
+	}
+	if status, _ := a.dashboardGet("/admin/feedback", session); status != http.StatusSeeOther {
+		t.Errorf("after revoking the key = %d, want a redirect to sign in", status)
+	}
+}
+
+// dashboardLogin signs in with the key and returns the session cookie.
+func (a *testApi) dashboardLogin(key string) string {
+	a.t.Helper()
+	req := httptest.NewRequest("POST", "/admin/login", strings.NewReader("key="+url.QueryEscape(key)))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	resp, err := a.app.Test(req, -1)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSeeOther {
+		a.t.Fatalf("sign in = %d", resp.StatusCode)
+	}
+	for _, c := range resp.Cookies() {
+		if c.Name == dashboardCookie {
+			return c.Value
+		}
+	}
+	a.t.Fatal("sign in set no session cookie")
+	return ""
+}
+
+// dashboardLogout signs the session out with the csrf token and returns the
+// status.
+func (a *testApi) dashboardLogout(session, csrf string) int {
+	a.t.Helper()
+	req := httptest.NewRequest("POST", "/admin/logout", strings.NewReader("csrf="+url.QueryEscape(csrf)))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.AddCookie(&http.Cookie{Name: dashboardCookie, Value: session})
+	resp, err := a.app.Test(req, -1)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestApiDashboardRejectsCrossSiteForms(t *testing.T) {
+	a := newTestApi(t)
+
+	// a form on another site can't sign the browser in
+	for origin, want := range map[string]int{
+		"":                               http.StatusSeeOther,
+		"http://example.com":             http.StatusSeeOther,
+		"https://evil.example":           http.StatusForbidden,
+		"null":                           http.StatusForbidden,
+		"referer:https://evil.example/x": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest("POST", "/admin/login", strings.NewReader("key="+url.QueryEscape(a.admin)))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+		if referer, ok := strings.CutPrefix(origin, "referer:"); ok {
+			req.Header.Set(fiber.HeaderReferer, referer)
+		} else if origin != "" {
+			req.Header.Set(fiber.HeaderOrigin, origin)
+		}
+		resp, err := a.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("sign in from %q = %d, want %d", origin, resp.StatusCode, want)
+		}
+	}
+
+	// nor sign it out
+	session := a.dashboardLogin(a.admin)
+	if status := a.dashboardLogout(session, ""); status != http.StatusForbidden {
+		t.Errorf("sign out without the csrf token = %d", status)
+	}
+	if status, _ := a.dashboardGet("/admin/feedback", session); status != http.StatusOK {
+		t.Errorf("the session ended without the csrf token: %d", status)
+	}
+}
+
+// dashboardGet requests a dashboard page with the session cookie.
+func (a *testApi) dashboardGet(path, session string) (int, []byte) {
+	a.t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	req.AddCookie(&http.Cookie{Name: dashboardCookie, Value: session})
+	resp, err := a.app.Test(req, -1)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, b
+}
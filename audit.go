@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// AuditEvent records an administrative action on personal data. It never
+// holds the personal data itself: the subject is stored as a hash, so a
+// later request about the same person can be matched without keeping who
+// it was.
+type AuditEvent struct {
+	gorm.Model
+	Action      string `json:"action" gorm:"index"`
+	Actor       string `json:"actor"`
+	SubjectHash string `json:"subjectHash" gorm:"index"`
+	Details     JSONB  `json:"details"`
+}
+
+// auditSubjectHash hashes the identifiers of a data subject. Emails are
+// compared case-insensitively, so they are lowercased first.
+func auditSubjectHash(user, email string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(user) + "\x00" + strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordAudit stores an audit event. details is marshalled to JSON.
+func (d *DatabaseHandler) RecordAudit(tx *gorm.DB, action, actor, subjectHash string, details interface{}) error {
+	b, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	if tx == nil {
+		tx = d.db
+	}
+	return tx.Create(&AuditEvent{Action: action, Actor: actor, SubjectHash: subjectHash, Details: JSONB(b)}).Error
+}
+
+// ListAuditEvents returns the newest events first.
+func (d *DatabaseHandler) ListAuditEvents(limit int) ([]AuditEvent, error) {
+	var events []AuditEvent
+	err := d.db.Order("id desc").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// actorOf names the caller of an admin request for the audit log.
+func actorOf(c *fiber.Ctx) string {
+	k := principal(c)
+	if k == nil {
+		return "unknown"
+	}
+	return "key:" + k.Prefix + " (" + k.Name + ")"
+}
+
+func (h *ApiHandler) listAuditRequest(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", feedbackPageDefault)
+	if limit <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "limit must be a positive integer")
+	}
+	events, err := h.store.ListAuditEvents(min(limit, feedbackPageMax))
+	if err != nil {
+		slog.Error("could not list audit events", "err", err)
+		return fiber.NewError(http.StatusInternalServerError, "could not list audit events")
+	}
+	return c.JSON(events)
+}
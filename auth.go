@@ -0,0 +1,414 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Role is the permission level of an admin credential. Every role includes
+// the permissions of the ones below it.
+type Role string
+
+const (
+	// RoleViewer can read feedback and the notification outbox.
+	RoleViewer Role = "viewer"
+	// RoleTriager can additionally change the triage state of feedback.
+	RoleTriager Role = "triager"
+	// RoleAdmin can additionally replay notifications and manage keys.
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:  1,
+	RoleTriager: 2,
+	RoleAdmin:   3,
+}
+
+func (r Role) valid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// allows reports whether r includes the permissions of required.
+func (r Role) allows(required Role) bool {
+	return r.valid() && roleRank[r] >= roleRank[required]
+}
+
+// Key prefixes tell the two kinds of keys apart at a glance, e.g. in a
+// leaked config file.
+const (
+	projectKeyPrefix = "fbp_"
+	adminKeyPrefix   = "fba_"
+	sessionPrefix    = "fbs_"
+)
+
+// lastUsedResolution limits how often last_used_at is written for a busy key.
+const lastUsedResolution = time.Minute
+
+// APIKey is a credential stored in the database. A project key (Project set)
+// may only submit feedback to that project and is meant for backend to
+// backend submissions. An admin key (Role set) grants access to the read,
+// triage and admin endpoints. Only a hash of the key is stored; the key
+// itself is shown once when it is created.
+type APIKey struct {
+	gorm.Model
+	Name       string     `json:"name"`
+	Hash       string     `gorm:"uniqueIndex" json:"-"`
+	Prefix     string     `json:"prefix"`
+	Project    string     `gorm:"index" json:"project,omitempty"`
+	Role       Role       `json:"role,omitempty"`
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+// ErrInvalidAPIKey is returned for unknown and revoked keys alike so callers
+// can't probe which keys once existed.
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// generateAPIKey returns a new random key with the given prefix and the hash
+// to store for it.
+func generateAPIKey(prefix string) (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = prefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, hashAPIKey(key), nil
+}
+
+// hashAPIKey hashes a key for storage and lookup. The keys are 256 bit
+// random values, so a plain SHA-256 is enough; there is nothing to brute
+// force.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// keyDisplayPrefix is the part of a key that is kept in clear text so
+// people can tell their keys apart.
+func keyDisplayPrefix(key string) string {
+	if len(key) > 12 {
+		return key[:12]
+	}
+	return key
+}
+
+// bearerToken extracts the token of an "Authorization: Bearer" header.
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	token = strings.TrimSpace(token)
+	return token, ok && token != ""
+}
+
+// CreateAPIKey mints a key and stores its hash. The returned string is the
+// only copy of the key.
+func (d *DatabaseHandler) CreateAPIKey(k *APIKey) (string, error) {
+	if (k.Project == "") == (k.Role == "") {
+		return "", fmt.Errorf("a key is either scoped to a project or has a role")
+	}
+	if k.Role != "" && !k.Role.valid() {
+		return "", fmt.Errorf("unknown role %q", k.Role)
+	}
+	prefix := adminKeyPrefix
+	if k.Project != "" {
+		prefix = projectKeyPrefix
+	}
+	key, hash, err := generateAPIKey(prefix)
+	if err != nil {
+		return "", err
+	}
+	k.Hash = hash
+	k.Prefix = keyDisplayPrefix(key)
+	if err := d.db.Create(k).Error; err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// AuthenticateAPIKey looks up an active key and records that it was used.
+func (d *DatabaseHandler) AuthenticateAPIKey(key string) (*APIKey, error) {
+	var k APIKey
+	err := d.db.Where("hash = ? AND revoked_at IS NULL", hashAPIKey(key)).First(&k).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) > lastUsedResolution {
+		err := d.db.Model(&APIKey{}).Where("id = ?", k.ID).UpdateColumn("last_used_at", now).Error
+		if err != nil {
+			slog.Warn("could not record api key usage", "key", k.Prefix, "err", err)
+		}
+	}
+	return &k, nil
+}
+
+// ListAPIKeys returns all keys including revoked ones, newest first.
+func (d *DatabaseHandler) ListAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := d.db.Order("id desc").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey disables a key and signs out the dashboard sessions opened
+// with it. Revoking an already revoked key is a no-op.
+func (d *DatabaseHandler) RevokeAPIKey(id uint) error {
+	var k APIKey
+	if err := d.db.First(&k, id).Error; err != nil {
+		return err
+	}
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", id).UpdateColumn("revoked_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("api_key_id = ?", id).Delete(&DashboardSession{}).Error
+	})
+}
+
+// CountActiveAdminKeys counts unrevoked keys with the admin role.
+func (d *DatabaseHandler) CountActiveAdminKeys() (int64, error) {
+	var n int64
+	err := d.db.Model(&APIKey{}).Where("role = ? AND revoked_at IS NULL", RoleAdmin).Count(&n).Error
+	return n, err
+}
+
+// DashboardSession is a dashboard sign in. The browser holds a random
+// session id instead of the admin key, so a leaked cookie expires and ends
+// with a sign out or the revocation of the key. Only a hash of the id is
+// stored, like for keys.
+type DashboardSession struct {
+	Hash      string `gorm:"primaryKey"`
+	APIKeyID  uint   `gorm:"index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// CreateDashboardSession opens a session for the key that lasts ttl and
+// returns its id, the only copy of it. Expired sessions are dropped on the
+// way.
+func (d *DatabaseHandler) CreateDashboardSession(keyID uint, ttl time.Duration) (string, error) {
+	id, hash, err := generateAPIKey(sessionPrefix)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	if err := d.db.Where("expires_at < ?", now).Delete(&DashboardSession{}).Error; err != nil {
+		slog.Warn("could not drop expired dashboard sessions", "err", err)
+	}
+	err = d.db.Create(&DashboardSession{Hash: hash, APIKeyID: keyID, CreatedAt: now, ExpiresAt: now.Add(ttl)}).Error
+	return id, err
+}
+
+// AuthenticateDashboardSession returns the active key of an unexpired
+// session. Unknown and expired sessions and revoked keys all give
+// ErrInvalidAPIKey.
+func (d *DatabaseHandler) AuthenticateDashboardSession(id string) (*APIKey, error) {
+	var session DashboardSession
+	err := d.db.Where("hash = ? AND expires_at > ?", hashAPIKey(id), time.Now()).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	var k APIKey
+	err = d.db.Where("id = ? AND revoked_at IS NULL", session.APIKeyID).First(&k).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// DeleteDashboardSession ends a session. Unknown ids are ignored.
+func (d *DatabaseHandler) DeleteDashboardSession(id string) error {
+	return d.db.Where("hash = ?", hashAPIKey(id)).Delete(&DashboardSession{}).Error
+}
+
+// principalKey is the fiber.Ctx local holding the authenticated *APIKey.
+const principalKey = "principal"
+
+// principal returns the key the request was authenticated with, if any.
+func principal(c *fiber.Ctx) *APIKey {
+	k, _ := c.Locals(principalKey).(*APIKey)
+	return k
+}
+
+// requireRole guards the read, triage and admin endpoints. The admin key
+// must be sent as "Authorization: Bearer <key>" and have at least the given
+// role. Project keys are never accepted here.
+func (h *ApiHandler) requireRole(role Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, ok := bearerToken(c)
+		if !ok {
+			return fiber.NewError(http.StatusUnauthorized, "invalid or missing token")
+		}
+		k, err := h.authenticateAdmin(token, role)
+		if err != nil {
+			return err
+		}
+		c.Locals(principalKey, k)
+		return c.Next()
+	}
+}
+
+// authenticateAdmin resolves an admin key and checks that it has at least
+// the given role. Errors are ready to be returned from a handler.
+func (h *ApiHandler) authenticateAdmin(token string, role Role) (*APIKey, error) {
+	k, err := h.store.AuthenticateAPIKey(token)
+	return checkRole(k, err, role)
+}
+
+// authenticateSession is authenticateAdmin for a dashboard session id.
+func (h *ApiHandler) authenticateSession(id string, role Role) (*APIKey, error) {
+	k, err := h.store.AuthenticateDashboardSession(id)
+	return checkRole(k, err, role)
+}
+
+// checkRole turns the result of a key lookup into a handler error unless
+// the key has at least the given role.
+func checkRole(k *APIKey, err error, role Role) (*APIKey, error) {
+	if errors.Is(err, ErrInvalidAPIKey) {
+		return nil, fiber.NewError(http.StatusUnauthorized, "invalid or missing token")
+	}
+	if err != nil {
+		slog.Error("could not authenticate api key", "err", err)
+		return nil, err
+	}
+	if !k.Role.allows(role) {
+		return nil, fiber.NewError(http.StatusForbidden, fmt.Sprintf("requires the %s role", role))
+	}
+	return k, nil
+}
+
+// authenticateSubmission checks an optional project key on a feedback
+// submission. Without an Authorization header the request is treated as a
+// browser submission and nil is returned.
+func (h *ApiHandler) authenticateSubmission(c *fiber.Ctx, project *Project) (*APIKey, error) {
+	if c.Get(fiber.HeaderAuthorization) == "" {
+		return nil, nil
+	}
+	token, ok := bearerToken(c)
+	if !ok {
+		return nil, fiber.NewError(http.StatusUnauthorized, "invalid or missing token")
+	}
+	k, err := h.store.AuthenticateAPIKey(token)
+	if errors.Is(err, ErrInvalidAPIKey) {
+		return nil, fiber.NewError(http.StatusUnauthorized, "invalid or missing token")
+	}
+	if err != nil {
+		slog.Error("could not authenticate api key", "err", err)
+		return nil, err
+	}
+	if k.Project != project.Slug {
+		return nil, fiber.NewError(http.StatusForbidden, "key is not valid for this project")
+	}
+	return k, nil
+}
+
+// createAPIKeyRequest is the body of POST /api/admin/keys.
+type createAPIKeyRequest struct {
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	Role    Role   `json:"role"`
+}
+
+// createdAPIKey is the answer to POST /api/admin/keys. Key is never shown
+// again.
+type createdAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+func (h *ApiHandler) createAPIKeyRequest(c *fiber.Ctx) error {
+	var req createAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(http.StatusBadRequest, "invalid key: "+err.Error())
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return fiber.NewError(http.StatusUnprocessableEntity, "name is required")
+	}
+	if (req.Project == "") == (req.Role == "") {
+		return fiber.NewError(http.StatusUnprocessableEntity, "set either project or role")
+	}
+	if req.Role != "" && !req.Role.valid() {
+		return fiber.NewError(http.StatusUnprocessableEntity, fmt.Sprintf("unknown role %q", req.Role))
+	}
+	if req.Project != "" && h.projects.Get(req.Project) == nil {
+		return fiber.NewError(http.StatusUnprocessableEntity, fmt.Sprintf("unknown project %q", req.Project))
+	}
+
+	k := APIKey{Name: req.Name, Project: req.Project, Role: req.Role}
+	if p := principal(c); p != nil {
+		k.CreatedBy = p.Name
+	}
+	key, err := h.store.CreateAPIKey(&k)
+	if err != nil {
+		slog.Error("could not create api key", "err", err)
+		return err
+	}
+	slog.Info("created api key", "key", k.Prefix, "name", k.Name, "project", k.Project, "role", k.Role, "by", k.CreatedBy)
+	return c.Status(http.StatusCreated).JSON(createdAPIKey{APIKey: k, Key: key})
+}
+
+func (h *ApiHandler) listAPIKeysRequest(c *fiber.Ctx) error {
+	keys, err := h.store.ListAPIKeys()
+	if err != nil {
+		slog.Error("could not list api keys", "err", err)
+		return err
+	}
+	return c.JSON(keys)
+}
+
+func (h *ApiHandler) revokeAPIKeyRequest(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, "invalid id")
+	}
+	if p := principal(c); p != nil && p.ID == uint(id) {
+		return fiber.NewError(http.StatusConflict, "a key can't revoke itself")
+	}
+	err = h.store.RevokeAPIKey(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fiber.NewError(http.StatusNotFound, "key not found")
+	}
+	if err != nil {
+		slog.Error("could not revoke api key", "id", id, "err", err)
+		return err
+	}
+	slog.Info("revoked api key", "id", id)
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// bootstrapAdminKey mints the first admin key. It refuses when an active
+// admin key already exists unless force is set, so it is safe to leave in
+// a deploy script.
+func bootstrapAdminKey(db APIKeyStore, name string, force bool) (string, error) {
+	n, err := db.CountActiveAdminKeys()
+	if err != nil {
+		return "", err
+	}
+	if n > 0 && !force {
+		return "", fmt.Errorf("%d active admin key(s) exist already; use -force to mint another", n)
+	}
+	return db.CreateAPIKey(&APIKey{Name: name, Role: RoleAdmin, CreatedBy: "bootstrap"})
+}
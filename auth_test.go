@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRoleAllows(t *testing.T) {
+	for _, tc := range []struct {
+		have, need Role
+		want       bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleTriager, false},
+		{RoleTriager, RoleViewer, true},
+		{RoleTriager, RoleAdmin, false},
+		{RoleAdmin, RoleTriager, true},
+		{"", RoleViewer, false},
+		{"root", RoleViewer, false},
+	} {
+		if got := tc.have.allows(tc.need); got != tc.want {
+			t.Errorf("%q allows %q: expected %v", tc.have, tc.need, tc.want)
+		}
+	}
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, hash, err := generateAPIKey(adminKeyPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, adminKeyPrefix) {
+		t.Errorf("key %q lacks prefix", key)
+	}
+	if hash != hashAPIKey(key) || strings.Contains(hash, key) {
+		t.Error("hash must be derived from, and not contain, the key")
+	}
+	other, _, _ := generateAPIKey(adminKeyPrefix)
+	if other == key {
+		t.Error("keys must be random")
+	}
+	if p := keyDisplayPrefix(key); len(p) != 12 || !strings.HasPrefix(key, p) {
+		t.Errorf("unexpected display prefix %q", p)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		token, ok := bearerToken(c)
+		if !ok {
+			return c.SendStatus(401)
+		}
+		return c.SendString(token)
+	})
+	for header, want := range map[string]int{
+		"":             401,
+		"Bearer ":      401,
+		"Basic abc":    401,
+		"Bearer fba_x": 200,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("header %q: expected %d, got %d", header, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestSubmissionWithoutKeySkipsAuth(t *testing.T) {
+	// no Authorization header means a browser submission; the database is
+	// not touched
+	h := &ApiHandler{}
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		k, err := h.authenticateSubmission(c, &Project{Slug: "sky"})
+		if err != nil {
+			return err
+		}
+		if k != nil {
+			t.Error("expected no key")
+		}
+		return c.SendStatus(204)
+	})
+	resp, err := app.Test(httptest.NewRequest("POST", "/", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 204 {
+		t.Errorf("expected 204, got %d", resp.StatusCode)
+	}
+}
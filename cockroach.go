@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -24,73 +27,338 @@ type Feedback struct {
 	gorm.Model
 	Feedback               string    `json:"feedback"`
 	AdditionalInformations string    `json:"additionalInformations"`
-	User                   string    `json:"user"`
-	Context                string    `json:"context"`
-	FeedbackName           string    `json:"fedbackName"`
-	Timestamp              time.Time `json:"timestamp"`
+	User                   string    `json:"user" gorm:"index"`
+	Context                string    `json:"context" gorm:"index"`
+	FeedbackName           string    `json:"fedbackName" gorm:"index"`
+	Timestamp              time.Time `json:"timestamp" gorm:"index"`
+	Project                string    `json:"project" gorm:"index"`
+	// Payload is the parsed Feedback string, queryable as JSONB.
+	Payload JSONB `json:"payload"`
+
+	// triage state, see triage.go
+	TriageState
+
+	// near-duplicate cluster, see issues.go
+	IssueID *uint `json:"issueId" gorm:"index"`
+
+	// deduplication, see dedup.go
+	ContentHash     string     `json:"contentHash" gorm:"index"`
+	DuplicateCount  int        `json:"duplicateCount" gorm:"default:0"`
+	LastDuplicateAt *time.Time `json:"lastDuplicateAt"`
+
+	// spam verdict, see SpamPolicy
+	Spam        bool   `json:"spam" gorm:"index"`
+	SpamScore   int    `json:"spamScore"`
+	SpamReasons string `json:"spamReasons"`
+
+	// number of values the redaction policy removed, see redact.go
+	Redactions int `json:"redactions"`
+
+	// set once the retention policy removed personal data, see retention.go
+	StrippedAt *time.Time `json:"strippedAt" gorm:"index"`
+
+	// full-text search, see search.go
+	SearchLanguage string       `json:"-" gorm:"index"`
+	SearchVector   SearchVector `json:"-" gorm:"->:false;<-"`
 }
 
 type DatabaseHandler struct {
-	db *gorm.DB
+	db              *gorm.DB
+	driver          string
+	dsn             string
+	dedupWindow     time.Duration
+	issueWindow     time.Duration
+	issueSimilarity float64
+	migrate         string
 }
 
-// ErrDuplicateFeedback is returned when the last stored feedback matches the
-// incoming one and should not be saved or forwarded again.
+// ErrDuplicateFeedback is returned when the same sender submitted identical
+// feedback within the dedup window and it should not be saved or forwarded
+// again.
 var ErrDuplicateFeedback = errors.New("duplicate feedback")
 
-func NewDatabaseHandler() *DatabaseHandler {
-	d := &DatabaseHandler{}
+func NewDatabaseHandler(cfg *Config) *DatabaseHandler {
+	d := &DatabaseHandler{
+		driver:          cfg.Database.Driver,
+		dsn:             cfg.Database.URL,
+		dedupWindow:     cfg.Dedup.Window,
+		issueWindow:     cfg.Issues.Window,
+		issueSimilarity: cfg.Issues.Similarity,
+		migrate:         cfg.Database.Migrate,
+	}
 	return d
 }
 
+// Connect opens the database and, depending on the migrate mode, migrates
+// it or checks that it is migrated, see migrate.go.
 func (d *DatabaseHandler) Connect() error {
-	slog.Debug("connecting to the database..")
+	if err := d.open(); err != nil {
+		return err
+	}
+	return d.prepareSchema()
+}
+
+// open connects without looking at the schema.
+func (d *DatabaseHandler) open() error {
+	slog.Debug("connecting to the database..", "driver", d.driver)
 
-	db, err := gorm.Open(postgres.Open(d.dsnString()))
+	dialector := postgres.Open(d.dsn)
+	switch d.driver {
+	case driverSQLite:
+		dialector = sqliteDialector(d.dsn)
+	case driverMemory:
+		dialector = sqliteDialector("")
+	}
+	db, err := gorm.Open(dialector)
 	if err != nil {
 		return err
 	}
+	if isSQLite(db) {
+		// SQLite allows one writer at a time, and an in-memory database
+		// only exists on its connection
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
 
+	if err := db.Use(dbMetrics{}); err != nil {
+		return err
+	}
+	if err := db.Use(dbTracing{}); err != nil {
+		return err
+	}
 	d.db = db
-
-	return d.migrations()
+	return nil
 }
 
-func (d *DatabaseHandler) migrations() error {
-	err := d.db.AutoMigrate(&Feedback{})
+// Close closes the connection pool, waiting for running statements.
+func (d *DatabaseHandler) Close() error {
+	sqlDB, err := d.db.DB()
 	if err != nil {
 		return err
 	}
+	return sqlDB.Close()
+}
+
+// SaveFeedback stores the feedback together with its pending notifications
+// and the groups of its errors in one transaction. If identical content from the same sender was stored
+// within the dedup window, nothing is stored, the duplicate counter of the
+// original is incremented and ErrDuplicateFeedback is returned.
+func (d *DatabaseHandler) SaveFeedback(ctx context.Context, f *Feedback, notifications []OutboxMessage, errs *ErrorCapture) error {
+	ctx, span := tracer.Start(ctx, "feedback.save", trace.WithAttributes(attribute.String("feedback.project", f.Project)))
+	defer span.End()
+
+	// the outbox worker continues the submission's trace
+	traceparent := traceContextOf(ctx)
+	for i := range notifications {
+		notifications[i].TraceContext = traceparent
+	}
+	if errs != nil {
+		for i := range errs.Alerts {
+			errs.Alerts[i].TraceContext = traceparent
+		}
+	}
+
+	f.ContentHash = feedbackContentHash(f)
+	f.indexForSearch()
+	duplicate := false
+	issueResult := ""
+	newErrorGroups := 0
+
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if d.dedupWindow > 0 {
+			originalID, claimed, err := claimDedupKey(tx, f.ContentHash, d.dedupWindow, now)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				duplicate = true
+				slog.Debug("detected duplicate feedback; skipping save", "original", originalID)
+				// UpdateColumns leaves updated_at alone, triage guards on it
+				return tx.Model(&Feedback{}).Where("id = ?", originalID).UpdateColumns(map[string]interface{}{
+					"duplicate_count":   gorm.Expr("duplicate_count + 1"),
+					"last_duplicate_at": now,
+				}).Error
+			}
+		}
+
+		// spam would only bury real issues
+		if d.issueWindow > 0 && !f.Spam {
+			var err error
+			issueResult, err = d.assignIssue(tx, f, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		res := tx.Create(f)
+		if res.Error != nil {
+			return res.Error
+		}
+
+		if d.dedupWindow > 0 {
+			res = tx.Model(&FeedbackDedupKey{}).Where("hash = ?", f.ContentHash).Update("feedback_id", f.ID)
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+
+		if len(notifications) > 0 {
+			for i := range notifications {
+				notifications[i].FeedbackID = f.ID
+			}
+			res = tx.Create(&notifications)
+			if res.Error != nil {
+				return res.Error
+			}
+		}
+
+		if errs != nil {
+			var err error
+			newErrorGroups, err = recordErrors(tx, f, errs, now)
+			if err != nil {
+				return err
+			}
+		}
 
+		slog.Debug(fmt.Sprintf("Inserted feedback with id %d", f.ID))
+		return nil
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetAttributes(attribute.Bool("feedback.duplicate", duplicate))
+	if duplicate {
+		return ErrDuplicateFeedback
+	}
+	if issueResult != "" {
+		issueAssignmentsCounter.WithLabelValues(f.Project, issueResult).Inc()
+	}
+	if errs != nil {
+		errorReportsCounter.WithLabelValues(f.Project).Add(float64(len(errs.Reports)))
+		newErrorGroupsCounter.WithLabelValues(f.Project).Add(float64(newErrorGroups))
+	}
 	return nil
 }
 
-func (d *DatabaseHandler) SaveFeedback(f *Feedback) error {
-	// Try to load the most recent feedback and compare. If identical, skip.
-	var last Feedback
-	res := d.db.Order("created_at desc").First(&last)
-	if res.Error == nil {
-		if last.Feedback == f.Feedback && last.AdditionalInformations == f.AdditionalInformations {
-			slog.Debug("detected duplicate feedback; skipping save")
-			return ErrDuplicateFeedback
-		}
-	} else if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
-		return res.Error
+// ListFeedback returns one page of feedback matching the filter, newest first.
+func (d *DatabaseHandler) ListFeedback(filter *FeedbackFilter) (*FeedbackPage, error) {
+	q := filter.apply(d.db.Model(&Feedback{}))
+	if filter.Cursor != nil {
+		q = q.Where("(created_at, id) < (?, ?)", filter.Cursor.CreatedAt, filter.Cursor.ID)
 	}
 
-	res = d.db.Create(f)
+	// fetch one extra row to know whether another page follows
+	var items []Feedback
+	res := q.Order("created_at desc, id desc").Limit(filter.Limit + 1).Find(&items)
 	if res.Error != nil {
-		return res.Error
+		return nil, res.Error
 	}
 
-	slog.Debug(fmt.Sprintf("Inserted feedback with id %d", f.ID))
-	return nil
+	page := &FeedbackPage{Items: items}
+	if len(items) > filter.Limit {
+		page.Items = items[:filter.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = feedbackCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+	return page, nil
 }
 
-func (d *DatabaseHandler) dsnString() string {
-	v := os.Getenv("COCKROACH_CONNECTION")
-	if v == "" {
-		panic("COCKROACH_CONNECTION is not set")
+// apply adds the filter's conditions, everything but cursor and limit, to a
+// feedback query.
+func (filter *FeedbackFilter) apply(q *gorm.DB) *gorm.DB {
+	if filter.Project != "" {
+		q = q.Where("project = ?", filter.Project)
+	}
+	if filter.Status != "" {
+		q = q.Where("status = ?", filter.Status)
+	}
+	if filter.Spam != nil {
+		q = q.Where("spam = ?", *filter.Spam)
+	}
+	if filter.Assignee != "" {
+		q = q.Where("assignee = ?", filter.Assignee)
+	}
+	if filter.Context != "" {
+		q = q.Where("context = ?", filter.Context)
+	}
+	if filter.FeedbackName != "" {
+		q = q.Where("feedback_name = ?", filter.FeedbackName)
+	}
+	if filter.User != "" {
+		q = q.Where("\"user\" = ?", filter.User)
+	}
+	if filter.IssueID != 0 {
+		q = q.Where("issue_id = ?", filter.IssueID)
+	}
+	if filter.ErrorGroupID != 0 {
+		q = q.Where("id IN (SELECT feedback_id FROM error_occurrences WHERE error_group_id = ?)", filter.ErrorGroupID)
+	}
+	if !filter.TimestampFrom.IsZero() {
+		q = q.Where("timestamp >= ?", filter.TimestampFrom)
+	}
+	if !filter.TimestampTo.IsZero() {
+		q = q.Where("timestamp < ?", filter.TimestampTo)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		q = q.Where("created_at >= ?", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		q = q.Where("created_at < ?", filter.CreatedTo)
+	}
+	for _, pf := range filter.Payload {
+		q = pf.apply(q)
+	}
+	return q
+}
+
+// GetFeedback loads a single feedback row. It returns gorm.ErrRecordNotFound
+// when no row with that id exists.
+func (d *DatabaseHandler) GetFeedback(id uint) (*Feedback, error) {
+	var f Feedback
+	res := d.db.First(&f, id)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return &f, nil
+}
+
+// UpdateFeedbackTriage applies a triage update to a feedback row. The update
+// only succeeds if the row wasn't written since the client read it, as told
+// by update.UpdatedAt, or else since it was read here. Without UpdatedAt a
+// client can still overwrite a change it never saw.
+func (d *DatabaseHandler) UpdateFeedbackTriage(id uint, update *TriageUpdate) (*Feedback, error) {
+	f, err := d.GetFeedback(id)
+	if err != nil {
+		return nil, err
+	}
+	if update.stale(f.UpdatedAt) {
+		return nil, ErrConcurrentUpdate
+	}
+	previous := f.UpdatedAt
+	now := time.Now()
+
+	changes, err := update.apply(&f.TriageState, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return f, nil
+	}
+	changes["updated_at"] = now
+	f.UpdatedAt = now
+
+	res := d.db.Model(&Feedback{}).Where("id = ? AND updated_at = ?", id, previous).Updates(changes)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrConcurrentUpdate
 	}
-	return v
+	return f, nil
 }
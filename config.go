@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server. Values come from the defaults,
+// then the optional YAML or TOML file named by CONFIG_FILE, then env vars.
+// Every env var can instead be given as a file path in <NAME>_FILE, which is
+// how Docker and Kubernetes mount secrets.
+//
+// Fields are bound by their env tag; secret fields are redacted when the
+// configuration is printed.
+type Config struct {
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+	Dedup     DedupConfig     `yaml:"dedup" toml:"dedup"`
+	Issues    IssuesConfig    `yaml:"issues" toml:"issues"`
+	Outbox    OutboxConfig    `yaml:"outbox" toml:"outbox"`
+	Retention RetentionConfig `yaml:"retention" toml:"retention"`
+	Contact   ContactConfig   `yaml:"contact" toml:"contact"`
+
+	ProjectsFile string `yaml:"projectsFile" toml:"projectsFile" env:"PROJECTS_FILE"`
+	// WebhookURL notifies about feedback of the built-in projects and is
+	// the contact form's fallback.
+	WebhookURL       string `yaml:"webhookUrl" toml:"webhookUrl" env:"WEBHOOK_URL" secret:"true"`
+	RedactionHashKey string `yaml:"redactionHashKey" toml:"redactionHashKey" env:"REDACTION_HASH_KEY" secret:"true"`
+}
+
+type DatabaseConfig struct {
+	// Driver is postgres, sqlite or memory, see the driver constants.
+	Driver string `yaml:"driver" toml:"driver" env:"DATABASE_DRIVER"`
+	// URL is a postgres connection string or URL, or the SQLite file.
+	URL string `yaml:"url" toml:"url" env:"COCKROACH_CONNECTION" secret:"true"`
+	// Migrate is check or up, what to do about pending migrations on start.
+	Migrate string `yaml:"migrate" toml:"migrate" env:"DATABASE_MIGRATE"`
+}
+
+type HTTPConfig struct {
+	Addr        string `yaml:"addr" toml:"addr" env:"API_ADDR"`
+	MetricsAddr string `yaml:"metricsAddr" toml:"metricsAddr" env:"METRICS_ADDR"`
+	// AllowedOrigins is the CORS allow list of projects that don't declare
+	// their own.
+	AllowedOrigins  []string      `yaml:"allowedOrigins" toml:"allowedOrigins" env:"CORS_ORIGINS"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" toml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+type DedupConfig struct {
+	// Window 0 disables deduplication.
+	Window time.Duration `yaml:"window" toml:"window" env:"DEDUP_WINDOW"`
+}
+
+type IssuesConfig struct {
+	// Window 0 disables clustering.
+	Window     time.Duration `yaml:"window" toml:"window" env:"ISSUE_WINDOW"`
+	Similarity float64       `yaml:"similarity" toml:"similarity" env:"ISSUE_SIMILARITY"`
+}
+
+type OutboxConfig struct {
+	Workers     int `yaml:"workers" toml:"workers" env:"OUTBOX_WORKERS"`
+	MaxAttempts int `yaml:"maxAttempts" toml:"maxAttempts" env:"OUTBOX_MAX_ATTEMPTS"`
+}
+
+type RetentionConfig struct {
+	Interval   time.Duration `yaml:"interval" toml:"interval" env:"RETENTION_INTERVAL"`
+	PurgeAfter time.Duration `yaml:"purgeAfter" toml:"purgeAfter" env:"RETENTION_PURGE_AFTER"`
+}
+
+type ContactConfig struct {
+	WebhookURL    string `yaml:"webhookUrl" toml:"webhookUrl" env:"CONTACT_WEBHOOK_URL" secret:"true"`
+	Project       string `yaml:"project" toml:"project" env:"CONTACT_PROJECT"`
+	PowDifficulty int    `yaml:"powDifficulty" toml:"powDifficulty" env:"CONTACT_POW_DIFFICULTY"`
+	// ChallengeSecret signs challenges; empty means a random one per start.
+	ChallengeSecret string   `yaml:"challengeSecret" toml:"challengeSecret" env:"CONTACT_CHALLENGE_SECRET" secret:"true"`
+	Blocklist       []string `yaml:"blocklist" toml:"blocklist" env:"CONTACT_BLOCKLIST"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Database: DatabaseConfig{Driver: driverPostgres, Migrate: migrateCheck},
+		HTTP: HTTPConfig{
+			Addr:            ":3000",
+			MetricsAddr:     ":2112",
+			AllowedOrigins:  defaultAllowedOrigins,
+			ShutdownTimeout: defaultShutdownTimeout,
+		},
+		Dedup:     DedupConfig{Window: defaultDedupWindow},
+		Issues:    IssuesConfig{Window: defaultIssueWindow, Similarity: defaultIssueSimilarity},
+		Outbox:    OutboxConfig{Workers: 4, MaxAttempts: 10},
+		Retention: RetentionConfig{Interval: defaultRetentionInterval, PurgeAfter: defaultPurgeAfter},
+		Contact:   ContactConfig{PowDifficulty: 4},
+	}
+}
+
+// LoadConfig reads and validates the configuration. On errors it still
+// returns what it could read, so config check can show it.
+func LoadConfig() (*Config, error) {
+	cfg := defaultConfig()
+	path, err := readEnv("CONFIG_FILE")
+	if err != nil {
+		return cfg, err
+	}
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return cfg, err
+		}
+	}
+	errs := cfg.readEnv()
+	errs = append(errs, cfg.validate()...)
+	return cfg, errors.Join(errs...)
+}
+
+// readFile decodes a YAML or TOML file, by its extension, over cfg. Unknown
+// keys are errors, a typo shouldn't silently leave the default in place.
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), c)
+		if err != nil {
+			return fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing config file %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("config file %s: unknown extension %q, use .yaml, .yml or .toml", path, ext)
+	}
+	return nil
+}
+
+// readEnv overrides fields with the env vars that are set.
+func (c *Config) readEnv() []error {
+	var errs []error
+	eachConfigField(reflect.ValueOf(c).Elem(), "", func(f configField) {
+		v, err := readEnv(f.env)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if v == "" {
+			return
+		}
+		if err := setConfigValue(f.value, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", f.env, f.key, err))
+		}
+	})
+	return errs
+}
+
+// readEnv returns the env var name or the content of the file named by
+// name_FILE, without a trailing newline.
+func readEnv(name string) (string, error) {
+	v := os.Getenv(name)
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return v, nil
+	}
+	if v != "" {
+		return "", fmt.Errorf("%s and %s_FILE are both set, use one", name, name)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// configField is a leaf of Config that can be set from an env var.
+type configField struct {
+	// key is the dotted path in the config file, e.g. dedup.window.
+	key    string
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+func eachConfigField(v reflect.Value, prefix string, fn func(configField)) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		key := prefix + sf.Tag.Get("yaml")
+		if sf.Type.Kind() == reflect.Struct {
+			eachConfigField(v.Field(i), key+".", fn)
+			continue
+		}
+		fn(configField{key: key, env: sf.Tag.Get("env"), secret: sf.Tag.Get("secret") == "true", value: v.Field(i)})
+	}
+}
+
+func setConfigValue(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, use e.g. 90s or 12h", s)
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				list = append(list, p)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case string:
+		v.SetString(s)
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// validate reports every invalid value at once, named by env var and key.
+func (c *Config) validate() []error {
+	var errs []error
+	check := func(ok bool, env, key, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s (%s): %s", env, key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	switch c.Database.Driver {
+	case driverPostgres, driverSQLite:
+		check(c.Database.URL != "", "COCKROACH_CONNECTION", "database.url", "is required")
+	case driverMemory:
+	default:
+		check(false, "DATABASE_DRIVER", "database.driver", "unknown driver %q, use postgres, sqlite or memory", c.Database.Driver)
+	}
+	check(c.Database.Migrate == migrateCheck || c.Database.Migrate == migrateUp,
+		"DATABASE_MIGRATE", "database.migrate", "unknown mode %q, use check or up", c.Database.Migrate)
+	for _, a := range []struct{ env, key, addr string }{
+		{"API_ADDR", "http.addr", c.HTTP.Addr},
+		{"METRICS_ADDR", "http.metricsAddr", c.HTTP.MetricsAddr},
+	} {
+		_, port, err := net.SplitHostPort(a.addr)
+		check(err == nil && port != "", a.env, a.key, "%q is not a host:port address like :3000", a.addr)
+	}
+	check(c.HTTP.Addr != c.HTTP.MetricsAddr, "METRICS_ADDR", "http.metricsAddr", "must differ from the api address")
+	for _, o := range c.HTTP.AllowedOrigins {
+		u, err := url.Parse(o)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && (u.Path == "" || u.Path == "/"),
+			"CORS_ORIGINS", "http.allowedOrigins", "%q is not an origin like https://example.com", o)
+	}
+	check(c.HTTP.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT", "http.shutdownTimeout", "must be positive")
+
+	check(c.Dedup.Window >= 0, "DEDUP_WINDOW", "dedup.window", "must not be negative")
+	check(c.Issues.Window >= 0, "ISSUE_WINDOW", "issues.window", "must not be negative")
+	check(c.Issues.Similarity > 0 && c.Issues.Similarity <= 1, "ISSUE_SIMILARITY", "issues.similarity", "must be above 0 and at most 1")
+	check(c.Outbox.Workers > 0, "OUTBOX_WORKERS", "outbox.workers", "must be positive")
+	check(c.Outbox.MaxAttempts > 0, "OUTBOX_MAX_ATTEMPTS", "outbox.maxAttempts", "must be positive")
+	check(c.Retention.Interval > 0, "RETENTION_INTERVAL", "retention.interval", "must be positive")
+	check(c.Retention.PurgeAfter >= 0, "RETENTION_PURGE_AFTER", "retention.purgeAfter", "must not be negative")
+	check(c.Contact.PowDifficulty >= 1 && c.Contact.PowDifficulty <= 8, "CONTACT_POW_DIFFICULTY", "contact.powDifficulty", "must be between 1 and 8")
+
+	for _, w := range []struct{ env, key, url string }{
+		{"WEBHOOK_URL", "webhookUrl", c.WebhookURL},
+		{"CONTACT_WEBHOOK_URL", "contact.webhookUrl", c.Contact.WebhookURL},
+	} {
+		if w.url == "" {
+			continue
+		}
+		// the url is a secret, don't echo it
+		u, err := url.Parse(w.url)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", w.env, w.key, "is not an http(s) url")
+	}
+	if c.ProjectsFile != "" {
+		_, err := os.Stat(c.ProjectsFile)
+		check(err == nil, "PROJECTS_FILE", "projectsFile", "%v", err)
+	}
+	return errs
+}
+
+// contactWebhookURL is where contact form messages go when no contact
+// project is configured.
+func (c *Config) contactWebhookURL() string {
+	if c.Contact.WebhookURL != "" {
+		return c.Contact.WebhookURL
+	}
+	return c.WebhookURL
+}
+
+// apply installs the settings that live in package state rather than in a
+// handler: the redaction hash key and the contact blocklist.
+func (c *Config) apply() {
+	if c.RedactionHashKey == "" {
+		slog.Warn("REDACTION_HASH_KEY not set; redaction hashes are unkeyed and can be reversed by guessing")
+	}
+	redactionHashKey = []byte(c.RedactionHashKey)
+
+	extraBlocklist = nil
+	for _, p := range c.Contact.Blocklist {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			extraBlocklist = append(extraBlocklist, p)
+		}
+	}
+}
+
+// Redacted returns a copy of c with the secrets that are set replaced.
+func (c *Config) Redacted() *Config {
+	r := *c
+	eachConfigField(reflect.ValueOf(&r).Elem(), "", func(f configField) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString("[redacted]")
+		}
+	})
+	return &r
+}
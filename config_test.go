@@ -0,0 +1,217 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearConfigEnv unsets every config env var, so the tests don't depend on
+// the environment they run in.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	eachConfigField(reflect.ValueOf(defaultConfig()).Elem(), "", func(f configField) {
+		t.Setenv(f.env, "")
+		t.Setenv(f.env+"_FILE", "")
+	})
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("COCKROACH_CONNECTION", "postgres://localhost/feedback")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTP.Addr != ":3000" || cfg.HTTP.MetricsAddr != ":2112" {
+		t.Errorf("addresses = %q, %q", cfg.HTTP.Addr, cfg.HTTP.MetricsAddr)
+	}
+	if cfg.Dedup.Window != defaultDedupWindow || cfg.Outbox.Workers != 4 || cfg.Contact.PowDifficulty != 4 {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.HTTP.AllowedOrigins, defaultAllowedOrigins) {
+		t.Errorf("origins = %v", cfg.HTTP.AllowedOrigins)
+	}
+}
+
+func TestLoadConfigDedupWindow(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("COCKROACH_CONNECTION", "postgres://localhost/feedback")
+
+	for value, want := range map[string]time.Duration{"1h": time.Hour, "0": 0} {
+		t.Setenv("DEDUP_WINDOW", value)
+		cfg, err := LoadConfig()
+		if err != nil || cfg.Dedup.Window != want {
+			t.Errorf("DEDUP_WINDOW=%s: got %s, %v", value, cfg.Dedup.Window, err)
+		}
+	}
+
+	t.Setenv("DEDUP_WINDOW", "soon")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "DEDUP_WINDOW (dedup.window): invalid duration") {
+		t.Errorf("expected an error naming DEDUP_WINDOW, got %v", err)
+	}
+}
+
+func TestLoadConfigFileAndEnv(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "feedback.yaml", `
+database:
+  url: postgres://file/feedback
+http:
+  addr: ":8080"
+  allowedOrigins: [https://example.com]
+dedup:
+  window: 1h
+outbox:
+  workers: 8
+contact:
+  blocklist: [casino, crypto]
+`))
+	// env vars win over the file
+	t.Setenv("OUTBOX_WORKERS", "2")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.URL != "postgres://file/feedback" || cfg.HTTP.Addr != ":8080" || cfg.Dedup.Window != time.Hour {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.Outbox.Workers != 2 {
+		t.Errorf("workers = %d, want the env value 2", cfg.Outbox.Workers)
+	}
+	if !reflect.DeepEqual(cfg.HTTP.AllowedOrigins, []string{"https://example.com"}) || len(cfg.Contact.Blocklist) != 2 {
+		t.Errorf("lists not applied: %v, %v", cfg.HTTP.AllowedOrigins, cfg.Contact.Blocklist)
+	}
+	// untouched values keep their defaults
+	if cfg.HTTP.MetricsAddr != ":2112" || cfg.Outbox.MaxAttempts != 10 {
+		t.Errorf("defaults lost: %+v", cfg)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "feedback.toml", `
+[database]
+url = "postgres://toml/feedback"
+
+[retention]
+interval = "30m"
+`))
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.URL != "postgres://toml/feedback" || cfg.Retention.Interval != 30*time.Minute {
+		t.Errorf("toml values not applied: %+v", cfg)
+	}
+}
+
+func TestLoadConfigUnknownKey(t *testing.T) {
+	clearConfigEnv(t)
+	for name, content := range map[string]string{
+		"feedback.yaml": "outbox:\n  wokers: 8\n",
+		"feedback.toml": "[outbox]\nwokers = 8\n",
+	} {
+		t.Setenv("CONFIG_FILE", writeConfigFile(t, name, content))
+		if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "wokers") {
+			t.Errorf("%s: expected an unknown key error, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfigSecretFile(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("COCKROACH_CONNECTION_FILE", writeConfigFile(t, "dsn", "postgres://secret/feedback\n"))
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.URL != "postgres://secret/feedback" {
+		t.Errorf("url = %q, want the file content without newline", cfg.Database.URL)
+	}
+
+	t.Setenv("COCKROACH_CONNECTION", "postgres://env/feedback")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "both set") {
+		t.Errorf("expected an error for both the var and its file, got %v", err)
+	}
+}
+
+func TestLoadConfigReportsEveryError(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("OUTBOX_WORKERS", "0")
+	t.Setenv("ISSUE_SIMILARITY", "2")
+	t.Setenv("METRICS_ADDR", ":3000")
+	t.Setenv("CORS_ORIGINS", "example.com")
+	t.Setenv("WEBHOOK_URL", "YOUR_WEBHOOK_URL_HERE")
+
+	_, err := LoadConfig()
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{"COCKROACH_CONNECTION", "OUTBOX_WORKERS", "ISSUE_SIMILARITY", "METRICS_ADDR", "CORS_ORIGINS", "WEBHOOK_URL"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %s: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "YOUR_WEBHOOK_URL_HERE") {
+		t.Error("errors must not echo secrets")
+	}
+}
+
+func TestLoadConfigDatabaseDriver(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("DATABASE_DRIVER", "memory")
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("the memory driver needs no url, got %v", err)
+	}
+
+	t.Setenv("DATABASE_DRIVER", "sqlite")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "COCKROACH_CONNECTION") {
+		t.Errorf("expected the sqlite driver to need a file, got %v", err)
+	}
+
+	t.Setenv("DATABASE_DRIVER", "mysql")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "DATABASE_DRIVER (database.driver): unknown driver") {
+		t.Errorf("expected an unknown driver error, got %v", err)
+	}
+
+	t.Setenv("DATABASE_DRIVER", "memory")
+	t.Setenv("DATABASE_MIGRATE", "always")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "DATABASE_MIGRATE (database.migrate): unknown mode") {
+		t.Errorf("expected an unknown migrate mode error, got %v", err)
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Database.URL = "postgres://user:pw@db/feedback"
+	cfg.Contact.ChallengeSecret = "s3cret"
+
+	r := cfg.Redacted()
+	if r.Database.URL != "[redacted]" || r.Contact.ChallengeSecret != "[redacted]" {
+		t.Errorf("secrets not redacted: %+v", r)
+	}
+	if r.WebhookURL != "" {
+		t.Error("unset secrets should stay empty so they show as unset")
+	}
+	if cfg.Database.URL != "postgres://user:pw@db/feedback" {
+		t.Error("Redacted modified the original")
+	}
+}
@@ -1,17 +1,16 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -22,6 +21,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
 )
 
 var (
@@ -55,10 +55,19 @@ type ContactHandler struct {
 
 	mu   sync.Mutex
 	used map[string]time.Time // solved challenge nonce-token -> expiry, replay guard
+
+	// notifiers receive accepted messages. When empty, messages go to the
+	// Discord webhook webhookURL, from CONTACT_WEBHOOK_URL / WEBHOOK_URL.
+	notifiers  []Notifier
+	webhookURL string
+
+	// store keeps accepted messages for the admin dashboard, nil disables
+	// storing them.
+	store ContactMessageStore
 }
 
-func NewContactHandler() *ContactHandler {
-	secret := []byte(os.Getenv("CONTACT_CHALLENGE_SECRET"))
+func NewContactHandler(store ContactMessageStore, notifiers []Notifier, cfg *Config) *ContactHandler {
+	secret := []byte(cfg.Contact.ChallengeSecret)
 	if len(secret) == 0 {
 		// No secret configured: generate an ephemeral one. Challenges won't
 		// survive a restart, but they expire within minutes anyway.
@@ -69,28 +78,29 @@ func NewContactHandler() *ContactHandler {
 		slog.Warn("CONTACT_CHALLENGE_SECRET not set; using an ephemeral random secret")
 	}
 
-	difficulty := 4
-	if v := os.Getenv("CONTACT_POW_DIFFICULTY"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 8 {
-			difficulty = n
-		}
-	}
-
 	h := &ContactHandler{
 		secret:     secret,
-		difficulty: difficulty,
+		difficulty: cfg.Contact.PowDifficulty,
 		used:       make(map[string]time.Time),
+		notifiers:  notifiers,
+		webhookURL: cfg.contactWebhookURL(),
+
+		store: store,
 	}
-	go h.cleanupLoop()
 	return h
 }
 
 // cleanupLoop periodically drops expired entries from the replay cache so it
-// doesn't grow without bound.
-func (h *ContactHandler) cleanupLoop() {
+// doesn't grow without bound, until ctx is cancelled.
+func (h *ContactHandler) cleanupLoop(ctx context.Context) {
 	ticker := time.NewTicker(contactChallengeTTL)
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		now := time.Now()
 		h.mu.Lock()
 		for k, exp := range h.used {
@@ -225,13 +235,23 @@ func (h *ContactHandler) postContact(c *fiber.Ctx) error {
 
 	// Layer 3: content blacklists / spam scoring. A human won't trip this, so
 	// like the honeypot it is dropped silently rather than surfaced.
-	if score, why := spamScore(name, email, message); score >= spamRejectThreshold {
+	score, why := spamScore(name, email, message)
+	contactSpamScore.Observe(float64(score))
+	if score >= spamRejectThreshold {
 		return h.dropSilent(c, "blacklist", fmt.Sprintf("spam score %d: %s", score, why))
 	}
 
-	if err := sendContactToDiscord(name, email, message); err != nil {
-		slog.Error("sending contact message to discord failed", "err", err)
-		errorsCounter.Inc()
+	submission := &ContactSubmission{Name: name, Email: email, Message: message}
+	deliverErr := h.deliver(c.UserContext(), submission)
+	if h.store != nil {
+		// a failed store must not lose the message, it was delivered already
+		if err := h.store.SaveContactMessage(submission, deliverErr == nil); err != nil {
+			slog.Error("could not store contact message", "err", err)
+		}
+	}
+	if err := deliverErr; err != nil {
+		slog.Error("sending contact message failed", "err", err)
+		errorsCounter.WithLabelValues("").Inc()
 		return fiber.NewError(http.StatusInternalServerError, "could not deliver message")
 	}
 
@@ -248,43 +268,67 @@ func looksLikeEmail(s string) bool {
 	return emailRegex.MatchString(s)
 }
 
-func sendContactToDiscord(name, email, message string) error {
-	webhookURL := os.Getenv("CONTACT_WEBHOOK_URL")
-	if webhookURL == "" {
-		webhookURL = os.Getenv("WEBHOOK_URL")
-	}
-	if webhookURL == "" || webhookURL == "YOUR_WEBHOOK_URL_HERE" {
-		return fmt.Errorf("no contact webhook configured (set CONTACT_WEBHOOK_URL)")
+// deliver forwards an accepted message to every notifier. It fails if any
+// of them fails, so the client can retry.
+func (h *ContactHandler) deliver(ctx context.Context, submission *ContactSubmission) error {
+	notifiers := h.notifiers
+	if len(notifiers) == 0 {
+		if h.webhookURL == "" {
+			return fmt.Errorf("no contact webhook configured (set CONTACT_WEBHOOK_URL)")
+		}
+		notifiers = []Notifier{instrument(&DiscordNotifier{WebhookURL: h.webhookURL}, "discord")}
 	}
 
-	// Keep the historical "<name> <email>: <message>" format.
-	content := fmt.Sprintf("%s %s: %s", name, email, message)
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
 
-	payload := map[string]interface{}{
-		"content": content,
-		// Never let a submitted @everyone/@here or role mention fire.
-		"allowed_mentions": map[string]interface{}{"parse": []string{}},
-	}
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("error creating JSON payload: %w", err)
+	n := contactNotification(submission)
+	var errs []error
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(ctx, n); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return fmt.Errorf("error creating HTTP request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
+// ContactMessage is a stored contact form submission. Only messages that
+// passed the spam layers are kept.
+type ContactMessage struct {
+	gorm.Model
+	Name      string `json:"name"`
+	Email     string `json:"email" gorm:"index"`
+	Message   string `json:"message"`
+	Delivered bool   `json:"delivered"`
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("error sending HTTP request: %w", err)
+// SaveContactMessage stores an accepted submission. delivered records
+// whether the notifiers got it.
+func (d *DatabaseHandler) SaveContactMessage(c *ContactSubmission, delivered bool) error {
+	return d.db.Create(&ContactMessage{
+		Name:      c.Name,
+		Email:     c.Email,
+		Message:   c.Message,
+		Delivered: delivered,
+	}).Error
+}
+
+// ListContactMessages returns up to limit messages older than the id
+// before (0 for the newest), newest first.
+func (d *DatabaseHandler) ListContactMessages(before uint, limit int) ([]ContactMessage, error) {
+	q := d.db.Order("id desc").Limit(limit)
+	if before > 0 {
+		q = q.Where("id < ?", before)
 	}
-	defer resp.Body.Close()
+	var messages []ContactMessage
+	err := q.Find(&messages).Error
+	return messages, err
+}
 
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received unexpected status code: %s", resp.Status)
+func (d *DatabaseHandler) GetContactMessage(id uint) (*ContactMessage, error) {
+	var m ContactMessage
+	if err := d.db.First(&m, id).Error; err != nil {
+		return nil, err
 	}
-	return nil
+	return &m, nil
 }
@@ -91,9 +91,8 @@ func TestContactHappyPath(t *testing.T) {
 		w.WriteHeader(http.StatusNoContent)
 	}))
 	defer webhook.Close()
-	t.Setenv("CONTACT_WEBHOOK_URL", webhook.URL)
-
-	app, _ := newContactApp(t)
+	app, h := newContactApp(t)
+	h.webhookURL = webhook.URL
 	form := validSolvedForm(t, app, "Jane Doe", "jane@example.com", "Hi, I would love to work with you on the filter project.")
 	if code := postForm(t, app, form); code != 200 {
 		t.Fatalf("expected 200, got %d", code)
@@ -110,9 +109,8 @@ func TestContactSpamNeverReachesWebhook(t *testing.T) {
 		w.WriteHeader(http.StatusNoContent)
 	}))
 	defer webhook.Close()
-	t.Setenv("CONTACT_WEBHOOK_URL", webhook.URL)
-
-	app, _ := newContactApp(t)
+	app, h := newContactApp(t)
+	h.webhookURL = webhook.URL
 	form := validSolvedForm(t, app, "IsaacHoono", "myhrtsdrm60@gmail.com",
 		"IMPORTANT MESSAGE! WITHDRAW 1.3426 BTC https://qrlinkgenerator.com/kLrUG")
 	// even with a perfectly solved challenge, content blacklist drops it
@@ -146,9 +144,8 @@ func TestContactReplayRejected(t *testing.T) {
 		w.WriteHeader(http.StatusNoContent)
 	}))
 	defer webhook.Close()
-	t.Setenv("CONTACT_WEBHOOK_URL", webhook.URL)
-
-	app, _ := newContactApp(t)
+	app, h := newContactApp(t)
+	h.webhookURL = webhook.URL
 	form := validSolvedForm(t, app, "Jane Doe", "jane@example.com", "Legit message about a project idea.")
 	if code := postForm(t, app, form); code != 200 {
 		t.Fatalf("first submit expected 200, got %d", code)
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
+	"gorm.io/gorm"
+)
+
+// The admin dashboard is plain server rendered HTML. Templates and the
+// stylesheet are embedded so the binary has no runtime dependencies and the
+// pages load nothing from third parties.
+//
+//go:embed dashboard
+var dashboardFS embed.FS
+
+// dashboardCookie holds the session id of a signed in dashboard user, see
+// DashboardSession.
+const dashboardCookie = "feedback_admin"
+
+// dashboardSessionTTL is how long a dashboard sign in lasts.
+const dashboardSessionTTL = 12 * time.Hour
+
+// contactPageSize is the number of contact messages per dashboard page.
+const contactPageSize = 50
+
+var dashboardPages = parseDashboardPages()
+
+var dashboardFuncs = template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	},
+	"timePtr": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	},
+	"excerpt": func(s string, n int) string {
+		s = strings.Join(strings.Fields(s), " ")
+		if len([]rune(s)) <= n {
+			return s
+		}
+		return string([]rune(s)[:n]) + "…"
+	},
+}
+
+// parseDashboardPages pairs every page template with the shared layout.
+func parseDashboardPages() map[string]*template.Template {
+	layout := template.Must(template.New("layout.html").Funcs(dashboardFuncs).ParseFS(dashboardFS, "dashboard/templates/layout.html"))
+	pages := make(map[string]*template.Template)
+	for _, name := range []string{"login.html", "feedback_list.html", "feedback_detail.html", "contact_list.html", "contact_detail.html", "error.html"} {
+		pages[name] = template.Must(template.Must(layout.Clone()).ParseFS(dashboardFS, "dashboard/templates/"+name))
+	}
+	return pages
+}
+
+// dashboardView is what every page template receives.
+type dashboardView struct {
+	Title     string
+	Principal *APIKey
+	CSRF      string
+	Error     string
+	Data      interface{}
+}
+
+func (h *ApiHandler) registerDashboard(app *fiber.App) {
+	static, err := fs.Sub(dashboardFS, "dashboard/static")
+	if err != nil {
+		panic(err)
+	}
+	app.Use("/admin/static", filesystem.New(filesystem.Config{Root: http.FS(static), MaxAge: 3600}))
+
+	app.Get("/admin/login", h.dashboardLoginPage)
+	app.Post("/admin/login", h.dashboardLogin)
+	app.Post("/admin/logout", h.dashboardLogout)
+
+	viewer, triager := h.dashboardAuth(RoleViewer), h.dashboardAuth(RoleTriager)
+	app.Get("/admin", viewer, func(c *fiber.Ctx) error { return c.Redirect("/admin/feedback", http.StatusSeeOther) })
+	app.Get("/admin/feedback", viewer, h.dashboardFeedbackList)
+	app.Get("/admin/feedback/:id", viewer, h.dashboardFeedbackDetail)
+	app.Post("/admin/feedback/:id/triage", triager, h.dashboardTriage)
+	app.Post("/admin/feedback/:id/notes", triager, h.dashboardAddNote)
+	app.Get("/admin/contact", viewer, h.dashboardContactList)
+	app.Get("/admin/contact/:id", viewer, h.dashboardContactDetail)
+}
+
+// dashboardAuth is requireRole for browsers: the session comes from a
+// cookie set at sign in, unauthenticated visitors are sent to the sign in
+// page and every form post must carry the CSRF token of the session.
+func (h *ApiHandler) dashboardAuth(role Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		session := c.Cookies(dashboardCookie)
+		if session == "" {
+			return redirectToLogin(c)
+		}
+		k, err := h.authenticateSession(session, role)
+		var fe *fiber.Error
+		if errors.As(err, &fe) && fe.Code == http.StatusUnauthorized {
+			clearDashboardCookie(c)
+			return redirectToLogin(c)
+		}
+		if errors.As(err, &fe) && fe.Code == http.StatusForbidden {
+			return h.renderDashboard(c, http.StatusForbidden, "error.html", dashboardView{Title: "Forbidden", Error: fe.Message})
+		}
+		if err != nil {
+			return err
+		}
+		if c.Method() == fiber.MethodPost && !validCSRF(c, session) {
+			return fiber.NewError(http.StatusForbidden, "invalid csrf token")
+		}
+		c.Locals(principalKey, k)
+		c.Locals("csrf", csrfToken(session))
+		return c.Next()
+	}
+}
+
+// csrfToken derives the form token from the session id. It is tied to the
+// session and can't be computed by a page that doesn't know the id.
+func csrfToken(session string) string {
+	mac := hmac.New(sha256.New, []byte(session))
+	mac.Write([]byte("dashboard-csrf"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRF tells whether a form post carries the token of the session.
+func validCSRF(c *fiber.Ctx, session string) bool {
+	return subtle.ConstantTimeCompare([]byte(c.FormValue("csrf")), []byte(csrfToken(session))) == 1
+}
+
+// sameOrigin tells whether a form post was sent from a page of this host,
+// by its Origin or, without one, its Referer. Requests with neither, which
+// browsers don't send for form posts, e.g. from curl, pass.
+func sameOrigin(c *fiber.Ctx) bool {
+	source := c.Get(fiber.HeaderOrigin)
+	if source == "" {
+		source = c.Get(fiber.HeaderReferer)
+	}
+	if source == "" {
+		return true
+	}
+	// an opaque "null" origin has no host and fails
+	u, err := url.Parse(source)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, c.Hostname())
+}
+
+func redirectToLogin(c *fiber.Ctx) error {
+	if c.Method() != fiber.MethodGet {
+		return fiber.NewError(http.StatusUnauthorized, "not signed in")
+	}
+	return c.Redirect("/admin/login?next="+url.QueryEscape(c.OriginalURL()), http.StatusSeeOther)
+}
+
+func clearDashboardCookie(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{Name: dashboardCookie, Value: "", Path: "/admin", Expires: time.Unix(0, 0), HTTPOnly: true, SameSite: fiber.CookieSameSiteStrictMode})
+}
+
+// safeNext only allows redirects to dashboard pages after sign in.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/admin/") || strings.HasPrefix(next, "//") || strings.ContainsAny(next, "\\\r\n") {
+		return "/admin/feedback"
+	}
+	return next
+}
+
+func (h *ApiHandler) renderDashboard(c *fiber.Ctx, status int, page string, view dashboardView) error {
+	if view.Principal == nil {
+		view.Principal = principal(c)
+	}
+	if view.CSRF == "" {
+		view.CSRF, _ = c.Locals("csrf").(string)
+	}
+	var buf bytes.Buffer
+	if err := dashboardPages[page].ExecuteTemplate(&buf, "layout", view); err != nil {
+		slog.Error("could not render dashboard page", "page", page, "err", err)
+		return fiber.NewError(http.StatusInternalServerError, "could not render page")
+	}
+	// nothing on these pages should be cached, framed or scripted
+	c.Set("Content-Security-Policy", "default-src 'none'; style-src 'self'; img-src 'self'; form-action 'self'; frame-ancestors 'none'")
+	c.Set("X-Content-Type-Options", "nosniff")
+	// same-origin keeps the dashboard's urls from other sites but lets its
+	// own forms send their Origin, see sameOrigin
+	c.Set("Referrer-Policy", "same-origin")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html")
+	return c.Status(status).Send(buf.Bytes())
+}
+
+func (h *ApiHandler) dashboardLoginPage(c *fiber.Ctx) error {
+	return h.renderDashboard(c, http.StatusOK, "login.html", dashboardView{Title: "Sign in", Data: fiber.Map{"Next": safeNext(c.Query("next"))}})
+}
+
+func (h *ApiHandler) dashboardLogin(c *fiber.Ctx) error {
+	// there is no session and so no csrf token yet; without this check
+	// another site could sign the browser into a session of its choosing
+	if !sameOrigin(c) {
+		return fiber.NewError(http.StatusForbidden, "sign in from another site")
+	}
+	token := strings.TrimSpace(c.FormValue("key"))
+	next := safeNext(c.FormValue("next"))
+	fail := func(msg string) error {
+		return h.renderDashboard(c, http.StatusUnauthorized, "login.html", dashboardView{Title: "Sign in", Error: msg, Data: fiber.Map{"Next": next}})
+	}
+	if token == "" {
+		return fail("Enter an admin key.")
+	}
+	k, err := h.authenticateAdmin(token, RoleViewer)
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fail("This key is not valid for the dashboard.")
+	}
+	if err != nil {
+		return err
+	}
+	session, err := h.store.CreateDashboardSession(k.ID, dashboardSessionTTL)
+	if err != nil {
+		slog.Error("could not create dashboard session", "err", err)
+		return err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     dashboardCookie,
+		Value:    session,
+		Path:     "/admin",
+		Expires:  time.Now().Add(dashboardSessionTTL),
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+	slog.Info("dashboard sign in", "key", k.Prefix, "name", k.Name)
+	return c.Redirect(next, http.StatusSeeOther)
+}
+
+func (h *ApiHandler) dashboardLogout(c *fiber.Ctx) error {
+	if session := c.Cookies(dashboardCookie); session != "" {
+		if !validCSRF(c, session) {
+			return fiber.NewError(http.StatusForbidden, "invalid csrf token")
+		}
+		if err := h.store.DeleteDashboardSession(session); err != nil {
+			slog.Error("could not delete dashboard session", "err", err)
+			return err
+		}
+	}
+	clearDashboardCookie(c)
+	return c.Redirect("/admin/login", http.StatusSeeOther)
+}
+
+func (h *ApiHandler) dashboardFeedbackList(c *fiber.Ctx) error {
+	view := dashboardView{Title: "Feedback"}
+	data := fiber.Map{
+		"Project":  c.Query("project"),
+		"Status":   c.Query("status"),
+		"Spam":     c.Query("spam"),
+		"Projects": h.projects.All(),
+		"Statuses": []FeedbackStatus{StatusNew, StatusAcknowledged, StatusInProgress, StatusResolved, StatusWontFix},
+	}
+	view.Data = data
+
+	filter, err := parseFeedbackFilter(c)
+	if err != nil {
+		view.Error = err.Error()
+		return h.renderDashboard(c, http.StatusBadRequest, "feedback_list.html", view)
+	}
+	page, err := h.store.ListFeedback(filter)
+	if err != nil {
+		slog.Error("could not list feedback", "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not list feedback")
+	}
+	data["Items"] = page.Items
+	if page.NextCursor != "" {
+		q := url.Values{"cursor": {page.NextCursor}}
+		for _, k := range []string{"project", "status", "spam"} {
+			if v := c.Query(k); v != "" {
+				q.Set(k, v)
+			}
+		}
+		data["Next"] = "/admin/feedback?" + q.Encode()
+	}
+	return h.renderDashboard(c, http.StatusOK, "feedback_list.html", view)
+}
+
+func (h *ApiHandler) dashboardFeedbackDetail(c *fiber.Ctx) error {
+	return h.renderFeedbackDetail(c, http.StatusOK, "")
+}
+
+// renderFeedbackDetail shows one feedback entry with its payload, notes and
+// the triage form. errMsg is shown above the form after a failed update.
+func (h *ApiHandler) renderFeedbackDetail(c *fiber.Ctx, status int, errMsg string) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+	f, err := h.store.GetFeedback(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return h.renderDashboard(c, http.StatusNotFound, "error.html", dashboardView{Title: "Not found", Error: "This feedback does not exist."})
+	}
+	if err != nil {
+		slog.Error("could not get feedback", "id", id, "err", err)
+		return fiber.NewError(http.StatusInternalServerError, "could not get feedback")
+	}
+	notes, err := h.store.ListFeedbackNotes(f.ID)
+	if err != nil {
+		slog.Error("could not list feedback notes", "id", id, "err", err)
+		return fiber.NewError(http.StatusInternalServerError, "could not get feedback")
+	}
+
+	payload, errorLog := splitErrorLog(f.Payload)
+	p := principal(c)
+	return h.renderDashboard(c, status, "feedback_detail.html", dashboardView{
+		Title: "Feedback #" + strconv.Itoa(int(f.ID)),
+		Error: errMsg,
+		Data: fiber.Map{
+			"Feedback":     f,
+			"Payload":      payload,
+			"ErrorLog":     errorLog,
+			"Notes":        notes,
+			"NextStatuses": statusTransitions[f.Status],
+			"CanTriage":    p != nil && p.Role.allows(RoleTriager),
+		},
+	})
+}
+
+// splitErrorLog pretty prints the payload with the errorLog taken out, so
+// the usually long log gets its own block.
+func splitErrorLog(raw JSONB) (payload, errorLog string) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return string(raw), ""
+	}
+	if v, ok := m["errorLog"]; ok && v != nil && v != "" {
+		if s, ok := v.(string); ok {
+			errorLog = s
+		} else if b, err := json.MarshalIndent(v, "", "  "); err == nil {
+			errorLog = string(b)
+		}
+	}
+	delete(m, "errorLog")
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return string(raw), errorLog
+	}
+	return string(b), errorLog
+}
+
+func (h *ApiHandler) dashboardTriage(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+
+	assignee := c.FormValue("assignee")
+	note := c.FormValue("resolutionNote")
+	update := TriageUpdate{Assignee: &assignee, ResolutionNote: &note}
+	if s := FeedbackStatus(c.FormValue("status")); s != "" {
+		update.Status = &s
+	}
+	// the form is prefilled, saving it must not undo a change made since
+	if v := c.FormValue("updatedAt"); v != "" {
+		seen, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "invalid updatedAt")
+		}
+		update.UpdatedAt = &seen
+	}
+
+	_, err = h.store.UpdateFeedbackTriage(uint(id), &update)
+	var invalid *FeedbackValidationError
+	switch {
+	case err == nil:
+		slog.Info("feedback triaged from dashboard", "id", id, "by", principal(c).Name)
+		return c.Redirect("/admin/feedback/"+strconv.Itoa(id), http.StatusSeeOther)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return h.renderDashboard(c, http.StatusNotFound, "error.html", dashboardView{Title: "Not found", Error: "This feedback does not exist."})
+	case errors.Is(err, ErrConcurrentUpdate):
+		return h.renderFeedbackDetail(c, http.StatusConflict, "Someone else changed this feedback in the meantime, please check and try again.")
+	case errors.As(err, &invalid):
+		return h.renderFeedbackDetail(c, http.StatusUnprocessableEntity, invalid.Reason)
+	}
+	slog.Error("could not update feedback", "id", id, "err", err)
+	errorsCounter.WithLabelValues("").Inc()
+	return fiber.NewError(http.StatusInternalServerError, "could not update feedback")
+}
+
+func (h *ApiHandler) dashboardAddNote(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+
+	_, err = h.store.AddFeedbackNote(uint(id), principal(c).Name, c.FormValue("text"))
+	var invalid *FeedbackValidationError
+	switch {
+	case err == nil:
+		return c.Redirect("/admin/feedback/"+strconv.Itoa(id)+"#notes", http.StatusSeeOther)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return h.renderDashboard(c, http.StatusNotFound, "error.html", dashboardView{Title: "Not found", Error: "This feedback does not exist."})
+	case errors.As(err, &invalid):
+		return h.renderFeedbackDetail(c, http.StatusUnprocessableEntity, invalid.Reason)
+	}
+	slog.Error("could not add feedback note", "id", id, "err", err)
+	return fiber.NewError(http.StatusInternalServerError, "could not add note")
+}
+
+func (h *ApiHandler) dashboardContactList(c *fiber.Ctx) error {
+	before, _ := strconv.ParseUint(c.Query("before"), 10, 64)
+	messages, err := h.store.ListContactMessages(uint(before), contactPageSize)
+	if err != nil {
+		slog.Error("could not list contact messages", "err", err)
+		return fiber.NewError(http.StatusInternalServerError, "could not list contact messages")
+	}
+	data := fiber.Map{"Items": messages}
+	if len(messages) == contactPageSize {
+		data["Next"] = "/admin/contact?before=" + strconv.Itoa(int(messages[len(messages)-1].ID))
+	}
+	return h.renderDashboard(c, http.StatusOK, "contact_list.html", dashboardView{Title: "Contact messages", Data: data})
+}
+
+func (h *ApiHandler) dashboardContactDetail(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+	m, err := h.store.GetContactMessage(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return h.renderDashboard(c, http.StatusNotFound, "error.html", dashboardView{Title: "Not found", Error: "This message does not exist."})
+	}
+	if err != nil {
+		slog.Error("could not get contact message", "id", id, "err", err)
+		return fiber.NewError(http.StatusInternalServerError, "could not get contact message")
+	}
+	return h.renderDashboard(c, http.StatusOK, "contact_detail.html", dashboardView{Title: "Contact message #" + strconv.Itoa(id), Data: m})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func newDashboardApp(t *testing.T) *fiber.App {
+	t.Helper()
+	projects, err := NewProjectRegistry(defaultProjects())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ApiHandler{projects: projects}
+	app := fiber.New()
+	h.registerDashboard(app)
+	return app
+}
+
+func TestDashboardPagesRender(t *testing.T) {
+	now := time.Now()
+	f := &Feedback{
+		Model:                  gorm.Model{ID: 3, CreatedAt: now, UpdatedAt: now},
+		Project:                "sky",
+		FeedbackName:           "flipper",
+		AdditionalInformations: "<script>alert(1)</script> prices are wrong",
+		TriageState:            TriageState{Status: StatusNew},
+		Spam:                   true,
+	}
+	key := &APIKey{Name: "alice", Role: RoleTriager}
+	payload, errorLog := splitErrorLog(JSONB(`{"rating":2,"errorLog":[{"msg":"boom"}]}`))
+
+	pages := map[string]interface{}{
+		"login.html":         fiber.Map{"Next": "/admin/feedback"},
+		"error.html":         nil,
+		"feedback_list.html": fiber.Map{"Projects": defaultProjects(), "Statuses": []FeedbackStatus{StatusNew}, "Items": []Feedback{*f}, "Status": "new", "Project": "sky", "Spam": "", "Next": "/admin/feedback?cursor=x"},
+		"feedback_detail.html": fiber.Map{
+			"Feedback": f, "Payload": payload, "ErrorLog": errorLog, "CanTriage": true,
+			"Notes":        []FeedbackNote{{FeedbackID: 3, Author: "alice", Text: "looking into it"}},
+			"NextStatuses": statusTransitions[StatusNew],
+		},
+		"contact_list.html":   fiber.Map{"Items": []ContactMessage{{Name: "Bob", Email: "bob@example.com", Message: "hi"}}},
+		"contact_detail.html": &ContactMessage{Name: "Bob", Email: "bob@example.com", Message: "hi"},
+	}
+	for page, data := range pages {
+		var sb strings.Builder
+		err := dashboardPages[page].ExecuteTemplate(&sb, "layout", dashboardView{Title: "t", Principal: key, CSRF: "tok", Data: data})
+		if err != nil {
+			t.Errorf("%s: %v", page, err)
+			continue
+		}
+		if strings.Contains(sb.String(), "<script>") {
+			t.Errorf("%s: submitted html not escaped", page)
+		}
+		// everything is served by us, no CDN
+		if strings.Contains(sb.String(), "https://") {
+			t.Errorf("%s: references an external url", page)
+		}
+	}
+
+	var sb strings.Builder
+	if err := dashboardPages["feedback_detail.html"].ExecuteTemplate(&sb, "layout", dashboardView{Principal: key, CSRF: "tok", Data: pages["feedback_detail.html"]}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`name="csrf" value="tok"`, "boom", "looking into it", `value="acknowledged"`, `name="updatedAt" value="` + now.Format(time.RFC3339Nano) + `"`} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("detail page lacks %q", want)
+		}
+	}
+}
+
+func TestDashboardRedirectsToLogin(t *testing.T) {
+	app := newDashboardApp(t)
+	resp, err := app.Test(httptest.NewRequest("GET", "/admin/feedback?status=new", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 303 {
+		t.Fatalf("expected 303, got %d", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/admin/login?next=%2Fadmin%2Ffeedback%3Fstatus%3Dnew" {
+		t.Errorf("unexpected redirect %q", loc)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/admin/feedback/1/notes", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 401 {
+		t.Errorf("posts without a session should get 401, got %d", resp.StatusCode)
+	}
+}
+
+func TestDashboardLoginPageAndAssets(t *testing.T) {
+	app := newDashboardApp(t)
+	resp, err := app.Test(httptest.NewRequest("GET", "/admin/login?next=https://evil.example", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !strings.Contains(string(body), `value="/admin/feedback"`) {
+		t.Errorf("login page should render with a safe next url, got %d", resp.StatusCode)
+	}
+	if csp := resp.Header.Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'none'") {
+		t.Errorf("missing csp, got %q", csp)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/admin/static/dashboard.css", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("stylesheet not served, got %d", resp.StatusCode)
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	for in, want := range map[string]string{
+		"/admin/feedback/3":    "/admin/feedback/3",
+		"":                     "/admin/feedback",
+		"https://evil.example": "/admin/feedback",
+		"//evil.example":       "/admin/feedback",
+		"/api/feedback":        "/admin/feedback",
+		"/admin/\\evil":        "/admin/feedback",
+	} {
+		if got := safeNext(in); got != want {
+			t.Errorf("safeNext(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSplitErrorLog(t *testing.T) {
+	payload, errorLog := splitErrorLog(JSONB(`{"rating":2,"errorLog":"TypeError: x is undefined"}`))
+	if errorLog != "TypeError: x is undefined" {
+		t.Errorf("unexpected errorLog %q", errorLog)
+	}
+	if strings.Contains(payload, "errorLog") || !strings.Contains(payload, `"rating": 2`) {
+		t.Errorf("unexpected payload %q", payload)
+	}
+
+	_, errorLog = splitErrorLog(JSONB(`{"errorLog":""}`))
+	if errorLog != "" {
+		t.Errorf("empty errorLog should be dropped, got %q", errorLog)
+	}
+}
+
+func TestCSRFTokenIsPerSession(t *testing.T) {
+	if csrfToken("a") == csrfToken("b") || csrfToken("a") != csrfToken("a") {
+		t.Error("csrf token must be deterministic per session and differ between sessions")
+	}
+}
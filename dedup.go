@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultDedupWindow is how long an identical submission counts as a
+// duplicate when DEDUP_WINDOW is not set.
+const defaultDedupWindow = 10 * time.Minute
+
+// FeedbackDedupKey claims a content hash for the dedup window. The primary
+// key on Hash is what makes dedup atomic across replicas: of two identical
+// submissions only one can insert (or take over an expired) key.
+type FeedbackDedupKey struct {
+	Hash       string    `gorm:"primaryKey"`
+	FeedbackID uint      `gorm:"index"`
+	ExpiresAt  time.Time `gorm:"index"`
+}
+
+// feedbackContentHash identifies a submission for deduplication. It covers
+// who sent it (project, user, context, feedback name) and the normalized
+// payload, so the same text from two users is never merged.
+func feedbackContentHash(f *Feedback) string {
+	h := sha256.New()
+	for _, part := range []string{f.Project, f.User, f.Context, f.FeedbackName} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(normalizedPayload(f.Feedback))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizedPayload returns a canonical form of the raw feedback JSON: keys
+// sorted, whitespace in strings collapsed and the client timestamp dropped,
+// since it differs between otherwise identical resubmissions.
+func normalizedPayload(raw string) []byte {
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return []byte(strings.TrimSpace(raw))
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		delete(m, "timestamp")
+	}
+	// encoding/json writes map keys in sorted order
+	b, err := json.Marshal(normalizeValue(v))
+	if err != nil {
+		return []byte(raw)
+	}
+	return b
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return strings.Join(strings.Fields(val), " ")
+	case map[string]interface{}:
+		for k, inner := range val {
+			val[k] = normalizeValue(inner)
+		}
+	case []interface{}:
+		for i, inner := range val {
+			val[i] = normalizeValue(inner)
+		}
+	}
+	return v
+}
+
+// claimDedupKey tries to claim the hash for the window. If an unexpired key
+// exists, it returns the id of the feedback holding it and false.
+func claimDedupKey(tx *gorm.DB, hash string, window time.Duration, now time.Time) (uint, bool, error) {
+	key := FeedbackDedupKey{Hash: hash, ExpiresAt: now.Add(window)}
+	res := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.AssignmentColumns([]string{"feedback_id", "expires_at"}),
+		// only take over keys whose window has passed
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Lt{Column: clause.Column{Table: "feedback_dedup_keys", Name: "expires_at"}, Value: now},
+		}},
+	}).Create(&key)
+	if res.Error != nil {
+		return 0, false, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return 0, true, nil
+	}
+
+	var existing FeedbackDedupKey
+	if err := tx.Where("hash = ?", hash).First(&existing).Error; err != nil {
+		return 0, false, err
+	}
+	return existing.FeedbackID, false, nil
+}
+
+// PurgeExpiredDedupKeys removes keys whose window has passed.
+func (d *DatabaseHandler) PurgeExpiredDedupKeys() (int64, error) {
+	res := d.db.Where("expires_at < ?", time.Now()).Delete(&FeedbackDedupKey{})
+	return res.RowsAffected, res.Error
+}
+
+// dedupCleanupLoop periodically drops expired dedup keys so the table only
+// holds the current window, until ctx is cancelled.
+func (d *DatabaseHandler) dedupCleanupLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := d.PurgeExpiredDedupKeys()
+		if err != nil {
+			slog.Error("could not purge expired dedup keys", "err", err)
+			continue
+		}
+		slog.Debug("purged expired dedup keys", "count", n)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFeedbackContentHashNormalizesPayload(t *testing.T) {
+	a := &Feedback{User: "u1", Context: "web", FeedbackName: "bug",
+		Feedback: `{"additionalInformation":"the  page\nis broken","rating":2,"timestamp":"2025-01-01T10:00:00Z"}`}
+	b := &Feedback{User: "u1", Context: "web", FeedbackName: "bug",
+		Feedback: `{"rating":2, "additionalInformation":" the page is broken ","timestamp":"2025-01-01T10:05:00Z"}`}
+	if feedbackContentHash(a) != feedbackContentHash(b) {
+		t.Error("equivalent payloads should hash the same")
+	}
+
+	for name, other := range map[string]*Feedback{
+		"user":    {User: "u2", Context: a.Context, FeedbackName: a.FeedbackName, Feedback: a.Feedback},
+		"context": {User: a.User, Context: "app", FeedbackName: a.FeedbackName, Feedback: a.Feedback},
+		"name":    {User: a.User, Context: a.Context, FeedbackName: "idea", Feedback: a.Feedback},
+		"project": {User: a.User, Context: a.Context, FeedbackName: a.FeedbackName, Feedback: a.Feedback, Project: "songvoter"},
+		"payload": {User: a.User, Context: a.Context, FeedbackName: a.FeedbackName, Feedback: `{"additionalInformation":"the page is broken","rating":3}`},
+	} {
+		if feedbackContentHash(other) == feedbackContentHash(a) {
+			t.Errorf("different %s should not hash the same", name)
+		}
+	}
+}
+
+func TestSaveFeedbackDeduplicates(t *testing.T) {
+	db := connectSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	defer db.Close()
+	ctx := context.Background()
+	submit := func() error {
+		return db.SaveFeedback(ctx, &Feedback{Project: "sky", User: "u1", Feedback: `{"additionalInformation":"broken"}`}, nil, nil)
+	}
+
+	if err := submit(); err != nil {
+		t.Fatal(err)
+	}
+	var original Feedback
+	if err := db.db.First(&original).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := submit(); !errors.Is(err, ErrDuplicateFeedback) {
+		t.Fatalf("second submission = %v, want ErrDuplicateFeedback", err)
+	}
+	var counted Feedback
+	if err := db.db.First(&counted, original.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if counted.DuplicateCount != 1 || counted.LastDuplicateAt == nil {
+		t.Errorf("duplicate count = %d, last at %v", counted.DuplicateCount, counted.LastDuplicateAt)
+	}
+	// triage guards on updated_at, a duplicate must not make it conflict
+	if !counted.UpdatedAt.Equal(original.UpdatedAt) {
+		t.Errorf("duplicate moved updated_at from %s to %s", original.UpdatedAt, counted.UpdatedAt)
+	}
+
+	// once the window passed the same content is stored again
+	if err := db.db.Model(&FeedbackDedupKey{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := submit(); err != nil {
+		t.Fatalf("submission after the window = %v", err)
+	}
+	var n int64
+	db.db.Model(&Feedback{}).Count(&n)
+	if n != 2 {
+		t.Errorf("%d rows stored, want 2", n)
+	}
+}
+
+func TestSaveFeedbackConcurrentDuplicates(t *testing.T) {
+	db := connectSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = db.SaveFeedback(context.Background(), &Feedback{Project: "sky", User: "u1", Feedback: `{"additionalInformation":"twice"}`}, nil, nil)
+		}()
+	}
+	wg.Wait()
+
+	saved := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			saved++
+		case !errors.Is(err, ErrDuplicateFeedback):
+			t.Fatal(err)
+		}
+	}
+	var n int64
+	db.db.Model(&Feedback{}).Count(&n)
+	if saved != 1 || n != 1 {
+		t.Errorf("%d submissions saved, %d rows stored, want exactly one", saved, n)
+	}
+}
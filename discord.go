@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf16"
+)
+
+// Discord rejects messages exceeding these limits with a 400, see
+// https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+const (
+	discordContentLimit     = 2000
+	discordTitleLimit       = 256
+	discordDescriptionLimit = 4096
+	discordFieldNameLimit   = 256
+	discordFieldValueLimit  = 1024
+	discordFieldsPerEmbed   = 25
+	discordEmbedTotalLimit  = 6000
+	discordEmbedsPerMessage = 10
+
+	// errorLogs longer than this are uploaded as a file instead of inlined
+	discordInlineErrorLog = 900
+)
+
+// embed colours by severity
+const (
+	discordColorBroken   = 0xED4245 // something broke / error log attached
+	discordColorNegative = 0xFEA75C // rating of 2 or lower
+	discordColorPositive = 0x57F287 // rating of 4 or higher
+	discordColorNeutral  = 0x5865F2
+)
+
+// DiscordNotifier posts to a Discord channel webhook. Feedback is rendered as
+// an embed, everything else as plain message content.
+type DiscordNotifier struct {
+	WebhookURL string
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+	Footer      *discordEmbedFooter `json:"footer,omitempty"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type discordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+type discordFile struct {
+	Name string
+	Data []byte
+}
+
+// discordMessage is one webhook execution.
+type discordMessage struct {
+	Content string
+	Embeds  []discordEmbed
+	Files   []discordFile
+}
+
+func (d *DiscordNotifier) Notify(ctx context.Context, n *Notification) error {
+	if d.WebhookURL == "" || d.WebhookURL == "YOUR_WEBHOOK_URL_HERE" {
+		return fmt.Errorf("no discord webhook configured")
+	}
+
+	var messages []discordMessage
+	if n.Feedback != nil {
+		var err error
+		messages, err = discordFeedbackMessages(n.Feedback)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, chunk := range splitDiscordText(n.Text, discordContentLimit) {
+			messages = append(messages, discordMessage{Content: chunk})
+		}
+	}
+
+	for ; n.Parts < len(messages); n.Parts++ {
+		if err := d.send(ctx, messages[n.Parts]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send executes the webhook once, as multipart when files are attached.
+func (d *DiscordNotifier) send(ctx context.Context, m discordMessage) error {
+	payload := map[string]interface{}{
+		// Never let a submitted @everyone/@here or role mention fire.
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	}
+	if m.Content != "" {
+		payload["content"] = m.Content
+	}
+	if len(m.Embeds) > 0 {
+		payload["embeds"] = m.Embeds
+	}
+	if len(m.Files) == 0 {
+		return postJSON(ctx, http.MethodPost, d.WebhookURL, nil, payload)
+	}
+
+	attachments := make([]map[string]interface{}, 0, len(m.Files))
+	for i, f := range m.Files {
+		attachments = append(attachments, map[string]interface{}{"id": i, "filename": f.Name})
+	}
+	payload["attachments"] = attachments
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error creating JSON payload: %w", err)
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.WriteField("payload_json", string(payloadJSON)); err != nil {
+		return err
+	}
+	for i, f := range m.Files {
+		part, err := w.CreateFormFile(fmt.Sprintf("files[%d]", i), f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return sendBody(ctx, http.MethodPost, d.WebhookURL, nil, w.FormDataContentType(), body.Bytes())
+}
+
+// discordFeedbackMessages renders a feedback as embeds: the feedback name as
+// title, additionalInformation as description, the well known keys as
+// fields and a colour by severity. A long errorLog is attached as a file;
+// anything still exceeding Discord's limits is split across embeds and
+// messages.
+func discordFeedbackMessages(f *Feedback) ([]discordMessage, error) {
+	var payload map[string]interface{}
+	raw := []byte(f.Payload)
+	if len(raw) == 0 {
+		raw = []byte(f.Feedback)
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("could not parse feedback JSON: %w", err)
+	}
+
+	title := f.FeedbackName
+	if title == "" {
+		title = "New feedback received"
+	}
+	if f.Project != "" {
+		title = fmt.Sprintf("[%s] %s", f.Project, title)
+	}
+
+	var fields []discordEmbedField
+	addField := func(name, value string, inline bool) {
+		if value == "" {
+			return
+		}
+		fields = append(fields, discordEmbedField{
+			Name:   truncateDiscord(name, discordFieldNameLimit),
+			Value:  truncateDiscord(value, discordFieldValueLimit),
+			Inline: inline,
+		})
+	}
+
+	// well known keys first, in a fixed order
+	addField("rating", discordValue(payload["rating"]), true)
+	addField("user", f.User, true)
+	addField("context", f.Context, true)
+	addField("href", discordValue(payload["href"]), false)
+
+	var files []discordFile
+	if errorLog, ok := payload["errorLog"]; ok && errorLog != nil {
+		pretty, err := json.MarshalIndent(errorLog, "", "  ")
+		if err == nil {
+			if discordLen(string(pretty)) <= discordInlineErrorLog {
+				addField("errorLog", "```json\n"+string(pretty)+"\n```", false)
+			} else {
+				files = append(files, discordFile{Name: fmt.Sprintf("errorLog-%d.json", f.ID), Data: pretty})
+				addField("errorLog", fmt.Sprintf("attached, %d bytes", len(pretty)), false)
+			}
+		}
+	}
+
+	// then whatever else the client sent, alphabetically
+	skip := []string{"rating", "href", "errorLog", "additionalInformation"}
+	var rest []string
+	for k := range payload {
+		if !slices.Contains(skip, k) {
+			rest = append(rest, k)
+		}
+	}
+	slices.Sort(rest)
+	for _, k := range rest {
+		addField(k, discordValue(payload[k]), true)
+	}
+
+	description, _ := payload["additionalInformation"].(string)
+	chunks := splitDiscordText(description, discordDescriptionLimit)
+	if len(chunks) == 0 {
+		chunks = []string{""}
+	}
+
+	embeds := make([]discordEmbed, 0, len(chunks))
+	for i, chunk := range chunks {
+		e := discordEmbed{Description: chunk, Color: discordSeverityColor(payload)}
+		if i == 0 {
+			e.Title = truncateDiscord(title, discordTitleLimit)
+		}
+		embeds = append(embeds, e)
+	}
+	// fields go on the last description embed and spill into further embeds
+	// when there are too many of them or they'd exceed the size budget
+	last := &embeds[len(embeds)-1]
+	for _, field := range fields {
+		if len(last.Fields) == discordFieldsPerEmbed || embedLen(*last)+discordLen(field.Name)+discordLen(field.Value) > discordEmbedTotalLimit {
+			embeds = append(embeds, discordEmbed{Color: last.Color})
+			last = &embeds[len(embeds)-1]
+		}
+		last.Fields = append(last.Fields, field)
+	}
+	if f.ID != 0 {
+		last.Footer = &discordEmbedFooter{Text: fmt.Sprintf("feedback #%d", f.ID)}
+	}
+
+	// Discord also caps the combined size of all embeds of one message
+	var messages []discordMessage
+	current := discordMessage{Files: files}
+	size := 0
+	for _, e := range embeds {
+		n := embedLen(e)
+		if len(current.Embeds) > 0 && (len(current.Embeds) == discordEmbedsPerMessage || size+n > discordEmbedTotalLimit) {
+			messages = append(messages, current)
+			current, size = discordMessage{}, 0
+		}
+		current.Embeds = append(current.Embeds, e)
+		size += n
+	}
+	return append(messages, current), nil
+}
+
+// discordSeverityColor picks the embed colour from the payload.
+func discordSeverityColor(payload map[string]interface{}) int {
+	if broke, _ := payload["somethingBroke"].(bool); broke {
+		return discordColorBroken
+	}
+	if errorLog, ok := payload["errorLog"]; ok && errorLog != nil && errorLog != "" {
+		return discordColorBroken
+	}
+	if rating, ok := payload["rating"].(float64); ok {
+		switch {
+		case rating <= 2:
+			return discordColorNegative
+		case rating >= 4:
+			return discordColorPositive
+		}
+	}
+	return discordColorNeutral
+}
+
+// discordValue formats a payload value for an embed field. Empty strings,
+// false and null come out empty so the field is left out.
+func discordValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		if val {
+			return "true"
+		}
+		return ""
+	case float64:
+		return fmt.Sprintf("%g", val)
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+}
+
+// discordLen counts characters the way Discord does (UTF-16 code units).
+func discordLen(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func embedLen(e discordEmbed) int {
+	n := discordLen(e.Title) + discordLen(e.Description)
+	for _, f := range e.Fields {
+		n += discordLen(f.Name) + discordLen(f.Value)
+	}
+	if e.Footer != nil {
+		n += discordLen(e.Footer.Text)
+	}
+	return n
+}
+
+// truncateDiscord cuts s to limit characters, marking the cut with an ellipsis.
+func truncateDiscord(s string, limit int) string {
+	if discordLen(s) <= limit {
+		return s
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		if n+utf16.RuneLen(r) > limit-1 {
+			break
+		}
+		b.WriteRune(r)
+		n += utf16.RuneLen(r)
+	}
+	return b.String() + "…"
+}
+
+// splitDiscordText breaks text into chunks of at most limit characters,
+// preferring line breaks. A ``` code block cut in two is closed at the end
+// of one chunk and reopened in the next, so both halves still render.
+func splitDiscordText(text string, limit int) []string {
+	if text == "" {
+		return nil
+	}
+	if discordLen(text) <= limit {
+		return []string{text}
+	}
+
+	const fence = "```"
+	// room for closing and reopening a fence around each chunk
+	budget := limit - 2*(len(fence)+1)
+
+	var chunks []string
+	var cur strings.Builder
+	curLen := 0
+	inCode := false
+
+	flush := func() {
+		if curLen == 0 {
+			return
+		}
+		s := cur.String()
+		if inCode {
+			s = strings.TrimSuffix(s, "\n") + "\n" + fence
+		}
+		chunks = append(chunks, s)
+		cur.Reset()
+		curLen = 0
+		if inCode {
+			cur.WriteString(fence + "\n")
+			curLen = len(fence) + 1
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		// lines longer than a whole chunk are hard wrapped
+		for discordLen(line) > budget {
+			head := truncateRunes(line, budget-curLen)
+			if head == "" {
+				flush()
+				continue
+			}
+			cur.WriteString(head)
+			curLen += discordLen(head)
+			line = line[len(head):]
+			flush()
+		}
+		if curLen+discordLen(line) > budget {
+			flush()
+		}
+		cur.WriteString(line)
+		curLen += discordLen(line)
+		if strings.Count(line, fence)%2 == 1 {
+			inCode = !inCode
+		}
+	}
+	if curLen > 0 {
+		chunks = append(chunks, cur.String())
+	}
+	return chunks
+}
+
+// truncateRunes returns the longest prefix of s with at most limit characters.
+func truncateRunes(s string, limit int) string {
+	n := 0
+	for i, r := range s {
+		if n+utf16.RuneLen(r) > limit {
+			return s[:i]
+		}
+		n += utf16.RuneLen(r)
+	}
+	return s
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestDiscordFeedbackEmbed(t *testing.T) {
+	f := &Feedback{
+		Model:        gorm.Model{ID: 42},
+		Project:      "sky",
+		FeedbackName: "auction-page",
+		User:         "u-1",
+		Context:      "web",
+		Payload:      JSONB(`{"additionalInformation":"prices are wrong","rating":1,"href":"https://sky.coflnet.com/auction/1","subscriptionStatus":"premium","loadNewInformation":false}`),
+	}
+	messages, err := discordFeedbackMessages(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || len(messages[0].Embeds) != 1 || len(messages[0].Files) != 0 {
+		t.Fatalf("expected a single embed, got %+v", messages)
+	}
+	e := messages[0].Embeds[0]
+	if e.Title != "[sky] auction-page" || e.Description != "prices are wrong" || e.Color != discordColorNegative {
+		t.Errorf("unexpected embed %+v", e)
+	}
+	var names []string
+	for _, field := range e.Fields {
+		names = append(names, field.Name)
+	}
+	if got := strings.Join(names, ","); got != "rating,user,context,href,subscriptionStatus" {
+		t.Errorf("unexpected fields %s", got)
+	}
+	if e.Footer == nil || e.Footer.Text != "feedback #42" {
+		t.Errorf("missing footer")
+	}
+}
+
+func TestDiscordAttachesLongErrorLog(t *testing.T) {
+	var mu sync.Mutex
+	var files []string
+	var payloads []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var p map[string]interface{}
+		if mediaType == "multipart/form-data" {
+			mr := multipart.NewReader(r.Body, params["boundary"])
+			for {
+				part, err := mr.NextPart()
+				if err != nil {
+					break
+				}
+				data, _ := io.ReadAll(part)
+				if part.FormName() == "payload_json" {
+					json.Unmarshal(data, &p)
+				} else {
+					files = append(files, part.FileName())
+				}
+			}
+		} else {
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &p)
+		}
+		payloads = append(payloads, p)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	frames := make([]string, 200)
+	for i := range frames {
+		frames[i] = fmt.Sprintf("at render (https://sky.coflnet.com/static/chunk-%d.js:1:%d)", i, i*31)
+	}
+	raw, _ := json.Marshal(map[string]interface{}{
+		"additionalInformation": strings.Repeat("the page crashed again. ", 400),
+		"somethingBroke":        true,
+		"errorLog":              map[string]interface{}{"message": "TypeError", "stack": frames},
+	})
+	f := &Feedback{Model: gorm.Model{ID: 9}, Feedback: string(raw), Payload: JSONB(raw)}
+
+	if err := (&DiscordNotifier{WebhookURL: srv.URL}).Notify(context.Background(), &Notification{Feedback: f}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0] != "errorLog-9.json" {
+		t.Errorf("expected the errorLog as attachment, got %v", files)
+	}
+	descriptions := 0
+	for _, p := range payloads {
+		total := 0
+		embeds, _ := p["embeds"].([]interface{})
+		if len(embeds) > discordEmbedsPerMessage {
+			t.Errorf("too many embeds in one message: %d", len(embeds))
+		}
+		for _, raw := range embeds {
+			e := raw.(map[string]interface{})
+			d, _ := e["description"].(string)
+			if discordLen(d) > discordDescriptionLimit {
+				t.Errorf("description exceeds limit: %d", discordLen(d))
+			}
+			if d != "" {
+				descriptions++
+			}
+			total += discordLen(d)
+			if title, ok := e["title"].(string); ok {
+				total += discordLen(title)
+			}
+		}
+		if total > discordEmbedTotalLimit {
+			t.Errorf("message exceeds the embed size budget: %d", total)
+		}
+		if p["embeds"] != nil && e0color(p) != discordColorBroken {
+			t.Errorf("broken feedback should be red")
+		}
+	}
+	if descriptions < 3 {
+		t.Errorf("expected the long text to be split, got %d parts", descriptions)
+	}
+}
+
+func e0color(p map[string]interface{}) int {
+	embeds := p["embeds"].([]interface{})
+	c, _ := embeds[0].(map[string]interface{})["color"].(float64)
+	return int(c)
+}
+
+func TestSplitDiscordTextKeepsCodeBlocksBalanced(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("intro\n```\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&b, "frame %d at some/long/path/to/file.js:%d\n", i, i)
+	}
+	b.WriteString("```\noutro")
+
+	chunks := splitDiscordText(b.String(), discordContentLimit)
+	if len(chunks) < 2 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+	for i, c := range chunks {
+		if discordLen(c) > discordContentLimit {
+			t.Errorf("chunk %d too long: %d", i, discordLen(c))
+		}
+		if strings.Count(c, "```")%2 != 0 {
+			t.Errorf("chunk %d has an unbalanced code fence:\n%s", i, c)
+		}
+	}
+	if !strings.HasSuffix(chunks[len(chunks)-1], "outro") {
+		t.Error("text lost at the end")
+	}
+}
+
+func TestSplitDiscordTextHardWrapsLongLines(t *testing.T) {
+	chunks := splitDiscordText(strings.Repeat("😀", 3000), discordContentLimit)
+	total := 0
+	for _, c := range chunks {
+		if discordLen(c) > discordContentLimit {
+			t.Errorf("chunk too long: %d", discordLen(c))
+		}
+		total += len([]rune(c))
+	}
+	if total != 3000 {
+		t.Errorf("expected all 3000 runes to survive, got %d", total)
+	}
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errorReportsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedback_error_reports_total",
+		Help: "errors parsed from feedback errorLogs",
+	}, []string{"project"})
+
+	newErrorGroupsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedback_error_groups_new_total",
+		Help: "error fingerprints seen for the first time",
+	}, []string{"project"})
+)
+
+const (
+	// maxErrorReports bounds the errors taken from a single errorLog.
+	maxErrorReports = 20
+	// maxFingerprintFrames is how many stack frames go into a fingerprint.
+	// Deeper frames are mostly framework code shared by unrelated errors.
+	maxFingerprintFrames = 5
+	// maxErrorMessageLength bounds the stored normalized message.
+	maxErrorMessageLength = 300
+	// maxErrorDimensionLength bounds version and page values.
+	maxErrorDimensionLength = 200
+)
+
+// error group count dimensions
+const (
+	errorDimensionVersion = "version"
+	errorDimensionPage    = "page"
+)
+
+// defaultVersionKeys are the payload keys the client version is looked up in
+// when the project doesn't name one.
+var defaultVersionKeys = []string{"version", "clientVersion", "appVersion"}
+
+// ErrorPolicy configures the error grouping of a project's feedback.
+type ErrorPolicy struct {
+	// DisableAlerts stops notifying the project's targets about new
+	// fingerprints.
+	DisableAlerts bool `json:"disableAlerts"`
+	// VersionKey is the top level payload key holding the client version,
+	// by default the first of version, clientVersion and appVersion.
+	VersionKey string `json:"versionKey"`
+}
+
+// ErrorGroup collects identical errors across feedback, Sentry style. Errors
+// are identical when their fingerprint, made of type, normalized message and
+// top stack frames, matches.
+type ErrorGroup struct {
+	gorm.Model
+	Project     string `json:"project" gorm:"uniqueIndex:idx_error_groups_fingerprint"`
+	Fingerprint string `json:"fingerprint" gorm:"uniqueIndex:idx_error_groups_fingerprint"`
+	Type        string `json:"type"`
+	// Message has numbers, ids and urls replaced by placeholders.
+	Message string `json:"message"`
+	// Culprit is the top stack frame, or the source file without a stack.
+	Culprit string `json:"culprit"`
+	// Stack holds the fingerprinted frames, one per line.
+	Stack           string    `json:"stack"`
+	Occurrences     int       `json:"occurrences"`
+	FirstSeenAt     time.Time `json:"firstSeenAt"`
+	LastSeenAt      time.Time `json:"lastSeenAt" gorm:"index"`
+	FirstFeedbackID uint      `json:"firstFeedbackId"`
+}
+
+// ErrorGroupCount counts a group's occurrences per client version or page.
+type ErrorGroupCount struct {
+	ErrorGroupID uint      `json:"-" gorm:"primaryKey"`
+	Dimension    string    `json:"-" gorm:"primaryKey"`
+	Value        string    `json:"value" gorm:"primaryKey"`
+	Occurrences  int       `json:"occurrences"`
+	LastSeenAt   time.Time `json:"lastSeenAt"`
+}
+
+// ErrorOccurrence links a group to the feedback it was reported in.
+type ErrorOccurrence struct {
+	ID           uint `gorm:"primaryKey"`
+	ErrorGroupID uint `gorm:"index"`
+	FeedbackID   uint `gorm:"index"`
+	CreatedAt    time.Time
+}
+
+// errorReport is one error parsed from an errorLog.
+type errorReport struct {
+	Type    string
+	Message string
+	Source  string
+	Frames  []stackFrame
+}
+
+type stackFrame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+var (
+	// Chrome and Node: "at fn (https://host/app.js:1:2)" or "at https://host/app.js:1:2"
+	v8Frame = regexp.MustCompile(`^\s*at\s+(?:(.+?)\s+\()?((?:[a-z-]+://)?[^()\s]+?):(\d+)(?::\d+)?\)?\s*$`)
+	// Firefox and Safari: "fn@https://host/app.js:1:2"
+	geckoFrame = regexp.MustCompile(`^\s*([^@\s]*)@(.+?):(\d+)(?::\d+)?\s*$`)
+	// .NET: "at Namespace.Type.Method(args) in /src/File.cs:line 42"
+	dotnetFrame = regexp.MustCompile(`^\s*at\s+([^\s(]+)(?:\([^)]*\))?(?:\s+in\s+(.+?):line\s+(\d+))?\s*$`)
+
+	// "TypeError: x is undefined"
+	errorTypePrefix = regexp.MustCompile(`^([A-Z][\w.]*(?:Error|Exception)):\s*`)
+)
+
+func parseStackFrame(line string) (stackFrame, bool) {
+	for _, re := range []*regexp.Regexp{v8Frame, geckoFrame, dotnetFrame} {
+		if m := re.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[3])
+			return stackFrame{Function: m[1], File: m[2], Line: n}, true
+		}
+	}
+	return stackFrame{}, false
+}
+
+// parseErrorLog reads the errorLog of a payload. Clients send a string, an
+// error object or a list of either; objects may name their fields like
+// browsers (message, stack, filename, lineno) or like loggers (msg, error,
+// stackTrace, url).
+func parseErrorLog(v interface{}) []errorReport {
+	var reports []errorReport
+	var collect func(v interface{}, depth int)
+	collect = func(v interface{}, depth int) {
+		if len(reports) >= maxErrorReports {
+			return
+		}
+		switch val := v.(type) {
+		case string:
+			if r, ok := parseErrorText(val); ok {
+				reports = append(reports, r)
+			}
+		case map[string]interface{}:
+			if r, ok := parseErrorObject(val); ok {
+				reports = append(reports, r)
+			}
+		case []interface{}:
+			if depth > 0 {
+				return
+			}
+			for _, inner := range val {
+				collect(inner, depth+1)
+			}
+		}
+	}
+	collect(v, 0)
+	return reports
+}
+
+// parseErrorText reads an error as printed: the message followed by stack
+// frames, one per line.
+func parseErrorText(s string) (errorReport, bool) {
+	var r errorReport
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if frame, ok := parseStackFrame(line); ok {
+			r.Frames = append(r.Frames, frame)
+		} else if r.Message == "" {
+			r.Message = strings.TrimSpace(line)
+		}
+	}
+	r.splitType()
+	return r, r.Message != "" || len(r.Frames) > 0
+}
+
+func firstString(m map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := m[k].(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+func parseErrorObject(m map[string]interface{}) (errorReport, bool) {
+	// {"error": {...}} wraps the actual error
+	if inner, ok := m["error"].(map[string]interface{}); ok {
+		return parseErrorObject(inner)
+	}
+
+	r := errorReport{
+		Type:    firstString(m, "name", "type", "errorType"),
+		Message: firstString(m, "message", "msg", "error", "reason", "description"),
+		Source:  firstString(m, "source", "filename", "fileName", "file", "url", "src"),
+	}
+	for _, key := range []string{"stack", "stacktrace", "stackTrace", "trace"} {
+		switch stack := m[key].(type) {
+		case string:
+			text, _ := parseErrorText(stack)
+			r.Frames = text.Frames
+			if r.Message == "" {
+				r.Message = text.Message
+			}
+		case []interface{}:
+			for _, entry := range stack {
+				switch frame := entry.(type) {
+				case string:
+					if f, ok := parseStackFrame(frame); ok {
+						r.Frames = append(r.Frames, f)
+					}
+				case map[string]interface{}:
+					f := stackFrame{
+						Function: firstString(frame, "function", "functionName", "method"),
+						File:     firstString(frame, "file", "fileName", "filename", "url"),
+					}
+					if n, ok := frame["line"].(float64); ok {
+						f.Line = int(n)
+					} else if n, ok := frame["lineNumber"].(float64); ok {
+						f.Line = int(n)
+					}
+					if f.Function != "" || f.File != "" {
+						r.Frames = append(r.Frames, f)
+					}
+				}
+			}
+		default:
+			continue
+		}
+		break
+	}
+	r.splitType()
+	return r, r.Message != "" || len(r.Frames) > 0
+}
+
+// splitType moves a "TypeError: " prefix of the message into Type.
+func (r *errorReport) splitType() {
+	if m := errorTypePrefix.FindStringSubmatch(r.Message); m != nil {
+		if r.Type == "" {
+			r.Type = m[1]
+		}
+		if r.Type == m[1] {
+			r.Message = r.Message[len(m[0]):]
+		}
+	}
+}
+
+var (
+	errorNoiseURL    = regexp.MustCompile(`\b[a-z][a-z0-9+.-]*://[^\s'"()]+`)
+	errorNoiseUUID   = regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`)
+	errorNoiseHex    = regexp.MustCompile(`(?i)\b(?:0x)?[0-9a-f]*[0-9][0-9a-f]*[a-f][0-9a-f]*\b|\b(?:0x)?[0-9a-f]*[a-f][0-9a-f]*[0-9][0-9a-f]*\b`)
+	errorNoiseNumber = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	// "app.3f9a1c2b.js" and "chunk-5f1c2d.js" are the same file across builds
+	bundleHash = regexp.MustCompile(`[.-][0-9a-f]{6,}(\.[a-z]+)$`)
+)
+
+// normalizeErrorMessage replaces what varies between occurrences of the same
+// error: urls, ids and numbers.
+func normalizeErrorMessage(msg string) string {
+	msg, _, _ = strings.Cut(msg, "\n")
+	msg = errorNoiseURL.ReplaceAllString(msg, "<url>")
+	msg = errorNoiseUUID.ReplaceAllString(msg, "<id>")
+	msg = errorNoiseHex.ReplaceAllStringFunc(msg, func(s string) string {
+		if len(s) < 8 {
+			return s
+		}
+		return "<id>"
+	})
+	msg = errorNoiseNumber.ReplaceAllString(msg, "<n>")
+	return truncateRunes(strings.Join(strings.Fields(msg), " "), maxErrorMessageLength)
+}
+
+// normalizeFrameFile reduces a frame's file to its name without query and
+// bundle hash.
+func normalizeFrameFile(file string) string {
+	if u, err := url.Parse(file); err == nil && u.Path != "" {
+		file = u.Path
+	}
+	file = path.Base(strings.ReplaceAll(file, `\`, "/"))
+	if file == "." || file == "/" {
+		return ""
+	}
+	return bundleHash.ReplaceAllStringFunc(file, func(s string) string {
+		// "app-facade.js" is a name, hashes have digits
+		if !strings.ContainsAny(s, "0123456789") {
+			return s
+		}
+		return bundleHash.FindStringSubmatch(s)[1]
+	})
+}
+
+// fingerprintFrames returns the normalized top frames as "function (file)",
+// without line numbers, which change with every build. Frames of browser
+// extensions are skipped, they aren't ours.
+func fingerprintFrames(frames []stackFrame) []string {
+	var out []string
+	for _, f := range frames {
+		if strings.Contains(f.File, "-extension://") {
+			continue
+		}
+		fn := f.Function
+		if fn == "" {
+			fn = "<anonymous>"
+		}
+		out = append(out, fmt.Sprintf("%s (%s)", fn, normalizeFrameFile(f.File)))
+		if len(out) == maxFingerprintFrames {
+			break
+		}
+	}
+	return out
+}
+
+// group returns the report in its grouped form, with the fingerprint set.
+func (r errorReport) group(project string) ErrorGroup {
+	g := ErrorGroup{
+		Project: project,
+		Type:    r.Type,
+		Message: normalizeErrorMessage(r.Message),
+	}
+	frames := fingerprintFrames(r.Frames)
+	g.Stack = strings.Join(frames, "\n")
+	if len(frames) > 0 {
+		g.Culprit = frames[0]
+	} else if r.Source != "" {
+		g.Culprit = normalizeFrameFile(r.Source)
+	}
+
+	h := sha256.New()
+	for _, part := range []string{g.Type, g.Message, g.Stack, g.Culprit} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	g.Fingerprint = hex.EncodeToString(h.Sum(nil)[:16])
+	return g
+}
+
+// ErrorCapture is what SaveFeedback records about the errors in a feedback.
+type ErrorCapture struct {
+	Reports []errorReport
+	Version string
+	Page    string
+	// Alerts are the pending messages to send per new fingerprint.
+	Alerts []OutboxMessage
+}
+
+// capture parses the feedback's errorLog. It returns nil when there is none.
+func (p ErrorPolicy) capture(project *Project, f *Feedback) *ErrorCapture {
+	var payload map[string]interface{}
+	if json.Unmarshal(f.Payload, &payload) != nil {
+		return nil
+	}
+	reports := parseErrorLog(payload["errorLog"])
+	if len(reports) == 0 {
+		return nil
+	}
+
+	c := &ErrorCapture{Reports: reports}
+	keys := defaultVersionKeys
+	if p.VersionKey != "" {
+		keys = []string{p.VersionKey}
+	}
+	for _, k := range keys {
+		switch v := payload[k].(type) {
+		case string:
+			c.Version = strings.TrimSpace(v)
+		case float64:
+			c.Version = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		if c.Version != "" {
+			break
+		}
+	}
+	c.Version = truncateRunes(c.Version, maxErrorDimensionLength)
+	if href, ok := payload["href"].(string); ok {
+		c.Page = truncateRunes(normalizeIssueHref(href), maxErrorDimensionLength)
+	}
+	if !p.DisableAlerts {
+		for _, msg := range outboxMessagesFor(project) {
+			msg.Kind = OutboxErrorAlert
+			c.Alerts = append(c.Alerts, msg)
+		}
+	}
+	return c
+}
+
+// recordErrors groups the captured errors of a stored feedback and queues an
+// alert per new fingerprint. It returns the number of new groups.
+func recordErrors(tx *gorm.DB, f *Feedback, c *ErrorCapture, now time.Time) (int, error) {
+	seen := make(map[string]bool)
+	created := 0
+	for _, r := range c.Reports {
+		g := r.group(f.Project)
+		if seen[g.Fingerprint] {
+			continue
+		}
+		seen[g.Fingerprint] = true
+
+		g.Occurrences = 1
+		g.FirstSeenAt, g.LastSeenAt = now, now
+		g.FirstFeedbackID = f.ID
+		res := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "project"}, {Name: "fingerprint"}},
+			DoNothing: true,
+		}).Create(&g)
+		if res.Error != nil {
+			return created, res.Error
+		}
+
+		if res.RowsAffected == 1 {
+			created++
+			for _, alert := range c.Alerts {
+				alert.FeedbackID = f.ID
+				alert.ErrorGroupID = &g.ID
+				if err := tx.Create(&alert).Error; err != nil {
+					return created, err
+				}
+			}
+		} else {
+			existing := tx.Model(&ErrorGroup{}).Where("project = ? AND fingerprint = ?", g.Project, g.Fingerprint)
+			err := existing.Updates(map[string]interface{}{
+				"occurrences":  gorm.Expr("occurrences + 1"),
+				"last_seen_at": now,
+			}).Error
+			if err != nil {
+				return created, err
+			}
+			if err := tx.Model(&ErrorGroup{}).Select("id").Where("project = ? AND fingerprint = ?", g.Project, g.Fingerprint).Take(&g).Error; err != nil {
+				return created, err
+			}
+		}
+
+		if err := tx.Create(&ErrorOccurrence{ErrorGroupID: g.ID, FeedbackID: f.ID}).Error; err != nil {
+			return created, err
+		}
+		for dimension, value := range map[string]string{errorDimensionVersion: c.Version, errorDimensionPage: c.Page} {
+			if value == "" {
+				continue
+			}
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "error_group_id"}, {Name: "dimension"}, {Name: "value"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"occurrences":  gorm.Expr("error_group_counts.occurrences + 1"),
+					"last_seen_at": now,
+				}),
+			}).Create(&ErrorGroupCount{ErrorGroupID: g.ID, Dimension: dimension, Value: value, Occurrences: 1, LastSeenAt: now}).Error
+			if err != nil {
+				return created, err
+			}
+		}
+	}
+	return created, nil
+}
+
+// detachFromErrorGroups removes erased feedback, or feedback that lost its
+// errorLog, from the groups of its errors. Groups left without feedback are
+// deleted with their counts and alerts; a group whose first feedback is gone
+// points to the earliest remaining one.
+func detachFromErrorGroups(tx *gorm.DB, ids []uint) error {
+	var groups []struct {
+		ErrorGroupID uint
+		Removed      int
+	}
+	err := tx.Model(&ErrorOccurrence{}).Select("error_group_id, count(*) AS removed").
+		Where("feedback_id IN ?", ids).Group("error_group_id").Scan(&groups).Error
+	if err != nil || len(groups) == 0 {
+		return err
+	}
+	if err := tx.Where("feedback_id IN ?", ids).Delete(&ErrorOccurrence{}).Error; err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		var next ErrorOccurrence
+		if err := tx.Where("error_group_id = ?", g.ErrorGroupID).Order("feedback_id").Limit(1).Find(&next).Error; err != nil {
+			return err
+		}
+		if next.ID == 0 {
+			if err := tx.Where("error_group_id = ?", g.ErrorGroupID).Delete(&ErrorGroupCount{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("error_group_id = ?", g.ErrorGroupID).Delete(&OutboxMessage{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&ErrorGroup{}, g.ErrorGroupID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		err := tx.Model(&ErrorGroup{}).Where("id = ?", g.ErrorGroupID).UpdateColumns(map[string]interface{}{
+			"occurrences":       gorm.Expr("occurrences - ?", g.Removed),
+			"first_feedback_id": gorm.Expr("CASE WHEN first_feedback_id IN ? THEN ? ELSE first_feedback_id END", ids, next.FeedbackID),
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrorGroupFilter narrows down an error group listing.
+type ErrorGroupFilter struct {
+	Project        string
+	MinOccurrences int
+
+	// Cursor holds last_seen_at and id of the previous page's last group.
+	Cursor *feedbackCursor
+	Limit  int
+}
+
+// ErrorGroupPage is one page of an error group listing.
+type ErrorGroupPage struct {
+	Items      []ErrorGroup `json:"items"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// ListErrorGroups returns one page of error groups, most recently seen first.
+func (d *DatabaseHandler) ListErrorGroups(filter *ErrorGroupFilter) (*ErrorGroupPage, error) {
+	q := d.db.Model(&ErrorGroup{})
+	if filter.Project != "" {
+		q = q.Where("project = ?", filter.Project)
+	}
+	if filter.MinOccurrences > 0 {
+		q = q.Where("occurrences >= ?", filter.MinOccurrences)
+	}
+	if filter.Cursor != nil {
+		q = q.Where("(last_seen_at, id) < (?, ?)", filter.Cursor.CreatedAt, filter.Cursor.ID)
+	}
+
+	// fetch one extra row to know whether another page follows
+	var items []ErrorGroup
+	res := q.Order("last_seen_at desc, id desc").Limit(filter.Limit + 1).Find(&items)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	page := &ErrorGroupPage{Items: items}
+	if len(items) > filter.Limit {
+		page.Items = items[:filter.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = feedbackCursor{CreatedAt: last.LastSeenAt, ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+// GetErrorGroup loads a single group. It returns gorm.ErrRecordNotFound when
+// no group with that id exists.
+func (d *DatabaseHandler) GetErrorGroup(id uint) (*ErrorGroup, error) {
+	var g ErrorGroup
+	if err := d.db.First(&g, id).Error; err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ErrorGroupDetail is a group with its occurrences per version and page,
+// most frequent first.
+type ErrorGroupDetail struct {
+	*ErrorGroup
+	Versions []ErrorGroupCount `json:"versions"`
+	Pages    []ErrorGroupCount `json:"pages"`
+}
+
+// maxErrorGroupCounts bounds the versions and pages returned per group.
+const maxErrorGroupCounts = 50
+
+func (d *DatabaseHandler) GetErrorGroupDetail(id uint) (*ErrorGroupDetail, error) {
+	g, err := d.GetErrorGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	detail := &ErrorGroupDetail{ErrorGroup: g, Versions: []ErrorGroupCount{}, Pages: []ErrorGroupCount{}}
+	for dimension, dst := range map[string]*[]ErrorGroupCount{errorDimensionVersion: &detail.Versions, errorDimensionPage: &detail.Pages} {
+		err := d.db.Where("error_group_id = ? AND dimension = ?", id, dimension).
+			Order("occurrences desc, value").Limit(maxErrorGroupCounts).Find(dst).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return detail, nil
+}
+
+// errorAlertNotification renders the alert about a new error group.
+func errorAlertNotification(g *ErrorGroup) *Notification {
+	var b strings.Builder
+	title := g.Message
+	if g.Type != "" {
+		title = g.Type + ": " + g.Message
+	}
+	fmt.Fprintf(&b, "New error in %s\n\n**%s**\n", g.Project, title)
+	if g.Stack != "" {
+		fmt.Fprintf(&b, "```\n%s\n```\n", g.Stack)
+	} else if g.Culprit != "" {
+		fmt.Fprintf(&b, "**culprit:** %s\n", g.Culprit)
+	}
+	fmt.Fprintf(&b, "**fingerprint:** %s\n**first reported in feedback:** %d\n", g.Fingerprint, g.FirstFeedbackID)
+	return &Notification{
+		ID:      fmt.Sprintf("error-group-%d", g.ID),
+		Subject: fmt.Sprintf("New %s error: %s", g.Project, truncateRunes(title, 80)),
+		Text:    b.String(),
+		Project: g.Project,
+		Error:   g,
+	}
+}
+
+// errorAlert loads the group an alert message is about.
+func (w *OutboxWorker) errorAlert(msg OutboxMessage) (*Notification, error) {
+	if msg.ErrorGroupID == nil {
+		return nil, &DeliveryError{StatusCode: http.StatusUnprocessableEntity, Message: "error alert without error group"}
+	}
+	g, err := w.db.GetErrorGroup(*msg.ErrorGroupID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, &DeliveryError{StatusCode: http.StatusGone, Message: "error group was deleted"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return errorAlertNotification(g), nil
+}
+
+func parseErrorGroupFilter(c *fiber.Ctx) (*ErrorGroupFilter, error) {
+	f := &ErrorGroupFilter{Project: c.Query("project"), Limit: feedbackPageDefault}
+	if v := c.Query("minOccurrences"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("minOccurrences must be a positive integer")
+		}
+		f.MinOccurrences = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("limit must be a positive integer")
+		}
+		f.Limit = min(n, feedbackPageMax)
+	}
+	if v := c.Query("cursor"); v != "" {
+		cur, err := decodeFeedbackCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		f.Cursor = cur
+	}
+	return f, nil
+}
+
+func (h *ApiHandler) listErrorGroupsRequest(c *fiber.Ctx) error {
+	filter, err := parseErrorGroupFilter(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	page, err := h.store.ListErrorGroups(filter)
+	if err != nil {
+		slog.Error("could not list error groups", "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not list errors")
+	}
+	return c.JSON(page)
+}
+
+func (h *ApiHandler) getErrorGroupRequest(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+	detail, err := h.store.GetErrorGroupDetail(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fiber.NewError(http.StatusNotFound, "error not found")
+		}
+		slog.Error("could not load error group", "id", id, "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not load error")
+	}
+	return c.JSON(detail)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func errorLogOf(t *testing.T, raw string) []errorReport {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatal(err)
+	}
+	return parseErrorLog(v)
+}
+
+const chromeStack = "TypeError: Cannot read properties of undefined (reading 'price')\n" +
+	"    at renderFlip (https://sky.coflnet.com/_next/static/chunks/pages/flipper-3f9a1c2b7d.js:12:345)\n" +
+	"    at https://sky.coflnet.com/_next/static/chunks/main.js:1:2"
+
+func TestParseErrorLog(t *testing.T) {
+	chrome, _ := json.Marshal(chromeStack)
+	reports := errorLogOf(t, string(chrome))
+	if len(reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reports))
+	}
+	r := reports[0]
+	if r.Type != "TypeError" || r.Message != "Cannot read properties of undefined (reading 'price')" {
+		t.Errorf("unexpected type or message %q %q", r.Type, r.Message)
+	}
+	if len(r.Frames) != 2 || r.Frames[0].Function != "renderFlip" || r.Frames[0].Line != 12 || r.Frames[1].Function != "" {
+		t.Errorf("unexpected frames %+v", r.Frames)
+	}
+
+	reports = errorLogOf(t, `[
+		{"name": "RangeError", "message": "Invalid time value", "stack": "renderFlip@https://sky.coflnet.com/flipper.js:3:10\nhydrate@https://sky.coflnet.com/main.js:1:1"},
+		{"msg": "Script error.", "url": "https://sky.coflnet.com/static/app.js?v=12", "lineno": 1},
+		{"error": {"message": "boom", "stackTrace": [{"function": "load", "file": "/src/Loader.cs", "line": 7}]}},
+		"System.NullReferenceException: Object reference not set to an instance of an object.\n   at Coflnet.Sky.Api.Controller.Get(String id) in /src/Controller.cs:line 42",
+		42,
+		""
+	]`)
+	if len(reports) != 4 {
+		t.Fatalf("expected four reports, got %+v", reports)
+	}
+	if reports[0].Type != "RangeError" || len(reports[0].Frames) != 2 || reports[0].Frames[0].Function != "renderFlip" {
+		t.Errorf("unexpected firefox report %+v", reports[0])
+	}
+	if reports[1].Message != "Script error." || reports[1].Source == "" || len(reports[1].Frames) != 0 {
+		t.Errorf("unexpected stackless report %+v", reports[1])
+	}
+	if reports[2].Message != "boom" || len(reports[2].Frames) != 1 || reports[2].Frames[0].Line != 7 {
+		t.Errorf("unexpected wrapped report %+v", reports[2])
+	}
+	dotnet := reports[3]
+	if dotnet.Type != "System.NullReferenceException" || len(dotnet.Frames) != 1 ||
+		dotnet.Frames[0].Function != "Coflnet.Sky.Api.Controller.Get" || dotnet.Frames[0].File != "/src/Controller.cs" {
+		t.Errorf("unexpected .NET report %+v", dotnet)
+	}
+
+	if reports := errorLogOf(t, `{"foo": "bar"}`); len(reports) != 0 {
+		t.Errorf("objects without message or stack are no errors, got %+v", reports)
+	}
+}
+
+func TestErrorFingerprint(t *testing.T) {
+	a, _ := parseErrorText(chromeStack)
+	// the next deploy: new bundle hash, moved lines, another item
+	b, _ := parseErrorText(strings.NewReplacer("3f9a1c2b7d", "77e0c1d2aa", ":12:345", ":14:90").Replace(chromeStack))
+	c, _ := parseErrorText("TypeError: Cannot read properties of null (reading 'price')\n    at renderFlip (https://sky.coflnet.com/flipper.js:1:1)")
+
+	ga, gb, gc := a.group("sky"), b.group("sky"), c.group("sky")
+	if ga.Fingerprint != gb.Fingerprint {
+		t.Errorf("builds of the same error must group together:\n%+v\n%+v", ga, gb)
+	}
+	if ga.Fingerprint == gc.Fingerprint {
+		t.Error("different messages must not group together")
+	}
+	if ga.Culprit != "renderFlip (flipper.js)" || len(ga.Fingerprint) != 32 {
+		t.Errorf("unexpected group %+v", ga)
+	}
+}
+
+func TestNormalizeErrorMessage(t *testing.T) {
+	for msg, want := range map[string]string{
+		"Failed to fetch https://sky.coflnet.com/api/item/5?x=1": "Failed to fetch <url>",
+		"Auction 5f1c2d3e-4f5a-6b7c-8d9e-0f1a2b3c4d5e not found": "Auction <id> not found",
+		"Request failed with status code 502\n  more details":    "Request failed with status code <n>",
+		"Unexpected token   '<' in JSON at position 0":           "Unexpected token '<' in JSON at position <n>",
+		"Player 3f9a1c2b7d4e6f80 is banned":                      "Player <id> is banned",
+	} {
+		if got := normalizeErrorMessage(msg); got != want {
+			t.Errorf("%q: got %q, want %q", msg, got, want)
+		}
+	}
+	for file, want := range map[string]string{
+		"https://sky.coflnet.com/_next/static/chunks/app.3f9a1c2b.js?v=1": "app.js",
+		"webpack:///./src/components/Flipper.tsx":                         "Flipper.tsx",
+		`C:\src\Controller.cs`:                                            "Controller.cs",
+		"app-facade.js":                                                   "app-facade.js",
+	} {
+		if got := normalizeFrameFile(file); got != want {
+			t.Errorf("%q: got %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestErrorCapture(t *testing.T) {
+	project := &Project{Slug: "sky", Notify: []NotifyTarget{{Type: "webhook"}}}
+	f := &Feedback{Payload: JSONB(`{"errorLog": ["TypeError: x is undefined"], "clientVersion": "1.4.2", "href": "https://sky.coflnet.com/auction/5f1c2d3e4f5a6b7c?x=1"}`)}
+
+	c := ErrorPolicy{}.capture(project, f)
+	if c == nil || len(c.Reports) != 1 || c.Version != "1.4.2" || c.Page != "/auction/:id" {
+		t.Fatalf("unexpected capture %+v", c)
+	}
+	if len(c.Alerts) != 1 || c.Alerts[0].Kind != OutboxErrorAlert {
+		t.Errorf("expected one alert per notify target, got %+v", c.Alerts)
+	}
+	if c := (ErrorPolicy{DisableAlerts: true, VersionKey: "build"}).capture(project, f); c == nil || len(c.Alerts) != 0 || c.Version != "" {
+		t.Errorf("policy not applied: %+v", c)
+	}
+	if c := (ErrorPolicy{}).capture(project, &Feedback{Payload: JSONB(`{"rating": 1}`)}); c != nil {
+		t.Errorf("feedback without errorLog captured %+v", c)
+	}
+}
+
+// sqlRecorder collects the statements a dry run would have executed.
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+func TestRecordErrorsSQL(t *testing.T) {
+	rec := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true, Logger: rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := parseErrorText(chromeStack)
+	f := &Feedback{Project: "sky"}
+	f.ID = 7
+	c := &ErrorCapture{Reports: []errorReport{r, r}, Version: "1.4.2", Page: "/flipper"}
+	if _, err := recordErrors(db, f, c, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	sql := strings.Join(rec.statements, "\n")
+	for _, part := range []string{
+		`ON CONFLICT ("project","fingerprint") DO NOTHING`,
+		`"occurrences"=occurrences + 1`,
+		`INSERT INTO "error_occurrences"`,
+		`ON CONFLICT ("error_group_id","dimension","value") DO UPDATE SET`,
+		`"occurrences"=error_group_counts.occurrences + 1`,
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("missing %q in\n%s", part, sql)
+		}
+	}
+	if n := strings.Count(sql, `INSERT INTO "error_groups"`); n != 1 {
+		t.Errorf("duplicate reports of one feedback must count once, got %d inserts", n)
+	}
+
+	stmt := (&FeedbackFilter{ErrorGroupID: 3}).apply(db.Model(&Feedback{})).Find(&[]Feedback{}).Statement
+	if !strings.Contains(stmt.SQL.String(), "id IN (SELECT feedback_id FROM error_occurrences WHERE error_group_id = $") {
+		t.Errorf("error filter not applied: %s", stmt.SQL.String())
+	}
+}
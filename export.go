@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/parquet-go/parquet-go"
+)
+
+// ExportFormat is an output format of the feedback export.
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportNDJSON  ExportFormat = "ndjson"
+	ExportParquet ExportFormat = "parquet"
+)
+
+// exportBatchSize is how many rows are read from the database at a time.
+// Together with the parquet row group size it bounds the memory an export
+// needs, independent of how many rows it covers.
+const (
+	exportBatchSize           = 500
+	exportParquetRowGroupSize = 10000
+)
+
+func parseExportFormat(s string) (ExportFormat, error) {
+	switch f := ExportFormat(s); f {
+	case ExportCSV, ExportNDJSON, ExportParquet:
+		return f, nil
+	case "":
+		return ExportCSV, nil
+	}
+	return "", fmt.Errorf("unknown export format %q, use csv, ndjson or parquet", s)
+}
+
+func (f ExportFormat) contentType() string {
+	switch f {
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportParquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// exportKind is the type of an export column.
+type exportKind int
+
+const (
+	exportString exportKind = iota
+	exportInt
+	exportFloat
+	exportBool
+	exportTime
+)
+
+type exportColumn struct {
+	Name string
+	Kind exportKind
+}
+
+// exportBaseColumns are written for every row, before the payload columns.
+var exportBaseColumns = []exportColumn{
+	{"id", exportInt},
+	{"createdAt", exportTime},
+	{"project", exportString},
+	{"feedbackName", exportString},
+	{"user", exportString},
+	{"context", exportString},
+	{"timestamp", exportTime},
+	{"status", exportString},
+	{"assignee", exportString},
+	{"resolutionNote", exportString},
+	{"spam", exportBool},
+	{"spamScore", exportInt},
+	{"duplicateCount", exportInt},
+	{"additionalInformation", exportString},
+}
+
+// exportPayloadPrefix prefixes the flattened payload keys so they can't
+// clash with the base columns.
+const exportPayloadPrefix = "payload."
+
+// feedbackIterator calls fn for every feedback of an export, in order. It
+// stops at the first error fn returns.
+type feedbackIterator func(fn func(*Feedback) error) error
+
+// EachFeedback walks all feedback matching the filter, newest first, one
+// page at a time. The filter's Limit is the batch size.
+func (d *DatabaseHandler) EachFeedback(filter FeedbackFilter, fn func(*Feedback) error) error {
+	filter.Limit = exportBatchSize
+	for {
+		page, err := d.ListFeedback(&filter)
+		if err != nil {
+			return err
+		}
+		for i := range page.Items {
+			if err := fn(&page.Items[i]); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		last := page.Items[len(page.Items)-1]
+		filter.Cursor = &feedbackCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+}
+
+// exportRecord flattens a feedback into column name -> value. Nested payload
+// objects become dotted columns, arrays are kept as JSON text.
+func exportRecord(f *Feedback) map[string]interface{} {
+	r := map[string]interface{}{
+		"id":                    int64(f.ID),
+		"createdAt":             f.CreatedAt,
+		"project":               f.Project,
+		"feedbackName":          f.FeedbackName,
+		"user":                  f.User,
+		"context":               f.Context,
+		"timestamp":             f.Timestamp,
+		"status":                string(f.Status),
+		"assignee":              f.Assignee,
+		"resolutionNote":        f.ResolutionNote,
+		"spam":                  f.Spam,
+		"spamScore":             int64(f.SpamScore),
+		"duplicateCount":        int64(f.DuplicateCount),
+		"additionalInformation": f.AdditionalInformations,
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(f.Payload, &payload); err == nil {
+		for k, v := range payload {
+			flattenExportValue(exportPayloadPrefix+k, v, r)
+		}
+	}
+	return r
+}
+
+func flattenExportValue(name string, v interface{}, out map[string]interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, inner := range val {
+			flattenExportValue(name+"."+k, inner, out)
+		}
+	case []interface{}:
+		b, _ := json.Marshal(val)
+		out[name] = string(b)
+	case nil:
+	default:
+		out[name] = val
+	}
+}
+
+// exportValueKind maps a flattened payload value to a column kind.
+func exportValueKind(v interface{}) exportKind {
+	switch v.(type) {
+	case float64:
+		return exportFloat
+	case bool:
+		return exportBool
+	}
+	return exportString
+}
+
+// collectExportColumns makes a first pass over the export to learn which
+// payload columns exist and their types. A key seen with different types is
+// exported as text.
+func collectExportColumns(each feedbackIterator) ([]exportColumn, error) {
+	kinds := make(map[string]exportKind)
+	err := each(func(f *Feedback) error {
+		for name, v := range exportRecord(f) {
+			if !strings.HasPrefix(name, exportPayloadPrefix) {
+				continue
+			}
+			kind := exportValueKind(v)
+			if prev, ok := kinds[name]; ok && prev != kind {
+				kind = exportString
+			}
+			kinds[name] = kind
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	columns := slices.Clone(exportBaseColumns)
+	for _, name := range names {
+		columns = append(columns, exportColumn{Name: name, Kind: kinds[name]})
+	}
+	return columns, nil
+}
+
+// exportWriter writes export records in one format.
+type exportWriter interface {
+	Write(record map[string]interface{}) error
+	Close() error
+}
+
+// exportFeedback streams every feedback of the iterator to w. CSV and
+// Parquet need their columns up front, which costs an extra pass.
+func exportFeedback(w io.Writer, format ExportFormat, each feedbackIterator) (int, error) {
+	var columns []exportColumn
+	if format != ExportNDJSON {
+		var err error
+		if columns, err = collectExportColumns(each); err != nil {
+			return 0, err
+		}
+	}
+	return writeFeedbackExport(w, format, columns, each)
+}
+
+// writeFeedbackExport writes the export with columns from
+// collectExportColumns (unused for NDJSON).
+func writeFeedbackExport(w io.Writer, format ExportFormat, columns []exportColumn, each feedbackIterator) (int, error) {
+	var ew exportWriter
+	switch format {
+	case ExportCSV:
+		ew = newCSVExportWriter(w, columns)
+	case ExportNDJSON:
+		ew = &ndjsonExportWriter{enc: json.NewEncoder(w)}
+	case ExportParquet:
+		ew = newParquetExportWriter(w, columns)
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	n := 0
+	err := each(func(f *Feedback) error {
+		n++
+		return ew.Write(exportRecord(f))
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, ew.Close()
+}
+
+// exportText renders a value for text formats.
+func exportText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case time.Time:
+		if val.IsZero() {
+			return ""
+		}
+		return val.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+type csvExportWriter struct {
+	w       *csv.Writer
+	columns []exportColumn
+	header  bool
+	row     []string
+}
+
+func newCSVExportWriter(w io.Writer, columns []exportColumn) *csvExportWriter {
+	return &csvExportWriter{w: csv.NewWriter(w), columns: columns, row: make([]string, len(columns))}
+}
+
+func (e *csvExportWriter) writeHeader() error {
+	e.header = true
+	for i, c := range e.columns {
+		e.row[i] = c.Name
+	}
+	return e.w.Write(e.row)
+}
+
+func (e *csvExportWriter) Write(record map[string]interface{}) error {
+	if !e.header {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+	for i, c := range e.columns {
+		e.row[i] = csvSafeCell(exportText(record[c.Name]))
+	}
+	return e.w.Write(e.row)
+}
+
+// csvSafeCell keeps spreadsheets from evaluating submitted text: a cell
+// starting like a formula gets a leading ' so it is shown as text. Numbers
+// such as a negative rating stay as they are.
+func csvSafeCell(s string) string {
+	if s == "" || !strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return s
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s
+	}
+	return "'" + s
+}
+
+func (e *csvExportWriter) Close() error {
+	// an empty export still gets its header
+	if !e.header {
+		if err := e.writeHeader(); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonExportWriter writes one JSON object per line. Times are RFC 3339,
+// everything else keeps its JSON type.
+type ndjsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExportWriter) Write(record map[string]interface{}) error {
+	for k, v := range record {
+		if t, ok := v.(time.Time); ok {
+			record[k] = exportText(t)
+		}
+	}
+	return e.enc.Encode(record)
+}
+
+func (e *ndjsonExportWriter) Close() error { return nil }
+
+// parquetExportWriter writes a flat schema with one optional column per
+// export column. Rows are buffered per row group only.
+type parquetExportWriter struct {
+	w       *parquet.Writer
+	columns []exportColumn
+	// index maps the position in columns to the leaf index in the schema,
+	// which orders columns by name
+	index []int
+}
+
+func newParquetExportWriter(w io.Writer, columns []exportColumn) *parquetExportWriter {
+	group := make(parquet.Group, len(columns))
+	for _, c := range columns {
+		var node parquet.Node
+		switch c.Kind {
+		case exportInt:
+			node = parquet.Int(64)
+		case exportFloat:
+			node = parquet.Leaf(parquet.DoubleType)
+		case exportBool:
+			node = parquet.Leaf(parquet.BooleanType)
+		case exportTime:
+			node = parquet.Timestamp(parquet.Millisecond)
+		default:
+			node = parquet.String()
+		}
+		group[c.Name] = parquet.Optional(node)
+	}
+	schema := parquet.NewSchema("feedback", group)
+
+	index := make([]int, len(columns))
+	for i, c := range columns {
+		leaf, _ := schema.Lookup(c.Name)
+		index[i] = leaf.ColumnIndex
+	}
+	return &parquetExportWriter{
+		w:       parquet.NewWriter(w, schema, parquet.MaxRowsPerRowGroup(exportParquetRowGroupSize)),
+		columns: columns,
+		index:   index,
+	}
+}
+
+func (e *parquetExportWriter) Write(record map[string]interface{}) error {
+	row := make(parquet.Row, len(e.columns))
+	for i, c := range e.columns {
+		col := e.index[i]
+		v, ok := parquetExportValue(c.Kind, record[c.Name])
+		if !ok {
+			row[col] = parquet.NullValue().Level(0, 0, col)
+			continue
+		}
+		row[col] = v.Level(0, 1, col)
+	}
+	_, err := e.w.WriteRows([]parquet.Row{row})
+	return err
+}
+
+func (e *parquetExportWriter) Close() error {
+	return e.w.Close()
+}
+
+// parquetExportValue converts a record value for a column of the given kind.
+// Missing values and zero times are null.
+func parquetExportValue(kind exportKind, v interface{}) (parquet.Value, bool) {
+	if v == nil {
+		return parquet.Value{}, false
+	}
+	switch kind {
+	case exportInt:
+		if n, ok := v.(int64); ok {
+			return parquet.Int64Value(n), true
+		}
+	case exportFloat:
+		if f, ok := v.(float64); ok {
+			return parquet.DoubleValue(f), true
+		}
+	case exportBool:
+		if b, ok := v.(bool); ok {
+			return parquet.BooleanValue(b), true
+		}
+	case exportTime:
+		if t, ok := v.(time.Time); ok && !t.IsZero() {
+			return parquet.Int64Value(t.UnixMilli()), true
+		}
+		return parquet.Value{}, false
+	}
+	return parquet.ByteArrayValue([]byte(exportText(v))), true
+}
+
+// pinExportRange fixes an open upper bound to now, so both passes of an
+// export see the same rows even while new feedback arrives.
+func pinExportRange(filter *FeedbackFilter, now time.Time) {
+	if filter.CreatedTo.IsZero() {
+		filter.CreatedTo = now
+	}
+}
+
+// exportFeedbackRequest streams the feedback matching the listing filters.
+// The column pass runs before the response starts so its errors still turn
+// into a proper status; a failure while streaming truncates the file.
+func (h *ApiHandler) exportFeedbackRequest(c *fiber.Ctx) error {
+	format, err := parseExportFormat(c.Query("format"))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	filter, err := parseFeedbackFilter(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	pinExportRange(filter, time.Now())
+	each := func(fn func(*Feedback) error) error {
+		return h.store.EachFeedback(*filter, fn)
+	}
+	var columns []exportColumn
+	if format != ExportNDJSON {
+		if columns, err = collectExportColumns(each); err != nil {
+			slog.Error("could not prepare feedback export", "err", err)
+			errorsCounter.WithLabelValues("").Inc()
+			return fiber.NewError(http.StatusInternalServerError, "could not export feedback")
+		}
+	}
+	// only a registered slug goes into the header, the filter is any string
+	name := "feedback"
+	if p := h.projects.Get(filter.Project); p != nil {
+		name += "-" + p.Slug
+	}
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format("20060102"), format)
+	c.Set(fiber.HeaderContentType, format.contentType())
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		n, err := writeFeedbackExport(w, format, columns, each)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			slog.Error("feedback export aborted", "format", format, "rows", n, "err", err)
+			errorsCounter.WithLabelValues("").Inc()
+			return
+		}
+		slog.Info("exported feedback", "format", format, "rows", n)
+	})
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"mime"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/parquet-go/parquet-go"
+	"gorm.io/gorm"
+)
+
+func exportFixture() feedbackIterator {
+	created := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	items := []Feedback{
+		{Model: gorm.Model{ID: 2, CreatedAt: created}, Project: "sky", TriageState: TriageState{Status: StatusNew},
+			Payload: JSONB(`{"rating":4,"href":"https://sky.coflnet.com/","browser":{"name":"firefox"},"tags":["a","b"]}`)},
+		{Model: gorm.Model{ID: 1, CreatedAt: created.Add(-time.Hour)}, Project: "sky", TriageState: TriageState{Status: StatusResolved},
+			Payload: JSONB(`{"rating":"five","somethingBroke":true}`)},
+	}
+	return func(fn func(*Feedback) error) error {
+		for i := range items {
+			if err := fn(&items[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestExportColumns(t *testing.T) {
+	columns, err := collectExportColumns(exportFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]exportKind{}
+	for _, c := range columns[len(exportBaseColumns):] {
+		kinds[c.Name] = c.Kind
+	}
+	want := map[string]exportKind{
+		"payload.browser.name":   exportString,
+		"payload.href":           exportString,
+		"payload.rating":         exportString, // number in one row, text in the other
+		"payload.somethingBroke": exportBool,
+		"payload.tags":           exportString,
+	}
+	if len(kinds) != len(want) {
+		t.Errorf("unexpected payload columns %v", kinds)
+	}
+	for name, kind := range want {
+		if kinds[name] != kind {
+			t.Errorf("%s: expected kind %d, got %d", name, kind, kinds[name])
+		}
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := exportFeedback(&buf, ExportCSV, exportFixture())
+	if err != nil || n != 2 {
+		t.Fatalf("export failed: %d rows, %v", n, err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected header and 2 rows, got %d", len(rows))
+	}
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	if got := rows[1][col["payload.browser.name"]]; got != "firefox" {
+		t.Errorf("nested key not flattened, got %q", got)
+	}
+	if got := rows[1][col["payload.tags"]]; got != `["a","b"]` {
+		t.Errorf("arrays should be JSON, got %q", got)
+	}
+	if got := rows[1][col["createdAt"]]; got != "2026-09-01T12:00:00Z" {
+		t.Errorf("unexpected createdAt %q", got)
+	}
+	if got := rows[2][col["payload.href"]]; got != "" {
+		t.Errorf("missing keys should be empty, got %q", got)
+	}
+}
+
+func TestExportCSVEscapesFormulas(t *testing.T) {
+	items := []Feedback{{Project: "sky", Payload: JSONB(`{"a":"=HYPERLINK(\"http://evil.example\")","b":"+1+1","c":"@SUM(A1)","d":"-2+3","e":"\tx","f":"\r=1","g":-3,"h":"fine = ok"}`)}}
+	iter := func(fn func(*Feedback) error) error { return fn(&items[0]) }
+
+	var buf bytes.Buffer
+	if _, err := exportFeedback(&buf, ExportCSV, iter); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	for key, want := range map[string]string{
+		"a": `'=HYPERLINK("http://evil.example")`,
+		"b": "'+1+1",
+		"c": "'@SUM(A1)",
+		"d": "'-2+3",
+		"e": "'\tx",
+		"f": "'\r=1",
+		"g": "-3",
+		"h": "fine = ok",
+	} {
+		if got := rows[1][col["payload."+key]]; got != want {
+			t.Errorf("payload.%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// the other formats keep the text as it was submitted
+	buf.Reset()
+	if _, err := exportFeedback(&buf, ExportNDJSON, iter); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"=HYPERLINK`) {
+		t.Errorf("ndjson changed the text: %s", buf.String())
+	}
+}
+
+func TestExportCSVEmptyHasHeader(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := exportFeedback(&buf, ExportCSV, func(fn func(*Feedback) error) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "id,createdAt,project") {
+		t.Errorf("expected a header, got %q", buf.String())
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := exportFeedback(&buf, ExportNDJSON, exportFixture()); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	var lines []map[string]interface{}
+	for scanner.Scan() {
+		var m map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, m)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	if lines[0]["payload.rating"] != 4.0 || lines[1]["payload.somethingBroke"] != true {
+		t.Errorf("payload values should keep their JSON type: %v", lines)
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := exportFeedback(&buf, ExportParquet, exportFixture()); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.NumRows() != 2 {
+		t.Errorf("expected 2 rows, got %d", file.NumRows())
+	}
+	leaf, ok := file.Schema().Lookup("payload.somethingBroke")
+	if !ok {
+		t.Fatal("payload.somethingBroke column missing")
+	}
+	if leaf.Node.Type().Kind() != parquet.Boolean {
+		t.Errorf("expected a boolean column, got %v", leaf.Node.Type())
+	}
+
+	rows := make([]parquet.Row, 2)
+	reader := parquet.NewReader(file)
+	if n, _ := reader.ReadRows(rows); n != 2 {
+		t.Fatalf("read %d rows", n)
+	}
+	if v := rows[1][leaf.ColumnIndex]; v.IsNull() || !v.Boolean() {
+		t.Errorf("unexpected somethingBroke value %v", v)
+	}
+	if v := rows[0][leaf.ColumnIndex]; !v.IsNull() {
+		t.Errorf("missing value should be null, got %v", v)
+	}
+}
+
+func TestExportFilename(t *testing.T) {
+	a := newTestApi(t)
+	date := time.Now().UTC().Format("20060102")
+	for query, want := range map[string]string{
+		"project=sky":                           "feedback-sky-" + date + ".ndjson",
+		"project=" + url.QueryEscape(`x"; a=b`): "feedback-" + date + ".ndjson",
+	} {
+		req := httptest.NewRequest("GET", "/api/feedback/export?format=ndjson&"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+a.admin)
+		resp, err := a.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		disposition, params, err := mime.ParseMediaType(resp.Header.Get(fiber.HeaderContentDisposition))
+		if err != nil || disposition != "attachment" || len(params) != 1 || params["filename"] != want {
+			t.Errorf("%s: Content-Disposition %q", query, resp.Header.Get(fiber.HeaderContentDisposition))
+		}
+	}
+}
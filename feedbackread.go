@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// feedbackPageDefault and feedbackPageMax bound how many rows a single
+// GET /api/feedback call returns.
+const (
+	feedbackPageDefault = 50
+	feedbackPageMax     = 500
+)
+
+// FeedbackFilter narrows down a feedback listing. Empty fields are ignored.
+// Time bounds are inclusive on the lower and exclusive on the upper end.
+type FeedbackFilter struct {
+	Project      string
+	Status       FeedbackStatus
+	Assignee     string
+	Spam         *bool
+	Context      string
+	FeedbackName string
+	User         string
+	IssueID      uint
+	ErrorGroupID uint
+
+	TimestampFrom time.Time
+	TimestampTo   time.Time
+	CreatedFrom   time.Time
+	CreatedTo     time.Time
+
+	// Payload holds conditions on the parsed feedback payload.
+	Payload []PayloadFilter
+
+	Cursor *feedbackCursor
+	Limit  int
+}
+
+// FeedbackPage is one page of a feedback listing. NextCursor is empty once the
+// last page was reached.
+type FeedbackPage struct {
+	Items      []Feedback `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// feedbackCursor points at the last row of the previous page. Listings are
+// ordered by (created_at, id) descending, which stays stable while new rows
+// are inserted at the top.
+type feedbackCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+func (c feedbackCursor) encode() string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeFeedbackCursor(s string) (*feedbackCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	return &feedbackCursor{CreatedAt: time.Unix(0, nanos).UTC(), ID: uint(n)}, nil
+}
+
+// parseFeedbackFilter reads the listing query parameters. Times are RFC 3339.
+func parseFeedbackFilter(c *fiber.Ctx) (*FeedbackFilter, error) {
+	f, err := parseFeedbackConditions(c)
+	if err != nil {
+		return nil, err
+	}
+	if v := c.Query("cursor"); v != "" {
+		cur, err := decodeFeedbackCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		f.Cursor = cur
+	}
+	return f, nil
+}
+
+// parseFeedbackConditions reads the listing query parameters except the
+// cursor, which depends on the order of the listing.
+func parseFeedbackConditions(c *fiber.Ctx) (*FeedbackFilter, error) {
+	f := &FeedbackFilter{
+		Project:      c.Query("project"),
+		Status:       FeedbackStatus(c.Query("status")),
+		Assignee:     c.Query("assignee"),
+		Context:      c.Query("context"),
+		FeedbackName: c.Query("feedbackName"),
+		User:         c.Query("user"),
+		Limit:        feedbackPageDefault,
+	}
+
+	times := []struct {
+		param string
+		dst   *time.Time
+	}{
+		{"timestampFrom", &f.TimestampFrom},
+		{"timestampTo", &f.TimestampTo},
+		{"createdFrom", &f.CreatedFrom},
+		{"createdTo", &f.CreatedTo},
+	}
+	for _, t := range times {
+		v := c.Query(t.param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", t.param)
+		}
+		*t.dst = parsed
+	}
+
+	if v := c.Query("spam"); v != "" {
+		spam, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("spam must be true or false")
+		}
+		f.Spam = &spam
+	}
+
+	if v := c.Query("issue"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || id == 0 {
+			return nil, errors.New("issue must be a positive integer")
+		}
+		f.IssueID = uint(id)
+	}
+
+	if v := c.Query("error"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || id == 0 {
+			return nil, errors.New("error must be a positive integer")
+		}
+		f.ErrorGroupID = uint(id)
+	}
+
+	if f.Status != "" && !f.Status.valid() {
+		return nil, fmt.Errorf("unknown status %q", f.Status)
+	}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("limit must be a positive integer")
+		}
+		f.Limit = min(n, feedbackPageMax)
+	}
+
+	payload, err := parsePayloadFilters(c)
+	if err != nil {
+		return nil, err
+	}
+	f.Payload = payload
+
+	return f, nil
+}
+
+func (h *ApiHandler) listFeedbackRequest(c *fiber.Ctx) error {
+	filter, err := parseFeedbackFilter(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	page, err := h.store.ListFeedback(filter)
+	if err != nil {
+		slog.Error("could not list feedback", "err", err)
+		errorsCounter.WithLabelValues(h.projects.metricLabel(filter.Project)).Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not list feedback")
+	}
+
+	return c.JSON(page)
+}
+
+func (h *ApiHandler) getFeedbackRequest(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+
+	f, err := h.store.GetFeedback(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fiber.NewError(http.StatusNotFound, "feedback not found")
+		}
+		slog.Error("could not load feedback", "id", id, "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not load feedback")
+	}
+
+	return c.JSON(f)
+}
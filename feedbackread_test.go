@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFeedbackCursorRoundTrip(t *testing.T) {
+	in := feedbackCursor{CreatedAt: time.Date(2025, 3, 1, 12, 30, 0, 123456789, time.UTC), ID: 987654321}
+	out, err := decodeFeedbackCursor(in.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.CreatedAt.Equal(in.CreatedAt) || out.ID != in.ID {
+		t.Fatalf("cursor changed on round trip: %+v -> %+v", in, *out)
+	}
+	if _, err := decodeFeedbackCursor("not-a-cursor"); err == nil {
+		t.Error("expected malformed cursor to be rejected")
+	}
+}
+
+func TestParseFeedbackFilter(t *testing.T) {
+	var got *FeedbackFilter
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		f, err := parseFeedbackFilter(c)
+		if err != nil {
+			return fiber.NewError(400, err.Error())
+		}
+		got = f
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/?context=sky&user=u1&timestampFrom=2025-01-01T00:00:00Z&limit=10000", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	if got.Context != "sky" || got.User != "u1" || got.TimestampFrom.Year() != 2025 {
+		t.Errorf("filter not parsed: %+v", got)
+	}
+	if got.Limit != feedbackPageMax {
+		t.Errorf("limit should be capped at %d, got %d", feedbackPageMax, got.Limit)
+	}
+
+	req = httptest.NewRequest("GET", "/?createdTo=yesterday", nil)
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("expected 400 for a bad time, got %d", resp.StatusCode)
+	}
+}
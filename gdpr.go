@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// DataSubject identifies the person a data-subject request is about. User
+// matches Feedback.User, Email matches contact messages and, since some
+// clients send the email as user id, Feedback.User as well.
+type DataSubject struct {
+	User  string `json:"user"`
+	Email string `json:"email"`
+}
+
+func (s *DataSubject) normalize() error {
+	s.User = strings.TrimSpace(s.User)
+	s.Email = strings.TrimSpace(s.Email)
+	if s.User == "" && s.Email == "" {
+		return &FeedbackValidationError{Reason: "user or email is required"}
+	}
+	return nil
+}
+
+// userIDs are the Feedback.User values belonging to the subject.
+func (s DataSubject) userIDs() []string {
+	var ids []string
+	if s.User != "" {
+		ids = append(ids, s.User)
+	}
+	if s.Email != "" && s.Email != s.User {
+		ids = append(ids, s.Email)
+	}
+	return ids
+}
+
+func (s DataSubject) hash() string {
+	return auditSubjectHash(s.User, s.Email)
+}
+
+// DataSubjectBundle is everything stored about a subject. Soft deleted
+// feedback is included since it is still held until purged.
+type DataSubjectBundle struct {
+	Subject         DataSubject      `json:"subject"`
+	GeneratedAt     time.Time        `json:"generatedAt"`
+	Feedback        []Feedback       `json:"feedback"`
+	Notes           []FeedbackNote   `json:"notes"`
+	ContactMessages []ContactMessage `json:"contactMessages"`
+}
+
+// ErasureMode is how erase handles a subject's feedback. Contact messages
+// are always deleted, they consist of nothing but personal data.
+type ErasureMode string
+
+const (
+	// ErasureDelete removes the feedback for good, right away.
+	ErasureDelete ErasureMode = "delete"
+	// ErasureAnonymize strips every personal field but keeps the rest for
+	// statistics, like the retention policy does.
+	ErasureAnonymize ErasureMode = "anonymize"
+)
+
+func (m ErasureMode) valid() bool {
+	return m == ErasureDelete || m == ErasureAnonymize
+}
+
+// ErasureResult counts what an erasure touched.
+type ErasureResult struct {
+	Mode                   ErasureMode `json:"mode"`
+	FeedbackDeleted        int64       `json:"feedbackDeleted"`
+	FeedbackAnonymized     int64       `json:"feedbackAnonymized"`
+	ContactMessagesDeleted int64       `json:"contactMessagesDeleted"`
+}
+
+func subjectFeedback(tx *gorm.DB, s DataSubject) *gorm.DB {
+	ids := s.userIDs()
+	if len(ids) == 0 {
+		return tx.Unscoped().Model(&Feedback{}).Where("1 = 0")
+	}
+	return tx.Unscoped().Model(&Feedback{}).Where("\"user\" IN ?", ids)
+}
+
+func subjectContacts(tx *gorm.DB, s DataSubject) *gorm.DB {
+	if s.Email == "" {
+		return tx.Unscoped().Model(&ContactMessage{}).Where("1 = 0")
+	}
+	return tx.Unscoped().Model(&ContactMessage{}).Where("lower(email) = lower(?)", s.Email)
+}
+
+// ExportDataSubject collects everything stored about the subject and
+// records the export in the audit log.
+func (d *DatabaseHandler) ExportDataSubject(s DataSubject, actor string) (*DataSubjectBundle, error) {
+	bundle := &DataSubjectBundle{
+		Subject:         s,
+		GeneratedAt:     time.Now().UTC(),
+		Feedback:        []Feedback{},
+		Notes:           []FeedbackNote{},
+		ContactMessages: []ContactMessage{},
+	}
+	if err := subjectFeedback(d.db, s).Order("id").Find(&bundle.Feedback).Error; err != nil {
+		return nil, err
+	}
+	if len(bundle.Feedback) > 0 {
+		ids := make([]uint, len(bundle.Feedback))
+		for i, f := range bundle.Feedback {
+			ids[i] = f.ID
+		}
+		if err := d.db.Where("feedback_id IN ?", ids).Order("id").Find(&bundle.Notes).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := subjectContacts(d.db, s).Order("id").Find(&bundle.ContactMessages).Error; err != nil {
+		return nil, err
+	}
+
+	err := d.RecordAudit(nil, "gdpr.export", actor, s.hash(), map[string]int{
+		"feedback":        len(bundle.Feedback),
+		"notes":           len(bundle.Notes),
+		"contactMessages": len(bundle.ContactMessages),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// EraseDataSubject deletes or anonymizes the subject's data in one
+// transaction, together with its audit event.
+func (d *DatabaseHandler) EraseDataSubject(s DataSubject, mode ErasureMode, actor string) (*ErasureResult, error) {
+	if !mode.valid() {
+		return nil, &FeedbackValidationError{Reason: fmt.Sprintf("unknown mode %q, use delete or anonymize", mode)}
+	}
+	result := &ErasureResult{Mode: mode}
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var feedback []Feedback
+		if err := subjectFeedback(tx, s).Find(&feedback).Error; err != nil {
+			return err
+		}
+
+		switch mode {
+		case ErasureDelete:
+			if len(feedback) > 0 {
+				ids := make([]uint, len(feedback))
+				for i, f := range feedback {
+					ids[i] = f.ID
+				}
+				if err := hardDeleteFeedback(tx, ids); err != nil {
+					return err
+				}
+			}
+			result.FeedbackDeleted = int64(len(feedback))
+		case ErasureAnonymize:
+			now := time.Now()
+			ids := make([]uint, len(feedback))
+			for i := range feedback {
+				ids[i] = feedback[i].ID
+				changes := stripFeedback(&feedback[i], strippableFields, now)
+				// the dedup hash covers the user id, so it could confirm who wrote it
+				changes["content_hash"] = ""
+				if err := tx.Unscoped().Model(&Feedback{}).Where("id = ?", feedback[i].ID).Updates(changes).Error; err != nil {
+					return err
+				}
+				// notes may quote the person, they go either way
+				if err := tx.Unscoped().Where("feedback_id = ?", feedback[i].ID).Delete(&FeedbackNote{}).Error; err != nil {
+					return err
+				}
+			}
+			if err := detachStrippedFeedback(tx, ids, strippableFields); err != nil {
+				return err
+			}
+			result.FeedbackAnonymized = int64(len(feedback))
+		}
+
+		res := subjectContacts(tx, s).Delete(&ContactMessage{})
+		if res.Error != nil {
+			return res.Error
+		}
+		result.ContactMessagesDeleted = res.RowsAffected
+
+		return d.RecordAudit(tx, "gdpr.erase", actor, s.hash(), result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// gdprRequest is the body of the data-subject endpoints. The identifiers
+// travel in the body so they don't end up in access logs.
+type gdprRequest struct {
+	DataSubject
+	Mode ErasureMode `json:"mode"`
+}
+
+func parseGDPRRequest(c *fiber.Ctx) (*gdprRequest, error) {
+	var req gdprRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, fiber.NewError(http.StatusBadRequest, "invalid body")
+	}
+	if err := req.normalize(); err != nil {
+		return nil, fiber.NewError(http.StatusUnprocessableEntity, err.Error())
+	}
+	return &req, nil
+}
+
+func (h *ApiHandler) gdprExportRequest(c *fiber.Ctx) error {
+	req, err := parseGDPRRequest(c)
+	if err != nil {
+		return err
+	}
+	bundle, err := h.store.ExportDataSubject(req.DataSubject, actorOf(c))
+	if err != nil {
+		slog.Error("could not export data subject", "err", err)
+		return fiber.NewError(http.StatusInternalServerError, "could not export data")
+	}
+	slog.Info("exported data subject", "subject", req.hash(), "by", actorOf(c))
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="data-subject-export.json"`)
+	return c.JSON(bundle)
+}
+
+func (h *ApiHandler) gdprEraseRequest(c *fiber.Ctx) error {
+	req, err := parseGDPRRequest(c)
+	if err != nil {
+		return err
+	}
+	result, err := h.store.EraseDataSubject(req.DataSubject, req.Mode, actorOf(c))
+	var invalid *FeedbackValidationError
+	if errors.As(err, &invalid) {
+		return fiber.NewError(http.StatusUnprocessableEntity, invalid.Reason)
+	}
+	if err != nil {
+		slog.Error("could not erase data subject", "err", err)
+		return fiber.NewError(http.StatusInternalServerError, "could not erase data")
+	}
+	slog.Info("erased data subject", "subject", req.hash(), "mode", req.Mode, "by", actorOf(c))
+	return c.JSON(result)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDataSubjectIdentifiers(t *testing.T) {
+	s := DataSubject{User: " 42 ", Email: " Bob@Example.com "}
+	if err := s.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	ids := s.userIDs()
+	if len(ids) != 2 || ids[0] != "42" || ids[1] != "Bob@Example.com" {
+		t.Errorf("unexpected ids %v", ids)
+	}
+
+	same := DataSubject{Email: "bob@example.com", User: "bob@example.com"}
+	if ids := same.userIDs(); len(ids) != 1 {
+		t.Errorf("identical user and email should match once, got %v", ids)
+	}
+
+	empty := DataSubject{User: "  "}
+	if err := empty.normalize(); err == nil {
+		t.Error("a subject without identifiers must be rejected, it would match every anonymous row")
+	}
+}
+
+func TestAuditSubjectHash(t *testing.T) {
+	if auditSubjectHash("", "Bob@Example.com") != auditSubjectHash("", "bob@example.com ") {
+		t.Error("email case and whitespace must not change the hash")
+	}
+	if auditSubjectHash("bob", "") == auditSubjectHash("", "bob") {
+		t.Error("user and email must be kept apart")
+	}
+	if strings.Contains(auditSubjectHash("bob", "bob@example.com"), "bob") {
+		t.Error("hash leaks the subject")
+	}
+}
+
+func TestGDPRRequestValidation(t *testing.T) {
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		req, err := parseGDPRRequest(c)
+		if err != nil {
+			return err
+		}
+		if !req.Mode.valid() {
+			return fiber.NewError(422, "mode")
+		}
+		return c.SendStatus(204)
+	})
+	for body, want := range map[string]int{
+		`{}`:                                   422,
+		`{"user":"42","mode":"delete"}`:        204,
+		`{"email":"a@b.c","mode":"anonymize"}`: 204,
+		`{"user":"42","mode":"shred"}`:         422,
+		`not json`:                             400,
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", body, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestEraseDataSubjectCleansIssuesAndErrorGroups(t *testing.T) {
+	db := connectSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	defer db.Close()
+	project := &Project{Slug: "sky", Notify: []NotifyTarget{{Type: "webhook"}}}
+	save := func(user, text, errorLog string) *Feedback {
+		t.Helper()
+		f := &Feedback{
+			Project:                "sky",
+			User:                   user,
+			AdditionalInformations: text,
+			Payload:                JSONB(`{"href": "https://sky.coflnet.com/flipper", "errorLog": ["` + errorLog + `"]}`),
+			TriageState:            TriageState{Status: StatusNew},
+		}
+		if err := db.SaveFeedback(context.Background(), f, nil, ErrorPolicy{}.capture(project, f)); err != nil {
+			t.Fatal(err)
+		}
+		if f.IssueID == nil {
+			t.Fatalf("%s's feedback opened no issue", user)
+		}
+		return f
+	}
+	alice := save("alice", "auction page crashes loading flipper settings yesterday", "TypeError: alice is undefined")
+	bob := save("bob", "auction page crashes loading flipper settings", "TypeError: alice is undefined")
+	carol := save("carol", "pricing charts never render their candles", "RangeError: carol out of range")
+	if *bob.IssueID != *alice.IssueID || *carol.IssueID == *alice.IssueID {
+		t.Fatalf("unexpected issues %d, %d, %d", *alice.IssueID, *bob.IssueID, *carol.IssueID)
+	}
+
+	if _, err := db.EraseDataSubject(DataSubject{User: "alice"}, ErasureDelete, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	issue, err := db.GetIssue(*bob.IssueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issue.Occurrences != 1 || issue.Title != "auction page crashes loading flipper settings" {
+		t.Errorf("issue kept the erased opener: %+v", issue)
+	}
+	var group ErrorGroup
+	if err := db.db.Where("project = ? AND message LIKE ?", "sky", "%alice%").First(&group).Error; err != nil {
+		t.Fatal(err)
+	}
+	if group.Occurrences != 1 || group.FirstFeedbackID != bob.ID {
+		t.Errorf("error group kept the erased feedback: %+v", group)
+	}
+
+	// carol was alone in her issue and error group, nothing of hers may stay
+	if _, err := db.EraseDataSubject(DataSubject{User: "carol"}, ErasureAnonymize, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	var issues, groups, alerts int64
+	db.db.Unscoped().Model(&Issue{}).Where("id = ?", *carol.IssueID).Count(&issues)
+	db.db.Unscoped().Model(&ErrorGroup{}).Where("message LIKE ?", "%carol%").Count(&groups)
+	db.db.Unscoped().Model(&OutboxMessage{}).Where("kind = ? AND feedback_id = ?", OutboxErrorAlert, carol.ID).Count(&alerts)
+	if issues != 0 || groups != 0 || alerts != 0 {
+		t.Errorf("after erasing carol: %d issues, %d error groups, %d alerts", issues, groups, alerts)
+	}
+	if f, err := db.GetFeedback(carol.ID); err != nil || f.IssueID != nil {
+		t.Errorf("anonymized feedback still in an issue: %+v, %v", f, err)
+	}
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+var issueAssignmentsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "feedback_issue_assignments_total",
+	Help: "feedback grouped into issues, by whether it opened a new issue, joined one or reopened a closed one",
+}, []string{"project", "result"})
+
+const (
+	// defaultIssueWindow is how long an issue takes new feedback after it
+	// was last seen, when ISSUE_WINDOW is not set.
+	defaultIssueWindow = 30 * 24 * time.Hour
+	// defaultIssueSimilarity is the estimated Jaccard similarity from which
+	// feedback joins an issue, when ISSUE_SIMILARITY is not set.
+	defaultIssueSimilarity = 0.5
+	// minHashSize is the number of hashes in a signature. The similarity
+	// estimate is off by about 1/sqrt(minHashSize).
+	minHashSize = 64
+	// minIssueWords is how many distinct words feedback needs to be
+	// clustered; shorter texts would match each other by chance.
+	minIssueWords = 3
+	// issueCandidates bounds the issues new feedback is compared with.
+	issueCandidates = 500
+	// issueTitleWords is the number of words an issue title is built from.
+	issueTitleWords = 8
+)
+
+// Issue groups feedback describing the same problem in different words, so it
+// can be triaged once. Feedback joins the most similar issue of its project
+// that was seen within the issue window, or opens a new one.
+type Issue struct {
+	gorm.Model
+	Project string `json:"project" gorm:"index"`
+	// Title is made of the first words of the feedback that opened the issue.
+	Title string `json:"title"`
+	// Href is the normalized page of the feedback that opened the issue.
+	Href string `json:"href"`
+	// Signature is the MinHash of the feedback that opened the issue.
+	Signature   []byte    `json:"-"`
+	Occurrences int       `json:"occurrences"`
+	FirstSeenAt time.Time `json:"firstSeenAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt" gorm:"index"`
+
+	TriageState
+}
+
+// issueFingerprint is what clustering knows about a feedback entry.
+type issueFingerprint struct {
+	Signature []byte
+	Href      string
+	Title     string
+}
+
+// issueSuffixes are stripped from words so inflections of the same word
+// match, e.g. "loading" and "loads". Only the first matching suffix is
+// removed and at least three letters stay.
+var issueSuffixes = []string{"ingly", "ungen", "ing", "ung", "ed", "es", "en", "er", "ly", "e", "s"}
+
+func issueStem(word string) string {
+	for _, suffix := range issueSuffixes {
+		stem, ok := strings.CutSuffix(word, suffix)
+		if ok && utf8.RuneCountInString(stem) >= 3 {
+			return stem
+		}
+	}
+	return word
+}
+
+// issueWord tells whether a word carries meaning for clustering.
+func issueWord(w string) bool {
+	if utf8.RuneCountInString(w) < 3 || searchStopwords[searchEnglish][w] || searchStopwords[searchGerman][w] {
+		return false
+	}
+	return strings.IndexFunc(w, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0
+}
+
+// normalizeIssueHref reduces a page url to its path with ids replaced, so
+// "/auction/5f1c…" and "/auction/9a0b…" count as the same page.
+func normalizeIssueHref(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	path := raw
+	if u, err := url.Parse(raw); err == nil {
+		path = u.Path
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, seg := range segments {
+		if isIssueID(seg) {
+			segments[i] = ":id"
+		}
+	}
+	return "/" + strings.ToLower(strings.Join(segments, "/"))
+}
+
+// isIssueID tells ids (numbers, uuids, hashes) from page names.
+func isIssueID(seg string) bool {
+	if seg == "" {
+		return false
+	}
+	digits := 0
+	for _, r := range seg {
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+	return digits == len(seg) || (digits > 0 && len(seg) >= 8)
+}
+
+// fingerprintFeedback computes the fingerprint of the feedback's additional
+// information and page. It returns nil for feedback with too little text to
+// cluster.
+func fingerprintFeedback(f *Feedback) *issueFingerprint {
+	seen := make(map[string]bool)
+	var features, title []string
+	for _, w := range searchWords(f.AdditionalInformations) {
+		if !issueWord(w) {
+			continue
+		}
+		stem := issueStem(w)
+		if seen[stem] {
+			continue
+		}
+		seen[stem] = true
+		features = append(features, stem)
+		if len(title) < issueTitleWords {
+			title = append(title, w)
+		}
+	}
+	if len(features) < minIssueWords {
+		return nil
+	}
+
+	fp := &issueFingerprint{Title: strings.Join(title, " ")}
+	if href, ok := payloadString(f.Payload, "href"); ok {
+		fp.Href = normalizeIssueHref(href)
+	}
+	// the page is one feature among the words: it tips the balance between
+	// similar texts but doesn't merge unrelated reports from one page
+	if fp.Href != "" {
+		features = append(features, "href:"+fp.Href)
+	}
+	fp.Signature = minHashSignature(features)
+	return fp
+}
+
+// payloadString returns a top level string of the payload.
+func payloadString(payload JSONB, key string) (string, bool) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return "", false
+	}
+	s, ok := m[key].(string)
+	return s, ok
+}
+
+// minHashSignature returns the MinHash of the feature set: for each of
+// minHashSize hash functions the smallest hash of any feature, 4 bytes each.
+// The share of equal positions in two signatures estimates the Jaccard
+// similarity of the sets.
+func minHashSignature(features []string) []byte {
+	mins := make([]uint32, minHashSize)
+	for i := range mins {
+		mins[i] = math.MaxUint32
+	}
+	for _, feature := range features {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		base := h.Sum64()
+		for i := range mins {
+			if v := uint32(splitmix64(base+uint64(i+1)*0x9e3779b97f4a7c15) >> 32); v < mins[i] {
+				mins[i] = v
+			}
+		}
+	}
+	sig := make([]byte, 4*minHashSize)
+	for i, v := range mins {
+		binary.BigEndian.PutUint32(sig[4*i:], v)
+	}
+	return sig
+}
+
+// splitmix64 derives the independent hash functions from one base hash.
+func splitmix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// minHashSimilarity estimates the Jaccard similarity of two signatures.
+func minHashSimilarity(a, b []byte) float64 {
+	if len(a) != 4*minHashSize || len(b) != len(a) {
+		return 0
+	}
+	equal := 0
+	for i := 0; i < len(a); i += 4 {
+		if binary.BigEndian.Uint32(a[i:]) == binary.BigEndian.Uint32(b[i:]) {
+			equal++
+		}
+	}
+	return float64(equal) / minHashSize
+}
+
+// assignIssue puts the feedback into the most similar recent issue of its
+// project or opens a new one, and sets f.IssueID. A closed issue that gets
+// new feedback is reopened. It returns the counter label of what happened,
+// or "" when the feedback can't be clustered.
+func (d *DatabaseHandler) assignIssue(tx *gorm.DB, f *Feedback, now time.Time) (string, error) {
+	fp := fingerprintFeedback(f)
+	if fp == nil {
+		return "", nil
+	}
+
+	var candidates []Issue
+	err := tx.Select("id", "signature", "status").
+		Where("project = ? AND last_seen_at >= ?", f.Project, now.Add(-d.issueWindow)).
+		Order("last_seen_at DESC").Limit(issueCandidates).Find(&candidates).Error
+	if err != nil {
+		return "", err
+	}
+	var best *Issue
+	bestScore := 0.0
+	for i := range candidates {
+		score := minHashSimilarity(fp.Signature, candidates[i].Signature)
+		if score >= d.issueSimilarity && score > bestScore {
+			best, bestScore = &candidates[i], score
+		}
+	}
+
+	if best == nil {
+		issue := &Issue{
+			Project:     f.Project,
+			Title:       fp.Title,
+			Href:        fp.Href,
+			Signature:   fp.Signature,
+			Occurrences: 1,
+			FirstSeenAt: now,
+			LastSeenAt:  now,
+			TriageState: TriageState{Status: StatusNew},
+		}
+		if err := tx.Create(issue).Error; err != nil {
+			return "", err
+		}
+		f.IssueID = &issue.ID
+		return "new", nil
+	}
+
+	result := "joined"
+	changes := map[string]interface{}{
+		"occurrences":  gorm.Expr("occurrences + 1"),
+		"last_seen_at": now,
+	}
+	if best.Status.closed() {
+		// it came back, so it needs another look
+		result = "reopened"
+		changes["status"] = StatusAcknowledged
+		changes["status_changed_at"] = now
+		changes["resolved_at"] = nil
+		// a triager who saw it closed must not overwrite the reopening
+		changes["updated_at"] = now
+	}
+	// UpdateColumns leaves updated_at alone, so triaging a busy issue doesn't
+	// conflict with every new occurrence
+	if err := tx.Model(&Issue{}).Where("id = ?", best.ID).UpdateColumns(changes).Error; err != nil {
+		return "", err
+	}
+	f.IssueID = &best.ID
+	return result, nil
+}
+
+// detachFromIssues takes feedback that is erased or lost its text out of its
+// issues. Issues left without feedback are deleted. An issue whose opener is
+// gone takes title, page and signature from its earliest remaining feedback,
+// so nothing of the removed text stays behind.
+func detachFromIssues(tx *gorm.DB, ids []uint) error {
+	var issues []struct {
+		IssueID uint
+		Removed int
+		First   uint
+	}
+	err := tx.Unscoped().Model(&Feedback{}).Select("issue_id, count(*) AS removed, min(id) AS first").
+		Where("id IN ? AND issue_id IS NOT NULL", ids).Group("issue_id").Scan(&issues).Error
+	if err != nil || len(issues) == 0 {
+		return err
+	}
+	if err := tx.Unscoped().Model(&Feedback{}).Where("id IN ?", ids).UpdateColumn("issue_id", nil).Error; err != nil {
+		return err
+	}
+
+	for _, issue := range issues {
+		var member Feedback
+		if err := tx.Unscoped().Where("issue_id = ?", issue.IssueID).Order("id").Limit(1).Find(&member).Error; err != nil {
+			return err
+		}
+		if member.ID == 0 {
+			if err := tx.Unscoped().Delete(&Issue{}, issue.IssueID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		// UpdateColumns leaves updated_at alone, triage guards on it
+		changes := map[string]interface{}{"occurrences": gorm.Expr("occurrences - ?", issue.Removed)}
+		if issue.First < member.ID {
+			changes["title"], changes["href"], changes["signature"] = "", "", nil
+			if fp := fingerprintFeedback(&member); fp != nil {
+				changes["title"], changes["href"], changes["signature"] = fp.Title, fp.Href, fp.Signature
+			}
+		}
+		if err := tx.Model(&Issue{}).Where("id = ?", issue.IssueID).UpdateColumns(changes).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IssueFilter narrows down an issue listing. Empty fields are ignored.
+type IssueFilter struct {
+	Project        string
+	Status         FeedbackStatus
+	Assignee       string
+	MinOccurrences int
+
+	// Cursor holds last_seen_at and id of the previous page's last issue.
+	Cursor *feedbackCursor
+	Limit  int
+}
+
+// IssuePage is one page of an issue listing. NextCursor is empty once the
+// last page was reached.
+type IssuePage struct {
+	Items      []Issue `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// ListIssues returns one page of issues matching the filter, most recently
+// seen first.
+func (d *DatabaseHandler) ListIssues(filter *IssueFilter) (*IssuePage, error) {
+	q := d.db.Model(&Issue{})
+	if filter.Project != "" {
+		q = q.Where("project = ?", filter.Project)
+	}
+	if filter.Status != "" {
+		q = q.Where("status = ?", filter.Status)
+	}
+	if filter.Assignee != "" {
+		q = q.Where("assignee = ?", filter.Assignee)
+	}
+	if filter.MinOccurrences > 0 {
+		q = q.Where("occurrences >= ?", filter.MinOccurrences)
+	}
+	if filter.Cursor != nil {
+		q = q.Where("(last_seen_at, id) < (?, ?)", filter.Cursor.CreatedAt, filter.Cursor.ID)
+	}
+
+	// fetch one extra row to know whether another page follows
+	var items []Issue
+	res := q.Order("last_seen_at desc, id desc").Limit(filter.Limit + 1).Find(&items)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	page := &IssuePage{Items: items}
+	if len(items) > filter.Limit {
+		page.Items = items[:filter.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = feedbackCursor{CreatedAt: last.LastSeenAt, ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+// GetIssue loads a single issue. It returns gorm.ErrRecordNotFound when no
+// issue with that id exists.
+func (d *DatabaseHandler) GetIssue(id uint) (*Issue, error) {
+	var issue Issue
+	if err := d.db.First(&issue, id).Error; err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
+
+// IssueUpdate is the body of PATCH /api/issues/{id}. The triage fields are
+// applied to the issue and to every feedback entry in it.
+type IssueUpdate struct {
+	TriageUpdate
+	Title *string `json:"title"`
+}
+
+// IssueTriageResult is the updated issue and what happened to its feedback.
+// Feedback whose state doesn't allow the new status, e.g. resolved feedback
+// asked to move to in_progress, is skipped.
+type IssueTriageResult struct {
+	Issue           *Issue `json:"issue"`
+	FeedbackUpdated int    `json:"feedbackUpdated"`
+	FeedbackSkipped int    `json:"feedbackSkipped"`
+}
+
+// UpdateIssueTriage applies an update to an issue and its feedback in one
+// transaction. Like UpdateFeedbackTriage it fails with ErrConcurrentUpdate
+// when the issue changed since the client or this function read it.
+func (d *DatabaseHandler) UpdateIssueTriage(id uint, update *IssueUpdate) (*IssueTriageResult, error) {
+	issue, err := d.GetIssue(id)
+	if err != nil {
+		return nil, err
+	}
+	if update.stale(issue.UpdatedAt) {
+		return nil, ErrConcurrentUpdate
+	}
+	previous := issue.UpdatedAt
+	now := time.Now()
+
+	changes, err := update.apply(&issue.TriageState, now)
+	if err != nil {
+		return nil, err
+	}
+	if update.Title != nil {
+		title := strings.TrimSpace(*update.Title)
+		if title == "" {
+			return nil, &FeedbackValidationError{Reason: "title is empty"}
+		}
+		issue.Title = title
+		changes["title"] = title
+	}
+
+	result := &IssueTriageResult{Issue: issue}
+	// the title is the issue's own, everything else goes to the feedback
+	// too, even if the issue itself is already in that state
+	propagate := update.Status != nil || update.Assignee != nil || update.ResolutionNote != nil
+	if len(changes) == 0 && !propagate {
+		return result, nil
+	}
+
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		if len(changes) > 0 {
+			changes["updated_at"] = now
+			issue.UpdatedAt = now
+			res := tx.Model(&Issue{}).Where("id = ? AND updated_at = ?", id, previous).Updates(changes)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return ErrConcurrentUpdate
+			}
+		}
+		if !propagate {
+			return nil
+		}
+
+		var members []Feedback
+		if err := tx.Where("issue_id = ?", id).Find(&members).Error; err != nil {
+			return err
+		}
+		for i := range members {
+			memberChanges, err := update.apply(&members[i].TriageState, now)
+			var invalid *FeedbackValidationError
+			if errors.As(err, &invalid) {
+				result.FeedbackSkipped++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if len(memberChanges) == 0 {
+				continue
+			}
+			if err := tx.Model(&Feedback{}).Where("id = ?", members[i].ID).Updates(memberChanges).Error; err != nil {
+				return err
+			}
+			result.FeedbackUpdated++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseIssueFilter(c *fiber.Ctx) (*IssueFilter, error) {
+	f := &IssueFilter{
+		Project:  c.Query("project"),
+		Status:   FeedbackStatus(c.Query("status")),
+		Assignee: c.Query("assignee"),
+		Limit:    feedbackPageDefault,
+	}
+	if f.Status != "" && !f.Status.valid() {
+		return nil, fmt.Errorf("unknown status %q", f.Status)
+	}
+	if v := c.Query("minOccurrences"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("minOccurrences must be a positive integer")
+		}
+		f.MinOccurrences = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("limit must be a positive integer")
+		}
+		f.Limit = min(n, feedbackPageMax)
+	}
+	if v := c.Query("cursor"); v != "" {
+		cur, err := decodeFeedbackCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		f.Cursor = cur
+	}
+	return f, nil
+}
+
+func (h *ApiHandler) listIssuesRequest(c *fiber.Ctx) error {
+	filter, err := parseIssueFilter(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	page, err := h.store.ListIssues(filter)
+	if err != nil {
+		slog.Error("could not list issues", "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not list issues")
+	}
+	return c.JSON(page)
+}
+
+func (h *ApiHandler) getIssueRequest(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+	issue, err := h.store.GetIssue(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fiber.NewError(http.StatusNotFound, "issue not found")
+		}
+		slog.Error("could not load issue", "id", id, "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not load issue")
+	}
+	return c.JSON(issue)
+}
+
+func (h *ApiHandler) patchIssueRequest(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+	var update IssueUpdate
+	if err := c.BodyParser(&update); err != nil {
+		return fiber.NewError(http.StatusBadRequest, "invalid body")
+	}
+
+	result, err := h.store.UpdateIssueTriage(uint(id), &update)
+	if err != nil {
+		var invalid *FeedbackValidationError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return fiber.NewError(http.StatusNotFound, "issue not found")
+		case errors.Is(err, ErrConcurrentUpdate):
+			return fiber.NewError(http.StatusConflict, "issue was modified concurrently")
+		case errors.As(err, &invalid):
+			return fiber.NewError(http.StatusUnprocessableEntity, invalid.Reason)
+		}
+		slog.Error("could not update issue", "id", id, "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not update issue")
+	}
+	slog.Info("issue triaged", "id", id, "feedbackUpdated", result.FeedbackUpdated, "feedbackSkipped", result.FeedbackSkipped, "by", actorOf(c))
+	return c.JSON(result)
+}
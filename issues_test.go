@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func fingerprintOf(t *testing.T, text, href string) *issueFingerprint {
+	t.Helper()
+	payload := `{"rating":1}`
+	if href != "" {
+		payload = `{"rating":1,"href":"` + href + `"}`
+	}
+	return fingerprintFeedback(&Feedback{AdditionalInformations: text, Payload: JSONB(payload)})
+}
+
+func TestIssueClustering(t *testing.T) {
+	a := fingerprintOf(t, "The bazaar flipper doesn't load anymore", "https://sky.coflnet.com/flipper?x=1")
+	b := fingerprintOf(t, "bazaar flipper is not loading anymore!!", "https://sky.coflnet.com/flipper")
+	c := fingerprintOf(t, "Prices of enchanted books on the auction house are wrong", "https://sky.coflnet.com/flipper")
+	if a == nil || b == nil || c == nil {
+		t.Fatal("expected fingerprints")
+	}
+	if sim := minHashSimilarity(a.Signature, b.Signature); sim < defaultIssueSimilarity {
+		t.Errorf("paraphrases should cluster, similarity %.2f", sim)
+	}
+	if sim := minHashSimilarity(a.Signature, c.Signature); sim >= defaultIssueSimilarity {
+		t.Errorf("unrelated reports from one page must not cluster, similarity %.2f", sim)
+	}
+	if sim := minHashSimilarity(a.Signature, a.Signature); sim != 1 {
+		t.Errorf("identical signatures must have similarity 1, got %.2f", sim)
+	}
+	if a.Title != "bazaar flipper load anymore" || a.Href != "/flipper" {
+		t.Errorf("unexpected fingerprint %q %q", a.Title, a.Href)
+	}
+
+	if fingerprintOf(t, "broken!!", "") != nil || fingerprintOf(t, "it is not ok 1234 5678", "") != nil {
+		t.Error("too little text must not be clustered")
+	}
+}
+
+func TestIssueStem(t *testing.T) {
+	for word, want := range map[string]string{
+		"loading":  "load",
+		"loads":    "load",
+		"loaded":   "load",
+		"flipper":  "flipp",
+		"preise":   "preis",
+		"rechnung": "rechn",
+		"thing":    "thing",
+		"bus":      "bus",
+	} {
+		if got := issueStem(word); got != want {
+			t.Errorf("%s: got %s, want %s", word, got, want)
+		}
+	}
+}
+
+func TestNormalizeIssueHref(t *testing.T) {
+	for raw, want := range map[string]string{
+		"https://sky.coflnet.com/auction/5f1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e?ref=x": "/auction/:id",
+		"https://sky.coflnet.com/player/Notch/":                                  "/player/notch",
+		"/item/ENCHANTED_BOOK/2024":                                              "/item/enchanted_book/:id",
+		"https://sky.coflnet.com":                                                "/",
+		"":                                                                       "",
+	} {
+		if got := normalizeIssueHref(raw); got != want {
+			t.Errorf("%q: got %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestJoiningIssueKeepsTriageVersion(t *testing.T) {
+	db := connectSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	defer db.Close()
+	save := func(user string) *Feedback {
+		t.Helper()
+		f := &Feedback{Project: "sky", User: user, AdditionalInformations: "The bazaar flipper doesn't load anymore", Payload: JSONB(`{}`), TriageState: TriageState{Status: StatusNew}}
+		if err := db.SaveFeedback(context.Background(), f, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	first := save("a")
+	opened, err := db.GetIssue(*first.IssueID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save("b")
+	joined, err := db.GetIssue(opened.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined.Occurrences != 2 || !joined.UpdatedAt.Equal(opened.UpdatedAt) {
+		t.Errorf("a new occurrence changed the triage version: %+v", joined)
+	}
+
+	resolved := StatusResolved
+	if _, err := db.UpdateIssueTriage(opened.ID, &IssueUpdate{TriageUpdate: TriageUpdate{Status: &resolved}}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := db.GetIssue(opened.ID)
+	save("c")
+	reopened, err := db.GetIssue(opened.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Status != StatusAcknowledged || reopened.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("reopening must change the triage version: %+v", reopened)
+	}
+}
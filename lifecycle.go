@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownTimeout is used when SHUTDOWN_TIMEOUT is not set. It stays
+// below the 30s grace period Kubernetes gives a pod before killing it.
+const defaultShutdownTimeout = 25 * time.Second
+
+// Lifecycle runs the listeners and background loops of the server and stops
+// them in order once SIGTERM or SIGINT arrives or a listener fails.
+type Lifecycle struct {
+	// ctx is cancelled when shutdown begins, loops return when it's done.
+	ctx  context.Context
+	stop context.CancelFunc
+
+	timeout time.Duration
+
+	loops sync.WaitGroup
+
+	mu      sync.Mutex
+	servers []lifecycleServer
+	closers []lifecycleCloser
+	// err is the first listener failure.
+	err error
+}
+
+type lifecycleServer struct {
+	name     string
+	shutdown func(context.Context) error
+}
+
+type lifecycleCloser struct {
+	name  string
+	close func(context.Context) error
+}
+
+// NewLifecycle uses timeout as the deadline for draining requests and
+// stopping loops.
+func NewLifecycle(parent context.Context, timeout time.Duration) *Lifecycle {
+	ctx, stop := signal.NotifyContext(parent, syscall.SIGTERM, os.Interrupt)
+	return &Lifecycle{
+		ctx:     ctx,
+		stop:    stop,
+		timeout: timeout,
+	}
+}
+
+// Context is cancelled once shutdown begins.
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Go runs loop in the background. It has to return soon after its context
+// is cancelled; shutdown waits for it before closing the closers.
+func (l *Lifecycle) Go(name string, loop func(context.Context)) {
+	l.loops.Add(1)
+	go func() {
+		defer l.loops.Done()
+		loop(l.ctx)
+		slog.Debug("stopped background loop", "loop", name)
+	}()
+}
+
+// Serve runs listen in the background and calls shutdown on it when the
+// lifecycle stops. listen returning before that, with or without an error,
+// is a failure that shuts everything else down.
+func (l *Lifecycle) Serve(name string, listen func() error, shutdown func(context.Context) error) {
+	l.mu.Lock()
+	l.servers = append(l.servers, lifecycleServer{name: name, shutdown: shutdown})
+	l.mu.Unlock()
+
+	go func() {
+		err := listen()
+		if l.ctx.Err() != nil {
+			// shutting down, http.ErrServerClosed or nil is expected
+			return
+		}
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			err = errors.New("stopped unexpectedly")
+		}
+		slog.Error("listener failed", "listener", name, "err", err)
+		l.fail(fmt.Errorf("%s: %w", name, err))
+	}()
+}
+
+// OnStop registers a function that runs after listeners and loops stopped.
+// Closers run in reverse order of registration.
+func (l *Lifecycle) OnStop(name string, close func(context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closers = append(l.closers, lifecycleCloser{name: name, close: close})
+}
+
+func (l *Lifecycle) fail(err error) {
+	l.mu.Lock()
+	if l.err == nil {
+		l.err = err
+	}
+	l.mu.Unlock()
+	l.stop()
+}
+
+// Wait blocks until shutdown begins and then stops everything: listeners
+// stop accepting and drain in-flight requests, then the loops return (the
+// outbox after finishing its in-flight deliveries), then the closers run.
+// Draining and stopping loops share one deadline, the closers get their own.
+// It returns the listener failure that caused the shutdown, if any, joined
+// with everything that didn't stop cleanly.
+func (l *Lifecycle) Wait() error {
+	<-l.ctx.Done()
+	// restore the default signal handling, a second SIGTERM kills at once
+	l.stop()
+	slog.Info("shutting down..", "timeout", l.timeout)
+
+	l.mu.Lock()
+	servers, closers := l.servers, l.closers
+	errs := []error{l.err}
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errsMu sync.Mutex
+	for _, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.shutdown(ctx); err != nil {
+				slog.Error("could not drain listener", "listener", s.name, "err", err)
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("draining %s: %w", s.name, err))
+				errsMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	stopped := make(chan struct{})
+	go func() {
+		l.loops.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Error("background loops did not stop in time")
+		errs = append(errs, errors.New("background loops did not stop in time"))
+	}
+
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), l.timeout)
+	defer cancelClose()
+	for i := len(closers) - 1; i >= 0; i-- {
+		c := closers[i]
+		if err := c.close(closeCtx); err != nil {
+			slog.Error("could not close", "closer", c.name, "err", err)
+			errs = append(errs, fmt.Errorf("closing %s: %w", c.name, err))
+		}
+	}
+
+	slog.Info("shut down")
+	return errors.Join(errs...)
+}
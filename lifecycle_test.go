@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLifecycleStopsInOrderOnSignal(t *testing.T) {
+	lc := NewLifecycle(context.Background(), time.Second)
+
+	var mu sync.Mutex
+	var order []string
+	record := func(step string) {
+		mu.Lock()
+		order = append(order, step)
+		mu.Unlock()
+	}
+
+	listening := make(chan struct{})
+	closed := make(chan struct{})
+	lc.Serve("api", func() error {
+		close(listening)
+		<-closed
+		return http.ErrServerClosed
+	}, func(context.Context) error {
+		record("drain api")
+		close(closed)
+		return nil
+	})
+	lc.Go("loop", func(ctx context.Context) {
+		<-ctx.Done()
+		// give a listener still draining the chance to record first
+		time.Sleep(10 * time.Millisecond)
+		record("stop loop")
+	})
+	lc.OnStop("first", func(context.Context) error {
+		record("close first")
+		return nil
+	})
+	lc.OnStop("second", func(context.Context) error {
+		record("close second")
+		return nil
+	})
+
+	<-listening
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if err := lc.Wait(); err != nil {
+		t.Fatalf("Wait() = %v, want nil after SIGTERM", err)
+	}
+
+	want := "drain api,stop loop,close second,close first"
+	if got := strings.Join(order, ","); got != want {
+		t.Fatalf("order = %s, want %s", got, want)
+	}
+}
+
+func TestLifecycleListenerFailureShutsDown(t *testing.T) {
+	lc := NewLifecycle(context.Background(), time.Second)
+
+	drained := false
+	lc.Serve("api", func() error {
+		<-lc.Context().Done()
+		return nil
+	}, func(context.Context) error {
+		drained = true
+		return nil
+	})
+	lc.Serve("metrics", func() error {
+		return errors.New("address already in use")
+	}, func(context.Context) error {
+		return nil
+	})
+
+	err := lc.Wait()
+	if err == nil || !strings.Contains(err.Error(), "metrics: address already in use") {
+		t.Fatalf("Wait() = %v, want the metrics failure", err)
+	}
+	if !drained {
+		t.Fatal("the api was not drained after the metrics listener failed")
+	}
+}
+
+func TestLifecycleListenerReturningEarlyFails(t *testing.T) {
+	lc := NewLifecycle(context.Background(), time.Second)
+	lc.Serve("api", func() error {
+		return nil
+	}, func(context.Context) error {
+		return nil
+	})
+
+	if err := lc.Wait(); err == nil || !strings.Contains(err.Error(), "stopped unexpectedly") {
+		t.Fatalf("Wait() = %v, want a failure", err)
+	}
+}
+
+func TestLifecycleLoopDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := NewLifecycle(ctx, 20*time.Millisecond)
+
+	release := make(chan struct{})
+	defer close(release)
+	lc.Go("stuck", func(context.Context) {
+		<-release
+	})
+	closed := false
+	lc.OnStop("database", func(context.Context) error {
+		closed = true
+		return nil
+	})
+
+	cancel()
+	err := lc.Wait()
+	if err == nil || !strings.Contains(err.Error(), "did not stop in time") {
+		t.Fatalf("Wait() = %v, want the deadline error", err)
+	}
+	if !closed {
+		t.Fatal("closers did not run after the deadline")
+	}
+}
+
+func TestLifecycleDrainsFiberRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := NewLifecycle(ctx, time.Second)
+
+	started := make(chan struct{})
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/slow", func(c *fiber.Ctx) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		return c.SendString("done")
+	})
+	lc.Serve("api", func() error {
+		return app.Listener(ln)
+	}, app.ShutdownWithContext)
+
+	type result struct {
+		status int
+		err    error
+	}
+	res := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			res <- result{err: err}
+			return
+		}
+		resp.Body.Close()
+		res <- result{status: resp.StatusCode}
+	}()
+
+	<-started
+	cancel()
+	if err := lc.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	r := <-res
+	if r.err != nil || r.status != http.StatusOK {
+		t.Fatalf("in-flight request = %d, %v; want it answered", r.status, r.err)
+	}
+}
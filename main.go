@@ -1,38 +1,418 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v3"
 
 	_ "github.com/joho/godotenv/autoload"
 )
 
 func main() {
-	errorCh := make(chan error)
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+	os.Exit(serve())
+}
 
-	// connect to the cockroach database
-	db := NewDatabaseHandler()
-	err := db.Connect()
+// serve runs the api, the metrics endpoint and the background jobs until
+// SIGTERM or a listener fails, then shuts them down and returns the exit code.
+func serve() int {
+	cfg, err := LoadConfig()
 	if err != nil {
-		slog.Error("could not connect to the database", "err", err)
-		panic(err)
+		slog.Error("invalid configuration, see feedback config check", "err", err)
+		return 1
+	}
+	cfg.apply()
+	lc := NewLifecycle(context.Background(), cfg.HTTP.ShutdownTimeout)
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		slog.Error("could not set up tracing", "err", err)
+		return 1
+	}
+	// closers run in reverse, so spans of the shutdown itself are flushed
+	lc.OnStop("tracing", shutdownTracing)
+
+	if err := start(lc, cfg); err != nil {
+		slog.Error("could not start", "err", err)
+		// stop whatever did start
+		lc.fail(err)
+	}
+
+	if err := lc.Wait(); err != nil {
+		slog.Error("stopped with errors", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// start connects to the database and starts everything lc has to stop.
+func start(lc *Lifecycle, cfg *Config) error {
+	// connect to the database
+	db := NewDatabaseHandler(cfg)
+	if err := db.Connect(); err != nil {
+		return fmt.Errorf("connecting to the database: %w", err)
 	}
+	lc.OnStop("database", func(context.Context) error {
+		return db.Close()
+	})
+
+	lc.Go("dedup cleanup", db.dedupCleanupLoop)
 
 	slog.Info("starting metrics..")
-	go startMetrics(errorCh)
+	startMetrics(lc, cfg.HTTP.MetricsAddr)
+
+	projects, err := LoadProjectRegistry(cfg)
+	if err != nil {
+		return fmt.Errorf("loading the project registry: %w", err)
+	}
+
+	slog.Info("starting notification outbox..")
+	lc.Go("outbox", NewOutboxWorker(db, projects, cfg).Run)
+
+	slog.Info("starting retention job..")
+	lc.Go("retention", NewRetentionJob(db, projects, cfg).Run)
 
 	// start the api
-	apiHandler := NewApiHandler(db)
+	apiHandler := NewApiHandler(db, projects, cfg)
 
 	slog.Info("starting api..")
-	err = apiHandler.startApi()
-	panic(fmt.Sprintf("received critical error stopping application, %v", err))
+	return apiHandler.startApi(lc)
+}
+
+// startMetrics serves the prometheus metrics on addr until lc stops.
+func startMetrics(lc *Lifecycle, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+	lc.Serve("metrics", srv.ListenAndServe, srv.Shutdown)
+}
+
+// runCommand runs a maintenance subcommand instead of the server and returns
+// the exit code.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "bootstrap-admin":
+		return bootstrapAdminCommand(args)
+	case "export":
+		return exportCommand(args)
+	case "gdpr":
+		return gdprCommand(args)
+	case "config":
+		return configCommand(args)
+	case "migrate":
+		return migrateCommand(args)
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\nusage: feedback [bootstrap-admin|export|gdpr|config|migrate]\n", name)
+	return 2
+}
+
+// connectDatabase loads the configuration and connects for a subcommand.
+func connectDatabase() (*DatabaseHandler, error) {
+	db, err := openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	return db, db.prepareSchema()
+}
+
+// openDatabase is connectDatabase without checking the schema.
+func openDatabase() (*DatabaseHandler, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	cfg.apply()
+	db := NewDatabaseHandler(cfg)
+	return db, db.open()
+}
+
+// migrateCommand applies, rolls back or lists the schema migrations.
+func migrateCommand(args []string) int {
+	usage := "usage: feedback migrate up|status|down [-steps n] [-yes]"
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	steps := fs.Int("steps", 1, "number of migrations to roll back")
+	yes := fs.Bool("yes", false, "confirm the rollback")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if action == "down" && *steps < 1 {
+		fmt.Fprintln(os.Stderr, "-steps must be positive")
+		return 2
+	}
+	if action == "down" && !*yes {
+		fmt.Fprintln(os.Stderr, "rolling back can drop data, pass -yes to confirm")
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		slog.Error("could not connect to the database", "err", err)
+		return 1
+	}
+	defer db.Close()
+	m, err := db.migrator()
+	if err != nil {
+		slog.Error("could not load the migrations", "err", err)
+		return 1
+	}
+
+	// waiting for the lock can be interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	switch action {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			slog.Error("migrating failed", "applied", n, "err", err)
+			return 1
+		}
+		slog.Info("database is up to date", "applied", n)
+	case "down":
+		n, err := m.Down(ctx, *steps)
+		if err != nil {
+			slog.Error("rolling back failed", "rolledBack", n, "err", err)
+			return 1
+		}
+		slog.Info("rolled back", "rolledBack", n)
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			slog.Error("could not read the migrations", "err", err)
+			return 1
+		}
+		return printMigrationStatus(os.Stdout, statuses)
+	}
+	return 0
+}
+
+// printMigrationStatus writes a table of the migrations and returns 1 unless
+// all of them are applied unchanged.
+func printMigrationStatus(w io.Writer, statuses []MigrationStatus) int {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	code := 0
+	for _, s := range statuses {
+		applied := "-"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		if s.State != migrationApplied {
+			code = 1
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, applied)
+	}
+	tw.Flush()
+	return code
 }
 
-func startMetrics(errorCh chan<- error) {
-	http.Handle("/metrics", promhttp.Handler())
-	errorCh <- http.ListenAndServe(":2112", nil)
+// configCommand validates the configuration. "config check" prints the
+// effective configuration with secrets redacted to stdout and every problem
+// to stderr.
+func configCommand(args []string) int {
+	if len(args) != 1 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: feedback config check")
+		return 2
+	}
+
+	cfg, err := LoadConfig()
+	if err == nil {
+		// the projects file is only parsed when loading the projects
+		var projects *ProjectRegistry
+		projects, err = LoadProjectRegistry(cfg)
+		if err == nil {
+			_, err = projects.ContactNotifiers(cfg.Contact.Project)
+		}
+	}
+
+	out, merr := yaml.Marshal(cfg.Redacted())
+	if merr != nil {
+		slog.Error("could not print the configuration", "err", merr)
+		return 1
+	}
+	os.Stdout.Write(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\ninvalid configuration:\n%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// bootstrapAdminCommand mints the first admin key and prints it to stdout.
+func bootstrapAdminCommand(args []string) int {
+	fs := flag.NewFlagSet("bootstrap-admin", flag.ContinueOnError)
+	name := fs.String("name", "bootstrap", "name to store with the key")
+	force := fs.Bool("force", false, "mint a key even if an admin key exists")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	db, err := connectDatabase()
+	if err != nil {
+		slog.Error("could not connect to the database", "err", err)
+		return 1
+	}
+	key, err := bootstrapAdminKey(db, *name, *force)
+	if err != nil {
+		slog.Error("could not mint admin key", "err", err)
+		return 1
+	}
+	fmt.Println(key)
+	return 0
+}
+
+// exportCommand writes feedback to a file or stdout, see exportFeedback.
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "csv, ndjson or parquet")
+	project := fs.String("project", "", "only feedback of this project")
+	status := fs.String("status", "", "only feedback with this status")
+	from := fs.String("from", "", "only feedback stored at or after this RFC 3339 time")
+	to := fs.String("to", "", "only feedback stored before this RFC 3339 time")
+	out := fs.String("o", "-", "output file, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	f, err := parseExportFormat(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	filter := FeedbackFilter{Project: *project, Status: FeedbackStatus(*status)}
+	if filter.Status != "" && !filter.Status.valid() {
+		fmt.Fprintf(os.Stderr, "unknown status %q\n", *status)
+		return 2
+	}
+	for _, t := range []struct {
+		value string
+		dst   *time.Time
+	}{{*from, &filter.CreatedFrom}, {*to, &filter.CreatedTo}} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid time %q, use RFC 3339\n", t.value)
+			return 2
+		}
+		*t.dst = parsed
+	}
+
+	pinExportRange(&filter, time.Now())
+
+	db, err := connectDatabase()
+	if err != nil {
+		slog.Error("could not connect to the database", "err", err)
+		return 1
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			slog.Error("could not create output file", "err", err)
+			return 1
+		}
+	}
+	buffered := bufio.NewWriter(w)
+	n, err := exportFeedback(buffered, f, func(fn func(*Feedback) error) error {
+		return db.EachFeedback(filter, fn)
+	})
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil && w != os.Stdout {
+		err = w.Close()
+	}
+	if err != nil {
+		slog.Error("export failed", "rows", n, "err", err)
+		return 1
+	}
+	slog.Info("exported feedback", "rows", n)
+	return 0
+}
+
+// gdprCommand answers data-subject requests from the command line:
+// "gdpr export" prints the JSON bundle, "gdpr erase" deletes or anonymizes.
+func gdprCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "erase") {
+		fmt.Fprintln(os.Stderr, "usage: feedback gdpr export|erase -user <id> -email <address> [-mode delete|anonymize] [-yes]")
+		return 2
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("gdpr "+action, flag.ContinueOnError)
+	user := fs.String("user", "", "user id as stored with the feedback")
+	email := fs.String("email", "", "email address")
+	mode := fs.String("mode", string(ErasureAnonymize), "erase mode, delete or anonymize")
+	yes := fs.Bool("yes", false, "confirm the erasure")
+	out := fs.String("o", "-", "export output file, - for stdout")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	subject := DataSubject{User: *user, Email: *email}
+	if err := subject.normalize(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if action == "erase" && !*yes {
+		fmt.Fprintln(os.Stderr, "erasing can't be undone, pass -yes to confirm")
+		return 2
+	}
+
+	db, err := connectDatabase()
+	if err != nil {
+		slog.Error("could not connect to the database", "err", err)
+		return 1
+	}
+	actor := "cli"
+	if u := os.Getenv("USER"); u != "" {
+		actor += ":" + u
+	}
+
+	var result interface{}
+	if action == "export" {
+		result, err = db.ExportDataSubject(subject, actor)
+	} else {
+		result, err = db.EraseDataSubject(subject, ErasureMode(*mode), actor)
+	}
+	if err != nil {
+		slog.Error("data-subject request failed", "action", action, "err", err)
+		return 1
+	}
+
+	w := os.Stdout
+	if *out != "-" && action == "export" {
+		if w, err = os.Create(*out); err != nil {
+			slog.Error("could not create output file", "err", err)
+			return 1
+		}
+		defer w.Close()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		slog.Error("could not write result", "err", err)
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MatrixNotifier sends an m.room.message through the Matrix client-server
+// API. The access token belongs to a bot account that has joined the room.
+type MatrixNotifier struct {
+	Homeserver  string
+	RoomID      string
+	AccessToken string
+}
+
+func (m *MatrixNotifier) Notify(ctx context.Context, n *Notification) error {
+	if m.AccessToken == "" {
+		return fmt.Errorf("no matrix access token configured")
+	}
+
+	// The transaction id makes retries idempotent: the homeserver drops a
+	// resend with an id it has already seen.
+	txn := n.ID
+	if txn == "" {
+		raw := make([]byte, 12)
+		rand.Read(raw)
+		txn = hex.EncodeToString(raw)
+	}
+
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(m.Homeserver, "/"), url.PathEscape(m.RoomID), url.PathEscape(txn))
+
+	return postJSON(ctx, http.MethodPut, endpoint,
+		map[string]string{"Authorization": "Bearer " + m.AccessToken},
+		map[string]interface{}{
+			"msgtype":        "m.text",
+			"body":           n.plainText(),
+			"format":         "org.matrix.custom.html",
+			"formatted_body": matrixHTML(n.Text),
+		})
+}
+
+var (
+	codeFenceRegex = regexp.MustCompile("(?s)```\n?(.*?)```")
+	boldRegex      = regexp.MustCompile(`\*\*(.+?)\*\*`)
+)
+
+// matrixHTML converts the notification markdown into the HTML subset Matrix
+// clients render.
+func matrixHTML(text string) string {
+	var out strings.Builder
+	last := 0
+	for _, m := range codeFenceRegex.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(matrixInlineHTML(text[last:m[0]]))
+		out.WriteString("<pre><code>")
+		out.WriteString(html.EscapeString(text[m[2]:m[3]]))
+		out.WriteString("</code></pre>")
+		last = m[1]
+	}
+	out.WriteString(matrixInlineHTML(text[last:]))
+	return out.String()
+}
+
+func matrixInlineHTML(text string) string {
+	text = html.EscapeString(text)
+	text = boldRegex.ReplaceAllString(text, "<strong>$1</strong>")
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
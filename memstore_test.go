@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// mapStore keeps feedback, notes, contact messages, keys and dashboard
+// sessions in maps. It implements the part of Store the submission, read,
+// triage, key and dashboard handlers use, to show they don't depend on
+// DatabaseHandler; the embedded Store is nil, so anything else panics.
+type mapStore struct {
+	Store
+
+	mu       sync.Mutex
+	lastID   uint
+	feedback map[uint]*Feedback
+	notes    []FeedbackNote
+	contacts []ContactMessage
+	keys     []APIKey
+	sessions map[string]DashboardSession
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{feedback: make(map[uint]*Feedback), sessions: make(map[string]DashboardSession)}
+}
+
+func (s *mapStore) nextID() uint {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *mapStore) SaveFeedback(ctx context.Context, f *Feedback, notifications []OutboxMessage, errs *ErrorCapture) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	f.ID, f.CreatedAt, f.UpdatedAt = s.nextID(), now, now
+	f.ContentHash = feedbackContentHash(f)
+	stored := *f
+	s.feedback[f.ID] = &stored
+	return nil
+}
+
+func (s *mapStore) ListFeedback(filter *FeedbackFilter) (*FeedbackPage, error) {
+	if len(filter.Payload) > 0 || filter.IssueID != 0 || filter.ErrorGroupID != 0 {
+		return nil, errors.New("the map store filters by columns only")
+	}
+	s.mu.Lock()
+	var items []Feedback
+	for _, f := range s.feedback {
+		if (filter.Project == "" || f.Project == filter.Project) &&
+			(filter.Status == "" || f.Status == filter.Status) &&
+			(filter.Assignee == "" || f.Assignee == filter.Assignee) &&
+			(filter.Spam == nil || f.Spam == *filter.Spam) &&
+			(filter.Context == "" || f.Context == filter.Context) &&
+			(filter.FeedbackName == "" || f.FeedbackName == filter.FeedbackName) &&
+			(filter.User == "" || f.User == filter.User) &&
+			(filter.Cursor == nil || f.CreatedAt.Before(filter.Cursor.CreatedAt) ||
+				f.CreatedAt.Equal(filter.Cursor.CreatedAt) && f.ID < filter.Cursor.ID) {
+			items = append(items, *f)
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(items, func(a, b Feedback) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return int(b.ID) - int(a.ID)
+	})
+	page := &FeedbackPage{Items: items}
+	if len(items) > filter.Limit {
+		page.Items = items[:filter.Limit]
+		last := page.Items[len(page.Items)-1]
+		page.NextCursor = feedbackCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+func (s *mapStore) GetFeedback(id uint) (*Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.feedback[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *f
+	return &copied, nil
+}
+
+func (s *mapStore) UpdateFeedbackTriage(id uint, update *TriageUpdate) (*Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.feedback[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if update.stale(f.UpdatedAt) {
+		return nil, ErrConcurrentUpdate
+	}
+	// apply writes into a copy, a rejected update must not change the entry
+	next := *f
+	changes, err := update.apply(&next.TriageState, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		next.UpdatedAt = time.Now()
+		*f = next
+	}
+	copied := *f
+	return &copied, nil
+}
+
+func (s *mapStore) AddFeedbackNote(feedbackID uint, author, text string) (*FeedbackNote, error) {
+	text, err := noteText(text)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.feedback[feedbackID]; !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	note := FeedbackNote{FeedbackID: feedbackID, Author: author, Text: text}
+	note.ID, note.CreatedAt = s.nextID(), time.Now()
+	s.notes = append(s.notes, note)
+	return &note, nil
+}
+
+func (s *mapStore) ListFeedbackNotes(feedbackID uint) ([]FeedbackNote, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var notes []FeedbackNote
+	for _, n := range s.notes {
+		if n.FeedbackID == feedbackID {
+			notes = append(notes, n)
+		}
+	}
+	return notes, nil
+}
+
+func (s *mapStore) SaveContactMessage(c *ContactSubmission, delivered bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := ContactMessage{Name: c.Name, Email: c.Email, Message: c.Message, Delivered: delivered}
+	m.ID, m.CreatedAt = s.nextID(), time.Now()
+	s.contacts = append(s.contacts, m)
+	return nil
+}
+
+func (s *mapStore) ListContactMessages(before uint, limit int) ([]ContactMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []ContactMessage
+	for i := len(s.contacts) - 1; i >= 0 && len(messages) < limit; i-- {
+		if before == 0 || s.contacts[i].ID < before {
+			messages = append(messages, s.contacts[i])
+		}
+	}
+	return messages, nil
+}
+
+func (s *mapStore) GetContactMessage(id uint) (*ContactMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.contacts {
+		if m.ID == id {
+			return &m, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (s *mapStore) CreateAPIKey(k *APIKey) (string, error) {
+	if (k.Project == "") == (k.Role == "") {
+		return "", fmt.Errorf("a key is either scoped to a project or has a role")
+	}
+	prefix := adminKeyPrefix
+	if k.Project != "" {
+		prefix = projectKeyPrefix
+	}
+	key, hash, err := generateAPIKey(prefix)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k.ID, k.CreatedAt = s.nextID(), time.Now()
+	k.Hash, k.Prefix = hash, keyDisplayPrefix(key)
+	s.keys = append(s.keys, *k)
+	return key, nil
+}
+
+// activeKey returns the unrevoked key matching the condition. The caller
+// holds the lock.
+func (s *mapStore) activeKey(match func(*APIKey) bool) (*APIKey, error) {
+	for i := range s.keys {
+		if k := s.keys[i]; k.RevokedAt == nil && match(&k) {
+			return &k, nil
+		}
+	}
+	return nil, ErrInvalidAPIKey
+}
+
+func (s *mapStore) AuthenticateAPIKey(key string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := hashAPIKey(key)
+	return s.activeKey(func(k *APIKey) bool { return k.Hash == hash })
+}
+
+func (s *mapStore) ListAPIKeys() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := slices.Clone(s.keys)
+	slices.Reverse(keys)
+	return keys, nil
+}
+
+func (s *mapStore) RevokeAPIKey(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.keys {
+		if s.keys[i].ID != id {
+			continue
+		}
+		if s.keys[i].RevokedAt == nil {
+			now := time.Now()
+			s.keys[i].RevokedAt = &now
+		}
+		for hash, session := range s.sessions {
+			if session.APIKeyID == id {
+				delete(s.sessions, hash)
+			}
+		}
+		return nil
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (s *mapStore) CountActiveAdminKeys() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, k := range s.keys {
+		if k.Role == RoleAdmin && k.RevokedAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *mapStore) CreateDashboardSession(keyID uint, ttl time.Duration) (string, error) {
+	id, hash, err := generateAPIKey(sessionPrefix)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sessions[hash] = DashboardSession{Hash: hash, APIKeyID: keyID, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	return id, nil
+}
+
+func (s *mapStore) AuthenticateDashboardSession(id string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[hashAPIKey(id)]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidAPIKey
+	}
+	return s.activeKey(func(k *APIKey) bool { return k.ID == session.APIKeyID })
+}
+
+func (s *mapStore) DeleteDashboardSession(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, hashAPIKey(id))
+	return nil
+}
+
+// newMapStoreApi serves the built-in projects from a mapStore.
+func newMapStoreApi(t *testing.T) *testApi {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Contact.ChallengeSecret = "test"
+	registry, err := LoadProjectRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMapStore()
+	app, _, err := NewApiHandler(store, registry, cfg).newApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := bootstrapAdminKey(store, "test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testApi{t: t, app: app, store: store, admin: admin}
+}
+
+func TestApiOnMapStore(t *testing.T) {
+	a := newMapStoreApi(t)
+	a.submit("alice", map[string]interface{}{"rating": 2, "additionalInformation": "the flipper is slow"})
+	a.submit("bob", map[string]interface{}{"rating": 5, "additionalInformation": "all good"})
+
+	var page FeedbackPage
+	a.get("/api/feedback?user=alice", &page)
+	if len(page.Items) != 1 || page.Items[0].AdditionalInformations != "the flipper is slow" {
+		t.Fatalf("listing = %+v", page.Items)
+	}
+	alice := page.Items[0]
+	a.get("/api/feedback?limit=1", &page)
+	if len(page.Items) != 1 || page.NextCursor == "" {
+		t.Errorf("first page = %+v", page)
+	}
+
+	path := fmt.Sprintf("/api/feedback/%d", alice.ID)
+	status, body := a.do("PATCH", path, a.admin, map[string]interface{}{"status": "acknowledged", "updatedAt": alice.UpdatedAt})
+	if status != http.StatusOK {
+		t.Fatalf("triage = %d: %s", status, body)
+	}
+	if status, _ := a.do("PATCH", path, a.admin, map[string]interface{}{"assignee": "carol", "updatedAt": alice.UpdatedAt}); status != http.StatusConflict {
+		t.Errorf("stale triage = %d", status)
+	}
+	if status, _ := a.do("PATCH", path, a.admin, map[string]interface{}{"status": "wont_fix"}); status != http.StatusUnprocessableEntity {
+		t.Errorf("wont_fix without a note = %d", status)
+	}
+	var got Feedback
+	a.get(path, &got)
+	if got.Status != StatusAcknowledged || got.Assignee != "" {
+		t.Errorf("after triage: %+v", got.TriageState)
+	}
+	if status, _ := a.do("GET", "/api/feedback/999", a.admin, nil); status != http.StatusNotFound {
+		t.Errorf("unknown feedback = %d", status)
+	}
+
+	// the dashboard signs in, shows the entry and takes notes
+	session := a.dashboardLogin(a.admin)
+	detail := fmt.Sprintf("/admin/feedback/%d", alice.ID)
+	form := url.Values{"csrf": {csrfToken(session)}, "text": {"asked alice for her browser"}}
+	req := httptest.NewRequest("POST", detail+"/notes", strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.AddCookie(&http.Cookie{Name: dashboardCookie, Value: session})
+	resp, err := a.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("adding a note = %d", resp.StatusCode)
+	}
+	if status, b := a.dashboardGet(detail, session); status != http.StatusOK || !strings.Contains(string(b), "the flipper is slow") || !strings.Contains(string(b), "asked alice for her browser") {
+		t.Errorf("dashboard detail = %d", status)
+	}
+	if status := a.dashboardLogout(session, csrfToken(session)); status != http.StatusSeeOther {
+		t.Errorf("sign out = %d", status)
+	}
+	if status, _ := a.dashboardGet("/admin/feedback", session); status != http.StatusSeeOther {
+		t.Errorf("after sign out = %d", status)
+	}
+}
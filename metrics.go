@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// outcomes of a feedback submission, the outcome label of feedback_total
+const (
+	outcomeStored     = "stored"
+	outcomeDuplicate  = "duplicate"
+	outcomeSpam       = "spam"
+	outcomeParseError = "parse_error"
+	// rejected by authentication, origin or validation policy
+	outcomeRejected = "rejected"
+	// the database failed to store it
+	outcomeStoreFailed = "store_failed"
+	// counted later, when the outbox gives up notifying a target about it
+	outcomeNotifyFailed = "notify_failed"
+)
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "feedback_http_request_duration_seconds",
+		Help:    "time spent serving api requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	notifyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "feedback_notify_duration_seconds",
+		Help:    "time a single notification delivery attempt took",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20},
+	}, []string{"type", "result"})
+
+	notifyFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedback_notify_failures_total",
+		Help: "failed notification delivery attempts",
+	}, []string{"type"})
+
+	contactSpamScore = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "contact_form_spam_score",
+		Help:    "spam score of contact form messages that reached the content check",
+		Buckets: []float64{0, 10, 25, 50, 75, 100, 150, 200},
+	})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "feedback_db_query_duration_seconds",
+		Help:    "time spent in database statements",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	}, []string{"operation", "table"})
+)
+
+// requestMetrics observes the duration of every request by its route
+// pattern, so /api/feedback/:id is one series and not one per id.
+func requestMetrics(c *fiber.Ctx) error {
+	start := time.Now()
+	self := c.Route()
+	err := c.Next()
+
+	route := routePattern(c, self)
+	status := strconv.Itoa(responseStatus(c, err))
+	requestDuration.WithLabelValues(c.Method(), route, status).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// responseStatus is the status the error handler will answer err with.
+func responseStatus(c *fiber.Ctx, err error) int {
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe.Code
+	} else if err != nil {
+		return fiber.StatusInternalServerError
+	}
+	return c.Response().StatusCode()
+}
+
+// routePattern returns the path pattern of the route that served the
+// request, or "unmatched" when no route but the calling middleware, whose
+// route is self, matched.
+func routePattern(c *fiber.Ctx, self *fiber.Route) string {
+	if c.Route() == self {
+		return "unmatched"
+	}
+	return c.Route().Path
+}
+
+// instrumentedNotifier records latency and failures of a notifier and
+// traces its deliveries.
+type instrumentedNotifier struct {
+	Notifier
+	kind string
+}
+
+func instrument(n Notifier, kind string) Notifier {
+	return &instrumentedNotifier{Notifier: n, kind: kind}
+}
+
+func (n *instrumentedNotifier) Notify(ctx context.Context, notification *Notification) error {
+	ctx, span := tracer.Start(ctx, "notify "+n.kind, trace.WithAttributes(attribute.String("notification.id", notification.ID)))
+	defer span.End()
+
+	start := time.Now()
+	err := n.Notifier.Notify(ctx, notification)
+	result := "ok"
+	if err != nil {
+		result = "error"
+		notifyFailures.WithLabelValues(n.kind).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	notifyDuration.WithLabelValues(n.kind, result).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// dbMetrics is a gorm plugin timing every statement by operation and table.
+type dbMetrics struct{}
+
+const dbMetricsStartKey = "metrics:start"
+
+func (dbMetrics) Name() string {
+	return "metrics"
+}
+
+func (dbMetrics) Initialize(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(dbMetricsStartKey, time.Now())
+	}
+	after := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			v, ok := tx.InstanceGet(dbMetricsStartKey)
+			if !ok {
+				return
+			}
+			table := tx.Statement.Table
+			if table == "" {
+				table = "raw"
+			}
+			dbQueryDuration.WithLabelValues(operation, table).Observe(time.Since(v.(time.Time)).Seconds())
+		}
+	}
+
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("metrics:before_create", before),
+		cb.Create().After("gorm:create").Register("metrics:after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("metrics:before_query", before),
+		cb.Query().After("gorm:query").Register("metrics:after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("metrics:before_update", before),
+		cb.Update().After("gorm:update").Register("metrics:after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("metrics:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("metrics:after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("metrics:before_row", before),
+		cb.Row().After("gorm:row").Register("metrics:after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("metrics:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("metrics:after_raw", after("raw")),
+	)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// observations returns how many samples a histogram series holds.
+func observations(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRequestMetricsUseRoutePattern(t *testing.T) {
+	app := fiber.New()
+	app.Use(requestMetrics)
+	app.Get("/api/things/:id", func(c *fiber.Ctx) error {
+		if c.Params("id") == "0" {
+			return fiber.NewError(400, "bad id")
+		}
+		return c.SendString("ok")
+	})
+
+	for _, path := range []string{"/api/things/1", "/api/things/2", "/api/things/0", "/nowhere"} {
+		if _, err := app.Test(httptest.NewRequest("GET", path, nil), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for labels, want := range map[[3]string]uint64{
+		{"GET", "/api/things/:id", "200"}: 2,
+		{"GET", "/api/things/:id", "400"}: 1,
+		{"GET", "unmatched", "404"}:       1,
+	} {
+		if got := observations(t, requestDuration.WithLabelValues(labels[:]...)); got != want {
+			t.Errorf("%v: got %d observations, want %d", labels, got, want)
+		}
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(ctx context.Context, n *Notification) error {
+	return errors.New("down")
+}
+
+func TestInstrumentedNotifier(t *testing.T) {
+	before := testutil.ToFloat64(notifyFailures.WithLabelValues("test"))
+	n := instrument(failingNotifier{}, "test")
+	if err := n.Notify(context.Background(), &Notification{}); err == nil {
+		t.Fatal("the error must be passed through")
+	}
+	if got := testutil.ToFloat64(notifyFailures.WithLabelValues("test")) - before; got != 1 {
+		t.Errorf("expected one failure, got %v", got)
+	}
+	if got := observations(t, notifyDuration.WithLabelValues("test", "error")); got != 1 {
+		t.Errorf("expected one latency observation, got %d", got)
+	}
+}
+
+func TestDBMetrics(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(dbMetrics{}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := observations(t, dbQueryDuration.WithLabelValues("query", "issues"))
+	db.Find(&[]Issue{})
+	db.Create(&Issue{Title: "x"})
+	if got := observations(t, dbQueryDuration.WithLabelValues("query", "issues")) - before; got != 1 {
+		t.Errorf("expected one query observation, got %d", got)
+	}
+	if got := observations(t, dbQueryDuration.WithLabelValues("create", "issues")); got == 0 {
+		t.Error("create not timed")
+	}
+}
+
+func TestErrorMetricsOnlyLabelRegisteredProjects(t *testing.T) {
+	a := newTestApi(t)
+	db := a.store.(*DatabaseHandler)
+	err := db.db.Callback().Query().After("gorm:query").Register("test:fail", func(tx *gorm.DB) {
+		if tx.Statement.Table == "feedbacks" {
+			tx.AddError(errors.New("database is down"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := testutil.CollectAndCount(errorsCounter)
+	before := testutil.ToFloat64(errorsCounter.WithLabelValues(""))
+	for _, path := range []string{"/api/feedback?project=no-such-project", "/api/feedback?project=another-one", "/api/feedback?project=sky"} {
+		if status, _ := a.do("GET", path, a.admin, nil); status != http.StatusInternalServerError {
+			t.Fatalf("%s with a failing store = %d", path, status)
+		}
+	}
+	if got := testutil.ToFloat64(errorsCounter.WithLabelValues("")) - before; got != 2 {
+		t.Errorf("unregistered projects counted %v times as \"\", want 2", got)
+	}
+	if n := testutil.CollectAndCount(errorsCounter); n > series+2 {
+		t.Errorf("query strings created %d new series", n-series)
+	}
+}
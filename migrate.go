@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// migrate modes, what a starting replica does about pending migrations
+const (
+	// migrateCheck refuses to start unless every migration is applied.
+	migrateCheck = "check"
+	// migrateUp applies pending migrations under the migration lock.
+	migrateUp = "up"
+)
+
+const (
+	// migrationLockLease is how long the lock is held without a renewal;
+	// a replica that died while migrating blocks the others this long.
+	migrationLockLease = time.Minute
+	// migrationLockWait is how long Up and Down wait for another replica.
+	migrationLockWait = 10 * time.Minute
+	migrationLockPoll = time.Second
+)
+
+// migrationFiles holds the schema migrations, one directory per dialect,
+// named <version>_<name>.up.sql and <version>_<name>.down.sql.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationBackfills are steps of a migration that can't be written in SQL,
+// such as filling new columns of existing rows. They run once the migration
+// is committed, in batches that commit on their own, and only touch rows that
+// still need it, so every Up resumes an interrupted backfill. They stop
+// between batches once ctx is done.
+var migrationBackfills = map[int64]func(ctx context.Context, db *gorm.DB) error{
+	3: func(ctx context.Context, db *gorm.DB) error {
+		if err := backfillPayload(ctx, db); err != nil {
+			return err
+		}
+		return backfillSearch(ctx, db)
+	},
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change. Checksum is the sha256 of Up, so
+// editing a migration after it was applied is noticed.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// SchemaMigration records an applied migration.
+type SchemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// schemaMigrationLock is the single row lease that keeps replicas from
+// migrating at the same time. CockroachDB has no advisory locks.
+type schemaMigrationLock struct {
+	ID          int `gorm:"primaryKey;autoIncrement:false"`
+	Owner       string
+	LockedUntil time.Time
+}
+
+func (schemaMigrationLock) TableName() string {
+	return "schema_migration_lock"
+}
+
+// migration states, see MigrationStatus
+const (
+	migrationApplied = "applied"
+	migrationPending = "pending"
+	// migrationChanged was applied with another checksum.
+	migrationChanged = "changed"
+	// migrationUnknown was applied but is not part of this binary, usually
+	// because a newer release migrated.
+	migrationUnknown = "unknown"
+)
+
+// MigrationStatus is a migration and whether it is applied.
+type MigrationStatus struct {
+	Migration
+	State     string
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the migrations of a dialect, ordered by version.
+func loadMigrations(fsys fs.FS, dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		parts := migrationFileName.FindStringSubmatch(e.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("unexpected migration file %s/%s", dir, e.Name())
+		}
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s/%s: %w", dir, e.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		} else if m.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s needs an up and a down file", m)
+		}
+		sum := sha256.Sum256([]byte(m.Up))
+		m.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and rolls back the migrations of one database.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	owner      string
+
+	lease    time.Duration
+	lockWait time.Duration
+	lockPoll time.Duration
+}
+
+// migrator returns the Migrator for the embedded migrations of the
+// database's dialect.
+func (d *DatabaseHandler) migrator() (*Migrator, error) {
+	dialect := driverPostgres
+	if isSQLite(d.db) {
+		dialect = driverSQLite
+	}
+	migrations, err := loadMigrations(migrationFiles, dialect)
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(d.db, migrations), nil
+}
+
+func newMigrator(db *gorm.DB, migrations []Migration) *Migrator {
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		owner:      migrationLockOwner(),
+		lease:      migrationLockLease,
+		lockWait:   migrationLockWait,
+		lockPoll:   migrationLockPoll,
+	}
+}
+
+// migrationLockOwner names this process in the lock, for whoever waits.
+func migrationLockOwner() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// prepareSchema migrates or checks the schema as configured. The memory
+// driver starts empty every time and always migrates.
+func (d *DatabaseHandler) prepareSchema() error {
+	m, err := d.migrator()
+	if err != nil {
+		return err
+	}
+	if d.driver == driverMemory || d.migrate == migrateUp {
+		_, err = m.Up(context.Background())
+		return err
+	}
+	return m.Check()
+}
+
+// Status lists every migration of the binary and every applied one, ordered
+// by version. It only reads, so it works on a database never migrated.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var applied []SchemaMigration
+	if m.db.Migrator().HasTable(&SchemaMigration{}) {
+		if err := m.db.Order("version").Find(&applied).Error; err != nil {
+			return nil, err
+		}
+	}
+	byVersion := map[int64]SchemaMigration{}
+	for _, a := range applied {
+		byVersion[a.Version] = a
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Migration: mig, State: migrationPending}
+		if a, ok := byVersion[mig.Version]; ok {
+			s.State = migrationApplied
+			if a.Checksum != mig.Checksum {
+				s.State = migrationChanged
+			}
+			s.AppliedAt = &a.AppliedAt
+			delete(byVersion, mig.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for _, a := range byVersion {
+		a := a
+		statuses = append(statuses, MigrationStatus{
+			Migration: Migration{Version: a.Version, Name: a.Name, Checksum: a.Checksum},
+			State:     migrationUnknown,
+			AppliedAt: &a.AppliedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Check returns an error unless every migration of the binary is applied
+// unchanged. Migrations of a newer release are only logged, so a rollback
+// of the binary keeps serving.
+func (m *Migrator) Check() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, s := range statuses {
+		switch s.State {
+		case migrationPending:
+			pending++
+		case migrationChanged:
+			return fmt.Errorf("migration %s was changed after it was applied", s.Migration)
+		case migrationUnknown:
+			slog.Warn("database has a migration this release doesn't know", "migration", s.Migration.String())
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("database schema is %d migrations behind, run feedback migrate up or set DATABASE_MIGRATE=up", pending)
+	}
+	return nil
+}
+
+// Up applies every pending migration in order and returns how many it
+// applied, then runs their backfills. It refuses to run if an applied
+// migration was changed.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(ctx context.Context) error {
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.State == migrationChanged {
+				return fmt.Errorf("migration %s was changed after it was applied", s.Migration)
+			}
+		}
+		for _, s := range statuses {
+			if s.State != migrationPending {
+				continue
+			}
+			if err := m.apply(ctx, s.Migration); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, m.backfill(ctx)
+}
+
+// Down rolls back the newest steps applied migrations and returns how many
+// it rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(ctx context.Context) error {
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0 && n < steps; i-- {
+			s := statuses[i]
+			switch s.State {
+			case migrationPending:
+				continue
+			case migrationUnknown:
+				return fmt.Errorf("migration %s is not part of this release, roll it back with the release that applied it", s.Migration)
+			case migrationChanged:
+				return fmt.Errorf("migration %s was changed after it was applied", s.Migration)
+			}
+			if err := m.revert(ctx, s.Migration); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, m.backfill(ctx)
+}
+
+// apply runs a migration and records it in one transaction. It doesn't
+// start once ctx is done, e.g. because the migration lock was lost.
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("applying migration %s: %w", mig, context.Cause(ctx))
+	}
+	start := time.Now()
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// without arguments the whole file is sent as one script
+		if err := tx.Exec(mig.Up).Error; err != nil {
+			return err
+		}
+		return tx.Create(&SchemaMigration{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			Checksum:  mig.Checksum,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("applying migration %s: %w", mig, err)
+	}
+	slog.Info("applied migration", "migration", mig.String(), "took", time.Since(start))
+	return nil
+}
+
+// backfill runs the backfills of the applied migrations. It holds neither a
+// transaction nor the migration lock, so replicas can start serving while a
+// big table is filled in; backfills running at once write the same values.
+func (m *Migrator) backfill(ctx context.Context) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		backfill := migrationBackfills[s.Version]
+		if backfill == nil || s.State != migrationApplied {
+			continue
+		}
+		if err := backfill(ctx, m.db.WithContext(ctx)); err != nil {
+			return fmt.Errorf("backfilling migration %s: %w", s.Migration, err)
+		}
+	}
+	return nil
+}
+
+// revert rolls a migration back and forgets it in one transaction. Like
+// apply it doesn't start once ctx is done.
+func (m *Migrator) revert(ctx context.Context, mig Migration) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("rolling back migration %s: %w", mig, context.Cause(ctx))
+	}
+	start := time.Now()
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(mig.Down).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&SchemaMigration{}, "version = ?", mig.Version).Error
+	})
+	if err != nil {
+		return fmt.Errorf("rolling back migration %s: %w", mig, err)
+	}
+	slog.Info("rolled back migration", "migration", mig.String(), "took", time.Since(start))
+	return nil
+}
+
+// locked runs fn while holding the migration lock, renewing its lease until
+// fn returns. If a renewal fails, another replica may hold the lock by now:
+// the context passed to fn is canceled so fn stops before its next step, and
+// locked returns the renewal error.
+func (m *Migrator) locked(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := m.createTables(); err != nil {
+		return err
+	}
+	if err := m.lock(ctx); err != nil {
+		return err
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(m.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := m.renewLock(); err != nil {
+					slog.Error("could not renew the migration lock", "err", err)
+					cancel(fmt.Errorf("renewing the migration lock: %w", err))
+					return
+				}
+			}
+		}
+	}()
+
+	err := fn(lockCtx)
+	close(stop)
+	<-renewed
+	if lockCtx.Err() != nil && ctx.Err() == nil {
+		err = context.Cause(lockCtx)
+	}
+	if uerr := m.unlock(); uerr != nil {
+		slog.Error("could not release the migration lock", "err", uerr)
+	}
+	return err
+}
+
+// createTables creates the bookkeeping tables the migrations can't.
+func (m *Migrator) createTables() error {
+	timestamp := "TIMESTAMPTZ"
+	if isSQLite(m.db) {
+		timestamp = "DATETIME"
+	}
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL, applied_at ` + timestamp + ` NOT NULL)`,
+		`CREATE TABLE IF NOT EXISTS schema_migration_lock (id BIGINT PRIMARY KEY, owner TEXT NOT NULL, locked_until ` + timestamp + ` NOT NULL)`,
+	}
+	for _, stmt := range stmts {
+		if err := m.db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("creating migration tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// lock waits until it holds the migration lock, at most lockWait.
+func (m *Migrator) lock(ctx context.Context) error {
+	deadline := time.Now().Add(m.lockWait)
+	waiting := false
+	for {
+		holder, err := m.tryLock()
+		if err != nil {
+			return fmt.Errorf("taking the migration lock: %w", err)
+		}
+		if holder == "" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("migration lock is still held by %s after %s", holder, m.lockWait)
+		}
+		if !waiting {
+			slog.Info("waiting for the migration lock", "owner", holder)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.lockPoll):
+		}
+	}
+}
+
+// tryLock takes the lock if it is free or its lease ran out, like
+// claimDedupKey. Otherwise it returns the owner holding it.
+func (m *Migrator) tryLock() (string, error) {
+	now := time.Now()
+	l := schemaMigrationLock{ID: 1, Owner: m.owner, LockedUntil: now.Add(m.lease)}
+	res := m.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"owner", "locked_until"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Lt{Column: clause.Column{Table: "schema_migration_lock", Name: "locked_until"}, Value: now},
+		}},
+	}).Create(&l)
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 1 {
+		return "", nil
+	}
+
+	var held schemaMigrationLock
+	err := m.db.Where("id = ?", 1).First(&held).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// released in between, try again on the next poll
+		return "unknown", nil
+	}
+	return held.Owner, err
+}
+
+func (m *Migrator) renewLock() error {
+	res := m.db.Model(&schemaMigrationLock{}).Where("id = ? AND owner = ?", 1, m.owner).
+		Update("locked_until", time.Now().Add(m.lease))
+	if res.Error == nil && res.RowsAffected == 0 {
+		return errors.New("the lease ran out and another replica took the lock")
+	}
+	return res.Error
+}
+
+func (m *Migrator) unlock() error {
+	return m.db.Where("id = ? AND owner = ?", 1, m.owner).Delete(&schemaMigrationLock{}).Error
+}
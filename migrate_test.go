@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// openSQLite opens a SQLite file without touching its schema.
+func openSQLite(t *testing.T, path string) *DatabaseHandler {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Database.Driver = driverSQLite
+	cfg.Database.URL = path
+	db := NewDatabaseHandler(cfg)
+	if err := db.open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func sqliteMigrator(t *testing.T, db *DatabaseHandler) *Migrator {
+	t.Helper()
+	m, err := db.migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/sqlite/0002_notes.up.sql":     {Data: []byte("CREATE TABLE notes (id integer)")},
+		"migrations/sqlite/0002_notes.down.sql":   {Data: []byte("DROP TABLE notes")},
+		"migrations/sqlite/0001_initial.up.sql":   {Data: []byte("CREATE TABLE a (id integer)")},
+		"migrations/sqlite/0001_initial.down.sql": {Data: []byte("DROP TABLE a")},
+	}
+	migrations, err := loadMigrations(fsys, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].String() != "0001_initial" || migrations[1].String() != "0002_notes" {
+		t.Fatalf("migrations = %v", migrations)
+	}
+	if migrations[0].Checksum == migrations[1].Checksum || len(migrations[0].Checksum) != 64 {
+		t.Errorf("checksums = %q, %q", migrations[0].Checksum, migrations[1].Checksum)
+	}
+
+	delete(fsys, "migrations/sqlite/0002_notes.down.sql")
+	if _, err := loadMigrations(fsys, "sqlite"); err == nil || !strings.Contains(err.Error(), "0002_notes needs an up and a down file") {
+		t.Errorf("expected a missing down file error, got %v", err)
+	}
+
+	fsys["migrations/sqlite/0003-Bad.up.sql"] = &fstest.MapFile{}
+	if _, err := loadMigrations(fsys, "sqlite"); err == nil || !strings.Contains(err.Error(), "unexpected migration file") {
+		t.Errorf("expected a bad name error, got %v", err)
+	}
+}
+
+func TestEmbeddedMigrationsMatchAcrossDialects(t *testing.T) {
+	pg, err := loadMigrations(migrationFiles, driverPostgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := loadMigrations(migrationFiles, driverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pg) == 0 || len(pg) != len(sqlite) {
+		t.Fatalf("%d postgres and %d sqlite migrations", len(pg), len(sqlite))
+	}
+	for i := range pg {
+		if pg[i].String() != sqlite[i].String() {
+			t.Errorf("migration %d is %s on postgres but %s on sqlite", i, pg[i], sqlite[i])
+		}
+	}
+}
+
+// TestMigrationsCoverModels catches a model field without a migration.
+func TestMigrationsCoverModels(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	if _, err := sqliteMigrator(t, db).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	models := []interface{}{&Feedback{}, &OutboxMessage{}, &FeedbackDedupKey{}, &APIKey{}, &ContactMessage{}, &FeedbackNote{}, &AuditEvent{}, &Issue{}, &ErrorGroup{}, &ErrorGroupCount{}, &ErrorOccurrence{}, &DashboardSession{}}
+	for _, model := range models {
+		s, err := schema.Parse(model, &sync.Map{}, db.db.NamingStrategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range s.Fields {
+			if f.DBName != "" && !db.db.Migrator().HasColumn(model, f.DBName) {
+				t.Errorf("%s.%s has no column", s.Table, f.DBName)
+			}
+		}
+	}
+}
+
+func TestMigrateUpDownStatus(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	m := sqliteMigrator(t, db)
+	ctx := context.Background()
+
+	if err := m.Check(); err == nil || !strings.Contains(err.Error(), "migrations behind") {
+		t.Fatalf("expected an empty database to fail the check, got %v", err)
+	}
+
+	n, err := m.Up(ctx)
+	if err != nil || n != len(m.migrations) {
+		t.Fatalf("up applied %d, %v", n, err)
+	}
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Errorf("second up applied %d, %v", n, err)
+	}
+	var out bytes.Buffer
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := printMigrationStatus(&out, statuses); code != 0 || !regexp.MustCompile(`0001\s+initial\s+applied`).MatchString(out.String()) {
+		t.Errorf("status exited %d:\n%s", code, out.String())
+	}
+	if _, err := db.CreateAPIKey(&APIKey{Name: "local", Role: RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.db.Create(&Feedback{Feedback: "{}", Payload: JSONB(`{}`)}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Down(ctx, len(m.migrations)); err != nil || n != len(m.migrations) {
+		t.Fatalf("down rolled back %d, %v", n, err)
+	}
+	if db.db.Migrator().HasTable(&APIKey{}) {
+		t.Error("rolling back the migrations kept their tables")
+	}
+	// the feedbacks table may be the one of the last release, its rows stay
+	var kept int64
+	if err := db.db.Table("feedbacks").Count(&kept).Error; err != nil || kept != 1 {
+		t.Errorf("feedback rows after rolling back everything = %d, %v", kept, err)
+	}
+	statuses, _ = m.Status()
+	if statuses[0].State != migrationPending || statuses[len(statuses)-1].State != migrationPending {
+		t.Errorf("status after down = %+v", statuses)
+	}
+	if n, err := m.Up(ctx); err != nil || n != len(m.migrations) {
+		t.Errorf("up after rolling back applied %d, %v", n, err)
+	}
+}
+
+// releasedFeedback is the Feedback of the last release before migrations.
+type releasedFeedback struct {
+	gorm.Model
+	Feedback               string
+	AdditionalInformations string
+	User                   string
+	Context                string
+	FeedbackName           string
+	Timestamp              time.Time
+}
+
+func (releasedFeedback) TableName() string {
+	return "feedbacks"
+}
+
+func TestMigrateAdoptsReleasedDatabase(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	// what the last release before migrations did on every start
+	if err := db.db.AutoMigrate(&releasedFeedback{}); err != nil {
+		t.Fatal(err)
+	}
+	old := []releasedFeedback{
+		{Feedback: `{"rating":2,"page":"checkout"}`, AdditionalInformations: "the page is broken", User: "u1"},
+		{Feedback: "not json", User: "u2"},
+	}
+	if err := db.db.Create(&old).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sqliteMigrator(t, db).Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var rows []Feedback
+	if err := db.db.Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("%d rows after adopting", len(rows))
+	}
+	if rows[0].Status != StatusNew || rows[0].Spam || rows[0].Project != "" {
+		t.Errorf("columns not filled in: %+v", rows[0])
+	}
+	if string(rows[0].Payload) != old[0].Feedback || string(rows[1].Payload) != "null" {
+		t.Errorf("payloads = %s, %s", rows[0].Payload, rows[1].Payload)
+	}
+	var vector string
+	if err := db.db.Raw("SELECT search_vector FROM feedbacks WHERE id = ?", rows[0].ID).Scan(&vector).Error; err != nil {
+		t.Fatal(err)
+	}
+	if rows[0].SearchLanguage == "" || !strings.Contains(vector, "checkout") {
+		t.Errorf("not indexed for search: %q %q", rows[0].SearchLanguage, vector)
+	}
+	if _, err := db.CreateAPIKey(&APIKey{Name: "local", Role: RoleAdmin}); err != nil {
+		t.Error(err)
+	}
+
+	// a backfill interrupted after the migration committed resumes on the next up
+	if err := db.db.Exec("UPDATE feedbacks SET payload = NULL, search_vector = NULL WHERE id = ?", rows[1].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sqliteMigrator(t, db).Up(context.Background()); err != nil || n != 0 {
+		t.Fatalf("second up applied %d, %v", n, err)
+	}
+	var resumed struct {
+		Payload      string
+		SearchVector *string
+	}
+	if err := db.db.Raw("SELECT payload, search_vector FROM feedbacks WHERE id = ?", rows[1].ID).Scan(&resumed).Error; err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Payload != "null" || resumed.SearchVector == nil {
+		t.Errorf("backfill not resumed: %+v", resumed)
+	}
+}
+
+func TestMigrateRefusesChangedMigration(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	m := sqliteMigrator(t, db)
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := append([]Migration(nil), m.migrations...)
+	edited[0].Up += "\n-- edited"
+	edited[0].Checksum = "edited"
+	changed := newMigrator(db.db, edited)
+	if err := changed.Check(); err == nil || !strings.Contains(err.Error(), "was changed after it was applied") {
+		t.Errorf("expected the check to refuse, got %v", err)
+	}
+	if _, err := changed.Up(context.Background()); err == nil {
+		t.Error("expected up to refuse")
+	}
+
+	// a release without a migration the database has keeps serving
+	if err := newMigrator(db.db, nil).Check(); err != nil {
+		t.Errorf("unknown migrations should only be logged, got %v", err)
+	}
+}
+
+func TestMigrationLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.db")
+	first := sqliteMigrator(t, openSQLite(t, path))
+	second := sqliteMigrator(t, openSQLite(t, path))
+	second.lockWait, second.lockPoll = 50*time.Millisecond, 10*time.Millisecond
+
+	if err := first.createTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Up(context.Background()); err == nil || !strings.Contains(err.Error(), "still held by "+first.owner) {
+		t.Fatalf("expected the second replica to time out, got %v", err)
+	}
+
+	if err := first.unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := second.Up(context.Background()); err != nil || n != len(second.migrations) {
+		t.Fatalf("up after unlocking applied %d, %v", n, err)
+	}
+
+	// a replica that died holding the lock blocks only until its lease ran out
+	first.lease = time.Millisecond
+	if err := first.lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if holder, err := second.tryLock(); err != nil || holder != "" {
+		t.Errorf("expired lock held by %q, %v", holder, err)
+	}
+}
+
+func TestMigrationLockLost(t *testing.T) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	m := sqliteMigrator(t, db)
+	m.lease = 30 * time.Millisecond
+
+	err := m.locked(context.Background(), func(ctx context.Context) error {
+		// the lease ran out and another replica took over
+		if err := db.db.Model(&schemaMigrationLock{}).Where("id = ?", 1).Update("owner", "other").Error; err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Error("losing the lock didn't cancel the migration")
+		}
+		return m.apply(ctx, m.migrations[0])
+	})
+	if err == nil || !strings.Contains(err.Error(), "another replica took the lock") {
+		t.Fatalf("expected the lost lease to be returned, got %v", err)
+	}
+	if statuses, _ := m.Status(); statuses[0].State != migrationPending {
+		t.Errorf("migrated without the lock: %+v", statuses[0])
+	}
+}
+
+func TestConnectChecksSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.db")
+	cfg := defaultConfig()
+	cfg.Database.Driver = driverSQLite
+	cfg.Database.URL = path
+
+	db := NewDatabaseHandler(cfg)
+	if err := db.Connect(); err == nil || !strings.Contains(err.Error(), "migrate up") {
+		t.Fatalf("expected connecting to an unmigrated database to fail, got %v", err)
+	}
+	db.Close()
+
+	cfg.Database.Migrate = migrateUp
+	db = NewDatabaseHandler(cfg)
+	if err := db.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	cfg.Database.Migrate = migrateCheck
+	db = NewDatabaseHandler(cfg)
+	if err := db.Connect(); err != nil {
+		t.Errorf("migrated database failed the check: %v", err)
+	}
+	db.Close()
+}
@@ -0,0 +1,2 @@
+-- nothing to undo: the feedbacks table may hold the rows of the last release
+-- without migrations, so rolling back never drops it
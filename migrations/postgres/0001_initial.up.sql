@@ -0,0 +1,17 @@
+-- The schema of the last release without migrations. Its AutoMigrate
+-- created the table, so it is adopted as it is.
+
+CREATE TABLE IF NOT EXISTS feedbacks (
+	id bigserial,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	feedback text,
+	additional_informations text,
+	"user" text,
+	context text,
+	feedback_name text,
+	timestamp timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_deleted_at ON feedbacks (deleted_at);
@@ -0,0 +1,40 @@
+DROP INDEX IF EXISTS idx_feedbacks_user;
+DROP INDEX IF EXISTS idx_feedbacks_context;
+DROP INDEX IF EXISTS idx_feedbacks_feedback_name;
+DROP INDEX IF EXISTS idx_feedbacks_timestamp;
+DROP INDEX IF EXISTS idx_feedbacks_project;
+DROP INDEX IF EXISTS idx_feedbacks_status;
+DROP INDEX IF EXISTS idx_feedbacks_assignee;
+DROP INDEX IF EXISTS idx_feedbacks_issue_id;
+DROP INDEX IF EXISTS idx_feedbacks_content_hash;
+DROP INDEX IF EXISTS idx_feedbacks_spam;
+DROP INDEX IF EXISTS idx_feedbacks_stripped_at;
+DROP INDEX IF EXISTS idx_feedbacks_search_language;
+DROP INDEX IF EXISTS idx_feedbacks_project_created_at;
+DROP INDEX IF EXISTS idx_feedbacks_payload;
+DROP INDEX IF EXISTS idx_feedbacks_payload_rating;
+DROP INDEX IF EXISTS idx_feedbacks_payload_href;
+DROP INDEX IF EXISTS idx_feedbacks_payload_somethingbroke;
+DROP INDEX IF EXISTS idx_feedbacks_payload_loadnewinformation;
+DROP INDEX IF EXISTS idx_feedbacks_payload_subscriptionstatus;
+DROP INDEX IF EXISTS idx_feedbacks_search_vector;
+
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS project;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS payload;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS status;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS assignee;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS resolution_note;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS acknowledged_at;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS resolved_at;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS issue_id;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS content_hash;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS duplicate_count;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS last_duplicate_at;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS spam;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS spam_score;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS spam_reasons;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS redactions;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS stripped_at;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS search_language;
+ALTER TABLE feedbacks DROP COLUMN IF EXISTS search_vector;
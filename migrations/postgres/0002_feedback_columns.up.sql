@@ -0,0 +1,50 @@
+-- The columns and indexes the releases before migrations added with
+-- AutoMigrate, IF NOT EXISTS so databases that ran them are adopted.
+-- Existing rows are filled in by 0003.
+
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS project text;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS payload JSONB;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS status text DEFAULT 'new';
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS assignee text;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS resolution_note text;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS status_changed_at timestamptz;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS acknowledged_at timestamptz;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS resolved_at timestamptz;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS issue_id bigint;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS content_hash text;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS duplicate_count bigint DEFAULT 0;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS last_duplicate_at timestamptz;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS spam boolean;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS spam_score bigint;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS spam_reasons text;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS redactions bigint;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS stripped_at timestamptz;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS search_language text;
+ALTER TABLE feedbacks ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE INDEX IF NOT EXISTS idx_feedbacks_user ON feedbacks ("user");
+CREATE INDEX IF NOT EXISTS idx_feedbacks_context ON feedbacks (context);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_feedback_name ON feedbacks (feedback_name);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_timestamp ON feedbacks (timestamp);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_project ON feedbacks (project);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_status ON feedbacks (status);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_assignee ON feedbacks (assignee);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_issue_id ON feedbacks (issue_id);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_content_hash ON feedbacks (content_hash);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_spam ON feedbacks (spam);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_stripped_at ON feedbacks (stripped_at);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_search_language ON feedbacks (search_language);
+
+-- payload filters, see payload.go
+CREATE INDEX IF NOT EXISTS idx_feedbacks_payload ON feedbacks USING GIN (payload);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_payload_rating ON feedbacks ((payload->>'rating'));
+CREATE INDEX IF NOT EXISTS idx_feedbacks_payload_href ON feedbacks ((payload->>'href'));
+CREATE INDEX IF NOT EXISTS idx_feedbacks_payload_somethingbroke ON feedbacks ((payload->>'somethingBroke'));
+CREATE INDEX IF NOT EXISTS idx_feedbacks_payload_loadnewinformation ON feedbacks ((payload->>'loadNewInformation'));
+CREATE INDEX IF NOT EXISTS idx_feedbacks_payload_subscriptionstatus ON feedbacks ((payload->>'subscriptionStatus'));
+
+-- full-text search, see search.go
+CREATE INDEX IF NOT EXISTS idx_feedbacks_search_vector ON feedbacks USING GIN (search_vector);
+
+-- per project statistics, see stats.go
+CREATE INDEX IF NOT EXISTS idx_feedbacks_project_created_at ON feedbacks (project, created_at);
@@ -0,0 +1 @@
+-- nothing to undo, 0002 drops the filled in columns
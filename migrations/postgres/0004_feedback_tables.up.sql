@@ -0,0 +1,161 @@
+-- The tables the releases before migrations added with AutoMigrate.
+
+CREATE TABLE IF NOT EXISTS outbox_messages (
+	id bigserial,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	feedback_id bigint,
+	project text,
+	target bigint,
+	kind text DEFAULT 'feedback',
+	error_group_id bigint,
+	trace_context text,
+	status text,
+	attempts bigint,
+	next_attempt_at timestamptz,
+	locked_until timestamptz,
+	last_error text,
+	delivered_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_deleted_at ON outbox_messages (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_feedback_id ON outbox_messages (feedback_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_status ON outbox_messages (status);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_next_attempt_at ON outbox_messages (next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS feedback_dedup_keys (
+	hash text,
+	feedback_id bigint,
+	expires_at timestamptz,
+	PRIMARY KEY (hash)
+);
+CREATE INDEX IF NOT EXISTS idx_feedback_dedup_keys_feedback_id ON feedback_dedup_keys (feedback_id);
+CREATE INDEX IF NOT EXISTS idx_feedback_dedup_keys_expires_at ON feedback_dedup_keys (expires_at);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id bigserial,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	name text,
+	hash text,
+	prefix text,
+	project text,
+	role text,
+	created_by text,
+	last_used_at timestamptz,
+	revoked_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_deleted_at ON api_keys (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_hash ON api_keys (hash);
+CREATE INDEX IF NOT EXISTS idx_api_keys_project ON api_keys (project);
+
+CREATE TABLE IF NOT EXISTS contact_messages (
+	id bigserial,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	name text,
+	email text,
+	message text,
+	delivered boolean,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_contact_messages_deleted_at ON contact_messages (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_contact_messages_email ON contact_messages (email);
+
+CREATE TABLE IF NOT EXISTS feedback_notes (
+	id bigserial,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	feedback_id bigint,
+	author text,
+	text text,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_feedback_notes_deleted_at ON feedback_notes (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_feedback_notes_feedback_id ON feedback_notes (feedback_id);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+	id bigserial,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	action text,
+	actor text,
+	subject_hash text,
+	details JSONB,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_deleted_at ON audit_events (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_subject_hash ON audit_events (subject_hash);
+
+CREATE TABLE IF NOT EXISTS issues (
+	id bigserial,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	project text,
+	title text,
+	href text,
+	signature bytea,
+	occurrences bigint,
+	first_seen_at timestamptz,
+	last_seen_at timestamptz,
+	status text DEFAULT 'new',
+	assignee text,
+	resolution_note text,
+	status_changed_at timestamptz,
+	acknowledged_at timestamptz,
+	resolved_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_issues_deleted_at ON issues (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_issues_project ON issues (project);
+CREATE INDEX IF NOT EXISTS idx_issues_last_seen_at ON issues (last_seen_at);
+CREATE INDEX IF NOT EXISTS idx_issues_status ON issues (status);
+CREATE INDEX IF NOT EXISTS idx_issues_assignee ON issues (assignee);
+
+CREATE TABLE IF NOT EXISTS error_groups (
+	id bigserial,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	project text,
+	fingerprint text,
+	type text,
+	message text,
+	culprit text,
+	stack text,
+	occurrences bigint,
+	first_seen_at timestamptz,
+	last_seen_at timestamptz,
+	first_feedback_id bigint,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_error_groups_deleted_at ON error_groups (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_error_groups_fingerprint ON error_groups (project, fingerprint);
+CREATE INDEX IF NOT EXISTS idx_error_groups_last_seen_at ON error_groups (last_seen_at);
+
+CREATE TABLE IF NOT EXISTS error_group_counts (
+	error_group_id bigint,
+	dimension text,
+	value text,
+	occurrences bigint,
+	last_seen_at timestamptz,
+	PRIMARY KEY (error_group_id, dimension, value)
+);
+
+CREATE TABLE IF NOT EXISTS error_occurrences (
+	id bigserial,
+	error_group_id bigint,
+	feedback_id bigint,
+	created_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_error_occurrences_error_group_id ON error_occurrences (error_group_id);
+CREATE INDEX IF NOT EXISTS idx_error_occurrences_feedback_id ON error_occurrences (feedback_id);
@@ -0,0 +1,2 @@
+-- how many parts of a split notification were delivered, see discord.go
+ALTER TABLE outbox_messages ADD COLUMN IF NOT EXISTS parts bigint DEFAULT 0;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS dashboard_sessions;
@@ -0,0 +1,10 @@
+-- dashboard sign ins, see DashboardSession
+CREATE TABLE IF NOT EXISTS dashboard_sessions (
+	hash text,
+	api_key_id bigint,
+	created_at timestamptz,
+	expires_at timestamptz,
+	PRIMARY KEY (hash)
+);
+CREATE INDEX IF NOT EXISTS idx_dashboard_sessions_api_key_id ON dashboard_sessions (api_key_id);
+CREATE INDEX IF NOT EXISTS idx_dashboard_sessions_expires_at ON dashboard_sessions (expires_at);
@@ -0,0 +1,15 @@
+-- The schema of the last release without migrations.
+
+CREATE TABLE IF NOT EXISTS feedbacks (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	feedback text,
+	additional_informations text,
+	"user" text,
+	context text,
+	feedback_name text,
+	timestamp datetime
+);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_deleted_at ON feedbacks (deleted_at);
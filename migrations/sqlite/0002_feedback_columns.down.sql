@@ -0,0 +1,33 @@
+DROP INDEX IF EXISTS idx_feedbacks_user;
+DROP INDEX IF EXISTS idx_feedbacks_context;
+DROP INDEX IF EXISTS idx_feedbacks_feedback_name;
+DROP INDEX IF EXISTS idx_feedbacks_timestamp;
+DROP INDEX IF EXISTS idx_feedbacks_project;
+DROP INDEX IF EXISTS idx_feedbacks_status;
+DROP INDEX IF EXISTS idx_feedbacks_assignee;
+DROP INDEX IF EXISTS idx_feedbacks_issue_id;
+DROP INDEX IF EXISTS idx_feedbacks_content_hash;
+DROP INDEX IF EXISTS idx_feedbacks_spam;
+DROP INDEX IF EXISTS idx_feedbacks_stripped_at;
+DROP INDEX IF EXISTS idx_feedbacks_search_language;
+DROP INDEX IF EXISTS idx_feedbacks_project_created_at;
+
+ALTER TABLE feedbacks DROP COLUMN project;
+ALTER TABLE feedbacks DROP COLUMN payload;
+ALTER TABLE feedbacks DROP COLUMN status;
+ALTER TABLE feedbacks DROP COLUMN assignee;
+ALTER TABLE feedbacks DROP COLUMN resolution_note;
+ALTER TABLE feedbacks DROP COLUMN status_changed_at;
+ALTER TABLE feedbacks DROP COLUMN acknowledged_at;
+ALTER TABLE feedbacks DROP COLUMN resolved_at;
+ALTER TABLE feedbacks DROP COLUMN issue_id;
+ALTER TABLE feedbacks DROP COLUMN content_hash;
+ALTER TABLE feedbacks DROP COLUMN duplicate_count;
+ALTER TABLE feedbacks DROP COLUMN last_duplicate_at;
+ALTER TABLE feedbacks DROP COLUMN spam;
+ALTER TABLE feedbacks DROP COLUMN spam_score;
+ALTER TABLE feedbacks DROP COLUMN spam_reasons;
+ALTER TABLE feedbacks DROP COLUMN redactions;
+ALTER TABLE feedbacks DROP COLUMN stripped_at;
+ALTER TABLE feedbacks DROP COLUMN search_language;
+ALTER TABLE feedbacks DROP COLUMN search_vector;
@@ -0,0 +1,39 @@
+-- The columns and indexes added since the last release without migrations.
+-- SQLite has no inverted or payload expression indexes, its queries scan.
+-- Existing rows are filled in by 0003.
+
+ALTER TABLE feedbacks ADD COLUMN project text;
+ALTER TABLE feedbacks ADD COLUMN payload TEXT;
+ALTER TABLE feedbacks ADD COLUMN status text DEFAULT 'new';
+ALTER TABLE feedbacks ADD COLUMN assignee text;
+ALTER TABLE feedbacks ADD COLUMN resolution_note text;
+ALTER TABLE feedbacks ADD COLUMN status_changed_at datetime;
+ALTER TABLE feedbacks ADD COLUMN acknowledged_at datetime;
+ALTER TABLE feedbacks ADD COLUMN resolved_at datetime;
+ALTER TABLE feedbacks ADD COLUMN issue_id integer;
+ALTER TABLE feedbacks ADD COLUMN content_hash text;
+ALTER TABLE feedbacks ADD COLUMN duplicate_count integer DEFAULT 0;
+ALTER TABLE feedbacks ADD COLUMN last_duplicate_at datetime;
+ALTER TABLE feedbacks ADD COLUMN spam numeric;
+ALTER TABLE feedbacks ADD COLUMN spam_score integer;
+ALTER TABLE feedbacks ADD COLUMN spam_reasons text;
+ALTER TABLE feedbacks ADD COLUMN redactions integer;
+ALTER TABLE feedbacks ADD COLUMN stripped_at datetime;
+ALTER TABLE feedbacks ADD COLUMN search_language text;
+ALTER TABLE feedbacks ADD COLUMN search_vector TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_feedbacks_user ON feedbacks ("user");
+CREATE INDEX IF NOT EXISTS idx_feedbacks_context ON feedbacks (context);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_feedback_name ON feedbacks (feedback_name);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_timestamp ON feedbacks (timestamp);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_project ON feedbacks (project);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_status ON feedbacks (status);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_assignee ON feedbacks (assignee);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_issue_id ON feedbacks (issue_id);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_content_hash ON feedbacks (content_hash);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_spam ON feedbacks (spam);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_stripped_at ON feedbacks (stripped_at);
+CREATE INDEX IF NOT EXISTS idx_feedbacks_search_language ON feedbacks (search_language);
+
+-- per project statistics, see stats.go
+CREATE INDEX IF NOT EXISTS idx_feedbacks_project_created_at ON feedbacks (project, created_at);
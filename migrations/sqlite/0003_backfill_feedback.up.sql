@@ -0,0 +1,7 @@
+-- Fill in the columns of 0002 for rows stored before they existed. The
+-- payload and the search index are computed in Go after this script, see
+-- migrationBackfills.
+UPDATE feedbacks SET project = '' WHERE project IS NULL;
+UPDATE feedbacks SET status = 'new' WHERE status IS NULL;
+UPDATE feedbacks SET duplicate_count = 0 WHERE duplicate_count IS NULL;
+UPDATE feedbacks SET spam = false WHERE spam IS NULL;
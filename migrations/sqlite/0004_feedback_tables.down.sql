@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS error_occurrences;
+DROP TABLE IF EXISTS error_group_counts;
+DROP TABLE IF EXISTS error_groups;
+DROP TABLE IF EXISTS issues;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS feedback_notes;
+DROP TABLE IF EXISTS contact_messages;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS feedback_dedup_keys;
+DROP TABLE IF EXISTS outbox_messages;
@@ -0,0 +1,153 @@
+-- The tables the releases before migrations added.
+
+CREATE TABLE IF NOT EXISTS outbox_messages (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	feedback_id integer,
+	project text,
+	target integer,
+	kind text DEFAULT 'feedback',
+	error_group_id integer,
+	trace_context text,
+	status text,
+	attempts integer,
+	next_attempt_at datetime,
+	locked_until datetime,
+	last_error text,
+	delivered_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_deleted_at ON outbox_messages (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_feedback_id ON outbox_messages (feedback_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_status ON outbox_messages (status);
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_next_attempt_at ON outbox_messages (next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS feedback_dedup_keys (
+	hash text,
+	feedback_id integer,
+	expires_at datetime,
+	PRIMARY KEY (hash)
+);
+CREATE INDEX IF NOT EXISTS idx_feedback_dedup_keys_feedback_id ON feedback_dedup_keys (feedback_id);
+CREATE INDEX IF NOT EXISTS idx_feedback_dedup_keys_expires_at ON feedback_dedup_keys (expires_at);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	name text,
+	hash text,
+	prefix text,
+	project text,
+	role text,
+	created_by text,
+	last_used_at datetime,
+	revoked_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_deleted_at ON api_keys (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_hash ON api_keys (hash);
+CREATE INDEX IF NOT EXISTS idx_api_keys_project ON api_keys (project);
+
+CREATE TABLE IF NOT EXISTS contact_messages (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	name text,
+	email text,
+	message text,
+	delivered numeric
+);
+CREATE INDEX IF NOT EXISTS idx_contact_messages_deleted_at ON contact_messages (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_contact_messages_email ON contact_messages (email);
+
+CREATE TABLE IF NOT EXISTS feedback_notes (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	feedback_id integer,
+	author text,
+	text text
+);
+CREATE INDEX IF NOT EXISTS idx_feedback_notes_deleted_at ON feedback_notes (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_feedback_notes_feedback_id ON feedback_notes (feedback_id);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	action text,
+	actor text,
+	subject_hash text,
+	details TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_deleted_at ON audit_events (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action);
+CREATE INDEX IF NOT EXISTS idx_audit_events_subject_hash ON audit_events (subject_hash);
+
+CREATE TABLE IF NOT EXISTS issues (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	project text,
+	title text,
+	href text,
+	signature blob,
+	occurrences integer,
+	first_seen_at datetime,
+	last_seen_at datetime,
+	status text DEFAULT 'new',
+	assignee text,
+	resolution_note text,
+	status_changed_at datetime,
+	acknowledged_at datetime,
+	resolved_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_issues_deleted_at ON issues (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_issues_project ON issues (project);
+CREATE INDEX IF NOT EXISTS idx_issues_last_seen_at ON issues (last_seen_at);
+CREATE INDEX IF NOT EXISTS idx_issues_status ON issues (status);
+CREATE INDEX IF NOT EXISTS idx_issues_assignee ON issues (assignee);
+
+CREATE TABLE IF NOT EXISTS error_groups (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	project text,
+	fingerprint text,
+	type text,
+	message text,
+	culprit text,
+	stack text,
+	occurrences integer,
+	first_seen_at datetime,
+	last_seen_at datetime,
+	first_feedback_id integer
+);
+CREATE INDEX IF NOT EXISTS idx_error_groups_deleted_at ON error_groups (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_error_groups_fingerprint ON error_groups (project, fingerprint);
+CREATE INDEX IF NOT EXISTS idx_error_groups_last_seen_at ON error_groups (last_seen_at);
+
+CREATE TABLE IF NOT EXISTS error_group_counts (
+	error_group_id integer,
+	dimension text,
+	value text,
+	occurrences integer,
+	last_seen_at datetime,
+	PRIMARY KEY (error_group_id, dimension, value)
+);
+
+CREATE TABLE IF NOT EXISTS error_occurrences (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	error_group_id integer,
+	feedback_id integer,
+	created_at datetime
+);
+CREATE INDEX IF NOT EXISTS idx_error_occurrences_error_group_id ON error_occurrences (error_group_id);
+CREATE INDEX IF NOT EXISTS idx_error_occurrences_feedback_id ON error_occurrences (feedback_id);
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Notifier delivers a notification to one destination such as a Discord
+// channel or a mailbox.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Notification is the sink independent form of a message. Text is formatted
+// with the small markdown subset Discord understands (**bold** and ``` code
+// fences); sinks that can't render it convert it.
+type Notification struct {
+	// ID identifies the notification, sinks that support idempotent sends
+	// use it to avoid duplicates on retries.
+	ID      string
+	Subject string
+	Text    string
+	Project string
+
+	// the record the notification is about, one of them is set
+	Feedback *Feedback
+	Contact  *ContactSubmission
+	Error    *ErrorGroup
+
+	// Parts is how many messages of a notification split across several
+	// sends were delivered. Sinks that split skip those and advance it as
+	// they go, so a retry resumes where the last attempt failed.
+	Parts int
+}
+
+// ContactSubmission is a message sent through the landing page contact form.
+type ContactSubmission struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+
+// notifyTimeout bounds a single delivery attempt.
+const notifyTimeout = 10 * time.Second
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// feedbackNotification renders a stored feedback. It returns nil when the
+// feedback is too trivial to bother anyone with.
+func feedbackNotification(feedback *Feedback) (*Notification, error) {
+	// If additional information is provided but it's too short, don't send the message.
+	// This prevents sending trivial additional info (shorter than 5 characters).
+	trimmed := strings.TrimSpace(feedback.AdditionalInformations)
+	if trimmed != "" && utf8.RuneCountInString(trimmed) < 5 {
+		slog.Warn("additionalInformation is too short; not sending notification")
+		return nil, nil
+	}
+
+	// try to parse the raw feedback JSON into a map
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(feedback.Feedback), &parsed); err != nil {
+		slog.Error("could not parse feedback JSON", "err", err)
+		return nil, err
+	}
+
+	// helper to read boolean safely
+	getBool := func(k string) bool {
+		if v, ok := parsed[k]; ok {
+			if b, ok := v.(bool); ok {
+				return b
+			}
+		}
+		return false
+	}
+
+	// extract fields
+	loadNew := getBool("loadNewInformation")
+
+	var additional string
+	if v, ok := parsed["additionalInformation"]; ok && v != nil {
+		if s, ok := v.(string); ok {
+			additional = s
+		} else {
+			// fallback: marshal non-string additionalInformation to string
+			if b, err := json.Marshal(v); err == nil {
+				additional = string(b)
+			}
+		}
+	}
+
+	// ignore feedback when loadNewInformation is false and additionalInformation is empty or too short
+	if !loadNew && len(additional) < 10 {
+		slog.Warn("ignoring feedback: loadNewInformation is false and additionalInformation is too short")
+		return nil, nil
+	}
+
+	// format properties nicely into a message
+	var buf bytes.Buffer
+	buf.WriteString("New feedback received\n\n")
+
+	// helper to check if a value is empty/false
+	isEmpty := func(v interface{}) bool {
+		switch val := v.(type) {
+		case bool:
+			return !val
+		case string:
+			return val == ""
+		case nil:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// iterate through all fields in parsed feedback and include non-empty ones
+	for key, value := range parsed {
+		if isEmpty(value) {
+			continue
+		}
+
+		switch key {
+		case "additionalInformation":
+			if s, ok := value.(string); ok && s != "" {
+				buf.WriteString(fmt.Sprintf("**additionalInformation:**\n%s\n\n", s))
+			}
+		case "errorLog":
+			// pretty-print errorLog
+			if b, err := json.MarshalIndent(value, "", "  "); err == nil {
+				buf.WriteString(fmt.Sprintf("**errorLog:**\n```\n%s\n```\n\n", string(b)))
+			}
+		case "href":
+			if s, ok := value.(string); ok && s != "" {
+				buf.WriteString(fmt.Sprintf("**href:** %s\n\n", s))
+			}
+		case "reason":
+			if s, ok := value.(string); ok && s != "" {
+				buf.WriteString(fmt.Sprintf("**reason:** %s\n", s))
+			}
+		case "rating":
+			if num, ok := value.(float64); ok {
+				buf.WriteString(fmt.Sprintf("**rating:** %.0f\n", num))
+			}
+		case "subscriptionStatus":
+			if s, ok := value.(string); ok && s != "" {
+				buf.WriteString(fmt.Sprintf("**subscriptionStatus:** %s\n", s))
+			}
+		case "timestamp":
+			if s, ok := value.(string); ok && s != "" {
+				buf.WriteString(fmt.Sprintf("**timestamp:** %s\n", s))
+			}
+		default:
+			// include any other non-empty fields
+			switch val := value.(type) {
+			case string:
+				if val != "" {
+					buf.WriteString(fmt.Sprintf("**%s:** %s\n", key, val))
+				}
+			case bool:
+				if val {
+					buf.WriteString(fmt.Sprintf("**%s:** true\n", key))
+				}
+			case float64:
+				buf.WriteString(fmt.Sprintf("**%s:** %.0f\n", key, val))
+			default:
+				if b, err := json.Marshal(value); err == nil && string(b) != "null" {
+					buf.WriteString(fmt.Sprintf("**%s:** %s\n", key, string(b)))
+				}
+			}
+		}
+	}
+	buf.WriteString("\n")
+
+	subject := "New feedback received"
+	if feedback.Project != "" {
+		subject = fmt.Sprintf("New %s feedback received", feedback.Project)
+	}
+	return &Notification{
+		ID:       fmt.Sprintf("feedback-%d", feedback.ID),
+		Subject:  subject,
+		Text:     buf.String(),
+		Project:  feedback.Project,
+		Feedback: feedback,
+	}, nil
+}
+
+// contactNotification renders a contact form message.
+func contactNotification(c *ContactSubmission) *Notification {
+	return &Notification{
+		Subject: fmt.Sprintf("Contact form message from %s", c.Name),
+		// Keep the historical "<name> <email>: <message>" format.
+		Text:    fmt.Sprintf("%s %s: %s", c.Name, c.Email, c.Message),
+		Contact: c,
+	}
+}
+
+// plainText strips the markdown markers for sinks that show raw text.
+func (n *Notification) plainText() string {
+	return strings.NewReplacer("**", "", "```\n", "", "```", "").Replace(n.Text)
+}
+
+// notifier builds the Notifier for a configured target, recording delivery
+// latency and failures by target type.
+func (t NotifyTarget) notifier() (Notifier, error) {
+	n, err := t.baseNotifier()
+	if err != nil {
+		return nil, err
+	}
+	return instrument(n, t.Type), nil
+}
+
+func (t NotifyTarget) baseNotifier() (Notifier, error) {
+	switch t.Type {
+	case "discord":
+		return &DiscordNotifier{WebhookURL: t.webhook()}, nil
+	case "slack":
+		return &SlackNotifier{WebhookURL: t.webhook()}, nil
+	case "webhook":
+		headers := make(map[string]string, len(t.Headers))
+		for k, v := range t.Headers {
+			headers[k] = os.ExpandEnv(v)
+		}
+		return &WebhookNotifier{URL: t.webhook(), Headers: headers}, nil
+	case "matrix":
+		return &MatrixNotifier{
+			Homeserver:  t.Homeserver,
+			RoomID:      t.RoomID,
+			AccessToken: secret(t.AccessToken, t.AccessTokenEnv),
+		}, nil
+	case "smtp":
+		return &SMTPNotifier{
+			Host:     t.SMTPHost,
+			Port:     t.SMTPPort,
+			Username: t.Username,
+			Password: secret(t.Password, t.PasswordEnv),
+			From:     t.From,
+			To:       t.To,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown notify type %q", t.Type)
+}
+
+// validate checks that all settings the target's type needs are present.
+// Values read from env vars are only resolved at send time.
+func (t NotifyTarget) validate() error {
+	switch t.Type {
+	case "discord", "slack", "webhook":
+		if t.WebhookURL == "" && t.WebhookURLEnv == "" {
+			return fmt.Errorf("%s target needs webhookUrl or webhookUrlEnv", t.Type)
+		}
+	case "matrix":
+		if t.Homeserver == "" || t.RoomID == "" || (t.AccessToken == "" && t.AccessTokenEnv == "") {
+			return fmt.Errorf("matrix target needs homeserver, roomId and accessToken or accessTokenEnv")
+		}
+	case "smtp":
+		if t.SMTPHost == "" || t.From == "" || len(t.To) == 0 {
+			return fmt.Errorf("smtp target needs smtpHost, from and to")
+		}
+	default:
+		return fmt.Errorf("unknown notify type %q", t.Type)
+	}
+	return nil
+}
+
+// secret returns the inline value or, if that is empty, the named env var,
+// which can also be given as a file in <env>_FILE.
+func secret(inline, env string) string {
+	if inline != "" || env == "" {
+		return inline
+	}
+	v, err := readEnv(env)
+	if err != nil {
+		slog.Warn("could not read secret", "env", env, "err", err)
+	}
+	return v
+}
+
+// postJSON sends body as JSON and turns non 2xx answers into a DeliveryError.
+func postJSON(ctx context.Context, method, url string, headers map[string]string, body interface{}) error {
+	jsonPayload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error creating JSON payload: %w", err)
+	}
+	return sendBody(ctx, method, url, headers, "application/json", jsonPayload)
+}
+
+// sendBody sends a raw body and turns non 2xx answers into a DeliveryError.
+func sendBody(ctx context.Context, method, url string, headers map[string]string, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return deliveryErrorFromResponse(resp)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// captureServer records the last request it received.
+func captureServer(t *testing.T, status int) (*httptest.Server, *http.Request, *[]byte) {
+	t.Helper()
+	var last http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = *r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &last, &body
+}
+
+func TestFeedbackNotificationSkipsTrivialFeedback(t *testing.T) {
+	for _, raw := range []string{
+		`{"additionalInformation":"ok"}`,
+		`{"additionalInformation":"too short","loadNewInformation":false}`,
+	} {
+		n, err := feedbackNotification(&Feedback{Feedback: raw, AdditionalInformations: additionalInformationOf(mustJSON(t, raw))})
+		if err != nil || n != nil {
+			t.Errorf("%s: expected to be skipped, got %+v, %v", raw, n, err)
+		}
+	}
+
+	n, err := feedbackNotification(&Feedback{
+		Model:                  gorm.Model{ID: 7},
+		Project:                "sky",
+		Feedback:               `{"additionalInformation":"the auction page is broken","rating":2}`,
+		AdditionalInformations: "the auction page is broken",
+	})
+	if err != nil || n == nil {
+		t.Fatalf("expected a notification, got %v", err)
+	}
+	if n.ID != "feedback-7" || !strings.Contains(n.Text, "**rating:** 2") || !strings.Contains(n.Subject, "sky") {
+		t.Errorf("unexpected notification %+v", n)
+	}
+}
+
+func TestSlackNotifierUsesMrkdwn(t *testing.T) {
+	srv, _, body := captureServer(t, http.StatusOK)
+	err := (&SlackNotifier{WebhookURL: srv.URL}).Notify(context.Background(), &Notification{Text: "**rating:** 1 <script>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	json.Unmarshal(*body, &got)
+	if got["text"] != "*rating:* 1 &lt;script&gt;" {
+		t.Errorf("unexpected slack text %q", got["text"])
+	}
+}
+
+func TestMatrixNotifierSendsRoomMessage(t *testing.T) {
+	srv, req, body := captureServer(t, http.StatusOK)
+	m := &MatrixNotifier{Homeserver: srv.URL + "/", RoomID: "!room:example.org", AccessToken: "tok"}
+	err := m.Notify(context.Background(), &Notification{ID: "feedback-3", Text: "**href:** /auction\n```\n<err>\n```"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPut || req.URL.Path != "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/feedback-3" {
+		t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+	}
+	if req.Header.Get("Authorization") != "Bearer tok" {
+		t.Errorf("missing access token")
+	}
+	var got map[string]string
+	json.Unmarshal(*body, &got)
+	if got["formatted_body"] != "<strong>href:</strong> /auction<br><pre><code>&lt;err&gt;\n</code></pre>" {
+		t.Errorf("unexpected html %q", got["formatted_body"])
+	}
+	if got["body"] != "href: /auction\n<err>\n" {
+		t.Errorf("unexpected plain body %q", got["body"])
+	}
+}
+
+func TestWebhookNotifierSendsRecord(t *testing.T) {
+	srv, req, body := captureServer(t, http.StatusAccepted)
+	t.Setenv("HOOK_TOKEN", "abc")
+	target := NotifyTarget{Type: "webhook", WebhookURL: srv.URL, Headers: map[string]string{"X-Token": "${HOOK_TOKEN}"}}
+	notifier, err := target.notifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notifier.Notify(context.Background(), contactNotification(&ContactSubmission{Name: "Jane", Email: "jane@example.com", Message: "hi"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("X-Token") != "abc" {
+		t.Errorf("header not expanded: %q", req.Header.Get("X-Token"))
+	}
+	var got webhookPayload
+	json.Unmarshal(*body, &got)
+	if got.Contact == nil || got.Contact.Email != "jane@example.com" {
+		t.Errorf("contact missing from payload: %s", *body)
+	}
+}
+
+func TestSMTPMessage(t *testing.T) {
+	s := &SMTPNotifier{From: "feedback@coflnet.com", To: []string{"a@coflnet.com", "b@coflnet.com"}}
+	msg := string(s.message(contactNotification(&ContactSubmission{Name: "Jürgen\r\nBcc: x@evil", Email: "j@example.com", Message: "line1\nline2"}), time.Unix(0, 0)))
+	if !strings.Contains(msg, "To: a@coflnet.com, b@coflnet.com\r\n") || !strings.Contains(msg, "Reply-To: j@example.com\r\n") {
+		t.Errorf("headers missing:\n%s", msg)
+	}
+	headers, _, _ := strings.Cut(msg, "\r\n\r\n")
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Errorf("header injection through the subject:\n%s", msg)
+	}
+	if !strings.HasSuffix(msg, "line1\r\nline2") {
+		t.Errorf("body not CRLF normalised:\n%q", msg)
+	}
+}
+
+func TestNotifyTargetValidation(t *testing.T) {
+	valid := []NotifyTarget{
+		{Type: "discord", WebhookURLEnv: "WEBHOOK_URL"},
+		{Type: "slack", WebhookURL: "https://hooks.slack.com/x"},
+		{Type: "matrix", Homeserver: "https://matrix.org", RoomID: "!a:b", AccessTokenEnv: "MATRIX_TOKEN"},
+		{Type: "smtp", SMTPHost: "mail", From: "a@b.c", To: []string{"d@e.f"}},
+		{Type: "webhook", WebhookURL: "https://example.com"},
+	}
+	for _, target := range valid {
+		if err := target.validate(); err != nil {
+			t.Errorf("%s: %v", target.Type, err)
+		}
+	}
+	invalid := []NotifyTarget{
+		{Type: "discord"},
+		{Type: "matrix", Homeserver: "https://matrix.org"},
+		{Type: "smtp", SMTPHost: "mail"},
+		{Type: "pigeon"},
+	}
+	for _, target := range invalid {
+		if err := target.validate(); err == nil {
+			t.Errorf("%+v: expected an error", target)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, raw string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
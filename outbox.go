@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// OutboxStatus is the delivery state of an outbox message.
+type OutboxStatus string
+
+const (
+	OutboxPending   OutboxStatus = "pending"
+	OutboxDelivered OutboxStatus = "delivered"
+	OutboxDead      OutboxStatus = "dead"
+)
+
+// OutboxKind tells what an outbox message is about.
+type OutboxKind string
+
+const (
+	// OutboxFeedback forwards a stored feedback.
+	OutboxFeedback OutboxKind = "feedback"
+	// OutboxErrorAlert announces an error fingerprint seen for the first time.
+	OutboxErrorAlert OutboxKind = "error_alert"
+)
+
+// OutboxMessage is a pending notification about a stored feedback. It is
+// written in the same transaction as the feedback row, so a notification is
+// never lost once the client got its 204.
+type OutboxMessage struct {
+	gorm.Model
+	FeedbackID uint   `json:"feedbackId" gorm:"index"`
+	Project    string `json:"project"`
+	// Target is the index of the destination in the project's notify list.
+	Target int `json:"target"`
+
+	Kind OutboxKind `json:"kind" gorm:"default:feedback"`
+	// ErrorGroupID is set for error alerts.
+	ErrorGroupID *uint `json:"errorGroupId"`
+	// TraceContext is the W3C traceparent of the submission, the delivery
+	// continues its trace.
+	TraceContext string `json:"-"`
+
+	// Parts is how many messages of a split notification were delivered,
+	// see Notification.Parts.
+	Parts int `json:"parts"`
+
+	Status        OutboxStatus `json:"status" gorm:"index"`
+	Attempts      int          `json:"attempts"`
+	NextAttemptAt time.Time    `json:"nextAttemptAt" gorm:"index"`
+	LockedUntil   *time.Time   `json:"lockedUntil"`
+	LastError     string       `json:"lastError"`
+	DeliveredAt   *time.Time   `json:"deliveredAt"`
+}
+
+const (
+	// outboxPollInterval is how often the dispatcher looks for due messages.
+	outboxPollInterval = time.Second
+	// outboxLease is how long a claimed message stays invisible to other
+	// replicas. Must comfortably exceed one delivery attempt.
+	outboxLease = time.Minute
+	// outboxBatch caps how many messages are claimed per poll.
+	outboxBatch = 20
+
+	outboxBaseBackoff = 5 * time.Second
+	outboxMaxBackoff  = 30 * time.Minute
+)
+
+// DeliveryError describes a failed notification attempt. RetryAfter is set
+// when the destination asked us to slow down.
+type DeliveryError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *DeliveryError) Error() string {
+	if e.StatusCode == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("received status %d: %s", e.StatusCode, e.Message)
+}
+
+// permanent reports whether retrying can't help, e.g. a deleted webhook.
+func (e *DeliveryError) permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusTooManyRequests && e.StatusCode != http.StatusRequestTimeout
+}
+
+// deliveryErrorFromResponse builds a DeliveryError from a non-success
+// response, honouring Discord's retry_after (seconds) and Matrix's
+// retry_after_ms in the JSON body as well as the standard Retry-After header.
+func deliveryErrorFromResponse(resp *http.Response) *DeliveryError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &DeliveryError{StatusCode: resp.StatusCode, Message: resp.Status}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return e
+	}
+
+	var rl struct {
+		RetryAfter   float64 `json:"retry_after"`
+		RetryAfterMs int64   `json:"retry_after_ms"`
+	}
+	json.Unmarshal(body, &rl)
+	if rl.RetryAfter > 0 {
+		e.RetryAfter = time.Duration(rl.RetryAfter * float64(time.Second))
+	} else if rl.RetryAfterMs > 0 {
+		e.RetryAfter = time.Duration(rl.RetryAfterMs) * time.Millisecond
+	} else if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs * float64(time.Second))
+	}
+	return e
+}
+
+// outboxBackoff returns the delay before the given (1 based) attempt is
+// retried: exponential with full jitter on the upper half, capped.
+func outboxBackoff(attempt int) time.Duration {
+	d := outboxMaxBackoff
+	if attempt < 20 {
+		d = min(outboxBaseBackoff<<(attempt-1), outboxMaxBackoff)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// OutboxWorker delivers outbox messages in the background. Several replicas
+// may run one each; claiming a message takes a short lease on it.
+type OutboxWorker struct {
+	db          Store
+	projects    *ProjectRegistry
+	workers     int
+	maxAttempts int
+}
+
+func NewOutboxWorker(db Store, projects *ProjectRegistry, cfg *Config) *OutboxWorker {
+	return &OutboxWorker{
+		db:          db,
+		projects:    projects,
+		workers:     cfg.Outbox.Workers,
+		maxAttempts: cfg.Outbox.MaxAttempts,
+	}
+}
+
+// Run polls for due messages and hands them to the worker pool until ctx is
+// cancelled. In-flight deliveries are finished before it returns.
+func (w *OutboxWorker) Run(ctx context.Context) {
+	jobs := make(chan OutboxMessage)
+	var wg sync.WaitGroup
+	for range w.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				w.deliver(msg)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	defer wg.Wait()
+	defer close(jobs)
+
+	for {
+		msgs, err := w.db.ClaimOutboxMessages(outboxBatch, outboxLease)
+		if err != nil {
+			slog.Error("could not claim outbox messages", "err", err)
+		}
+		for _, msg := range msgs {
+			select {
+			case jobs <- msg:
+			case <-ctx.Done():
+				// unclaimed messages are picked up again once their lease expires
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver makes one delivery attempt and records its outcome.
+func (w *OutboxWorker) deliver(msg OutboxMessage) {
+	ctx, span := tracer.Start(withTraceContext(context.Background(), msg.TraceContext), "outbox.deliver",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("outbox.id", int64(msg.ID)),
+			attribute.String("outbox.kind", string(msg.Kind)),
+			attribute.Int("outbox.attempt", msg.Attempts+1),
+			attribute.String("feedback.project", msg.Project),
+		))
+	defer span.End()
+
+	err := w.send(ctx, &msg)
+	msg.Attempts++
+	now := time.Now()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	if err == nil {
+		msg.Status = OutboxDelivered
+		msg.DeliveredAt = &now
+		msg.LastError = ""
+	} else {
+		msg.LastError = err.Error()
+		var de *DeliveryError
+		switch {
+		case errors.As(err, &de) && de.permanent():
+			msg.Status = OutboxDead
+		case msg.Attempts >= w.maxAttempts:
+			msg.Status = OutboxDead
+		case errors.As(err, &de) && de.RetryAfter > 0:
+			msg.NextAttemptAt = now.Add(de.RetryAfter)
+		default:
+			msg.NextAttemptAt = now.Add(outboxBackoff(msg.Attempts))
+		}
+
+		if msg.Status == OutboxDead {
+			slog.Error("notification moved to dead letter", "outbox", msg.ID, "feedback", msg.FeedbackID, "attempts", msg.Attempts, "err", err)
+			if msg.Kind != OutboxErrorAlert {
+				feedbackCounter.WithLabelValues(msg.Project, outcomeNotifyFailed).Inc()
+			}
+		} else {
+			slog.Warn("notification failed; will retry", "outbox", msg.ID, "feedback", msg.FeedbackID, "attempts", msg.Attempts, "next", msg.NextAttemptAt, "err", err)
+		}
+	}
+
+	if err := w.db.FinishOutboxMessage(&msg); err != nil {
+		slog.Error("could not record outbox delivery", "outbox", msg.ID, "err", err)
+	}
+}
+
+// send notifies the message's target and records in msg how many parts of
+// the notification were delivered.
+func (w *OutboxWorker) send(ctx context.Context, msg *OutboxMessage) error {
+	project := w.projects.Get(msg.Project)
+	if project == nil || msg.Target < 0 || msg.Target >= len(project.Notify) {
+		return &DeliveryError{Message: fmt.Sprintf("notify target %d of project %q is no longer configured", msg.Target, msg.Project)}
+	}
+
+	var notification *Notification
+	var err error
+	if msg.Kind == OutboxErrorAlert {
+		notification, err = w.errorAlert(*msg)
+	} else {
+		notification, err = w.feedbackNotification(*msg)
+	}
+	if err != nil {
+		return err
+	}
+	if notification == nil {
+		return nil
+	}
+	notifier, err := project.Notify[msg.Target].notifier()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*notifyTimeout)
+	defer cancel()
+	notification.Parts = msg.Parts
+	err = notifier.Notify(ctx, notification)
+	msg.Parts = notification.Parts
+	return err
+}
+
+// feedbackNotification loads the feedback a message is about.
+func (w *OutboxWorker) feedbackNotification(msg OutboxMessage) (*Notification, error) {
+	feedback, err := w.db.GetFeedback(msg.FeedbackID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// nothing left to tell anyone about
+		return nil, &DeliveryError{StatusCode: http.StatusGone, Message: "feedback was deleted"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	notification, err := feedbackNotification(feedback)
+	if err != nil {
+		return nil, &DeliveryError{StatusCode: http.StatusUnprocessableEntity, Message: err.Error()}
+	}
+	return notification, nil
+}
+
+// outboxMessagesFor builds one pending message per notify target of the project.
+func outboxMessagesFor(project *Project) []OutboxMessage {
+	msgs := make([]OutboxMessage, 0, len(project.Notify))
+	now := time.Now()
+	for i := range project.Notify {
+		msgs = append(msgs, OutboxMessage{
+			Project:       project.Slug,
+			Target:        i,
+			Kind:          OutboxFeedback,
+			Status:        OutboxPending,
+			NextAttemptAt: now,
+		})
+	}
+	return msgs
+}
+
+// ClaimOutboxMessages leases up to limit due messages to this replica.
+func (d *DatabaseHandler) ClaimOutboxMessages(limit int, lease time.Duration) ([]OutboxMessage, error) {
+	now := time.Now()
+	var candidates []OutboxMessage
+	res := d.db.
+		Where("status = ? AND next_attempt_at <= ?", OutboxPending, now).
+		Where("locked_until IS NULL OR locked_until < ?", now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&candidates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	until := now.Add(lease)
+	claimed := candidates[:0]
+	for _, msg := range candidates {
+		// another replica may have claimed it since we read it
+		res := d.db.Model(&OutboxMessage{}).
+			Where("id = ? AND status = ?", msg.ID, OutboxPending).
+			Where("locked_until IS NULL OR locked_until < ?", now).
+			Update("locked_until", until)
+		if res.Error != nil {
+			return claimed, res.Error
+		}
+		if res.RowsAffected == 1 {
+			msg.LockedUntil = &until
+			claimed = append(claimed, msg)
+		}
+	}
+	return claimed, nil
+}
+
+// FinishOutboxMessage stores the result of a delivery attempt and releases
+// the lease.
+func (d *DatabaseHandler) FinishOutboxMessage(msg *OutboxMessage) error {
+	return d.db.Model(&OutboxMessage{}).Where("id = ?", msg.ID).Updates(map[string]interface{}{
+		"status":          msg.Status,
+		"attempts":        msg.Attempts,
+		"parts":           msg.Parts,
+		"next_attempt_at": msg.NextAttemptAt,
+		"last_error":      msg.LastError,
+		"delivered_at":    msg.DeliveredAt,
+		"locked_until":    nil,
+	}).Error
+}
+
+// ListOutboxMessages returns the newest messages in the given state.
+func (d *DatabaseHandler) ListOutboxMessages(status OutboxStatus, limit int) ([]OutboxMessage, error) {
+	var msgs []OutboxMessage
+	q := d.db.Order("id desc").Limit(limit)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	res := q.Find(&msgs)
+	return msgs, res.Error
+}
+
+// ReplayOutboxMessages puts dead messages back into the queue. With id 0 all
+// dead messages are replayed. It returns how many were requeued.
+func (d *DatabaseHandler) ReplayOutboxMessages(id uint) (int64, error) {
+	q := d.db.Model(&OutboxMessage{}).Where("status = ?", OutboxDead)
+	if id != 0 {
+		q = q.Where("id = ?", id)
+	}
+	res := q.Updates(map[string]interface{}{
+		"status":          OutboxPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+		"locked_until":    nil,
+	})
+	return res.RowsAffected, res.Error
+}
+
+func (h *ApiHandler) listOutboxRequest(c *fiber.Ctx) error {
+	status := OutboxStatus(c.Query("status", string(OutboxDead)))
+	switch status {
+	case OutboxPending, OutboxDelivered, OutboxDead:
+	default:
+		return fiber.NewError(http.StatusBadRequest, "unknown status")
+	}
+	limit := c.QueryInt("limit", feedbackPageDefault)
+	if limit <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "limit must be a positive integer")
+	}
+
+	msgs, err := h.store.ListOutboxMessages(status, min(limit, feedbackPageMax))
+	if err != nil {
+		slog.Error("could not list outbox", "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not list outbox")
+	}
+	return c.JSON(msgs)
+}
+
+func (h *ApiHandler) replayOutboxRequest(c *fiber.Ctx) error {
+	var id uint
+	if c.Params("id") != "" {
+		n, err := c.ParamsInt("id")
+		if err != nil || n <= 0 {
+			return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+		}
+		id = uint(n)
+	}
+
+	n, err := h.store.ReplayOutboxMessages(id)
+	if err != nil {
+		slog.Error("could not replay outbox", "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not replay outbox")
+	}
+	if id != 0 && n == 0 {
+		return fiber.NewError(http.StatusNotFound, "no dead letter with that id")
+	}
+
+	slog.Info("replaying dead notifications", "count", n)
+	return c.JSON(fiber.Map{"replayed": n})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiscordRateLimitHonoursRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message":"You are being rate limited.","retry_after":1.5,"global":false}`))
+	}))
+	defer srv.Close()
+
+	err := (&DiscordNotifier{WebhookURL: srv.URL}).Notify(context.Background(), &Notification{Text: "hi"})
+	var de *DeliveryError
+	if !errors.As(err, &de) {
+		t.Fatalf("expected a DeliveryError, got %v", err)
+	}
+	if de.RetryAfter != 1500*time.Millisecond {
+		t.Errorf("expected retry after 1.5s, got %s", de.RetryAfter)
+	}
+	if de.permanent() {
+		t.Error("rate limits must be retried")
+	}
+}
+
+func TestDiscordRetryAfterHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	var de *DeliveryError
+	if err := (&DiscordNotifier{WebhookURL: srv.URL}).Notify(context.Background(), &Notification{Text: "hi"}); !errors.As(err, &de) || de.RetryAfter != 3*time.Second {
+		t.Fatalf("expected retry after 3s, got %v", err)
+	}
+}
+
+func TestDeliveryErrorClassification(t *testing.T) {
+	for code, permanent := range map[int]bool{
+		http.StatusNotFound:            true,
+		http.StatusBadRequest:          true,
+		http.StatusTooManyRequests:     false,
+		http.StatusRequestTimeout:      false,
+		http.StatusInternalServerError: false,
+		http.StatusBadGateway:          false,
+	} {
+		if got := (&DeliveryError{StatusCode: code}).permanent(); got != permanent {
+			t.Errorf("status %d: permanent=%v, want %v", code, got, permanent)
+		}
+	}
+	if (&DeliveryError{Message: "webhook not configured"}).permanent() {
+		t.Error("configuration errors should be retried")
+	}
+}
+
+func TestOutboxBackoffGrowsAndCaps(t *testing.T) {
+	for attempt := 1; attempt <= 30; attempt++ {
+		d := outboxBackoff(attempt)
+		if d <= 0 || d > outboxMaxBackoff {
+			t.Fatalf("attempt %d: backoff %s out of range", attempt, d)
+		}
+	}
+	if outboxBackoff(1) > outboxBaseBackoff {
+		t.Errorf("first retry should wait at most %s", outboxBaseBackoff)
+	}
+	if outboxBackoff(15) < outboxMaxBackoff/2 {
+		t.Errorf("late retries should be near the cap")
+	}
+}
+
+func TestOutboxMessagesForProject(t *testing.T) {
+	p := &Project{Slug: "sky", Notify: []NotifyTarget{{Type: "discord"}, {Type: "discord"}}}
+	msgs := outboxMessagesFor(p)
+	if len(msgs) != 2 || msgs[1].Target != 1 || msgs[0].Status != OutboxPending || msgs[0].Project != "sky" {
+		t.Errorf("unexpected outbox messages %+v", msgs)
+	}
+	if len(outboxMessagesFor(&Project{Slug: "quiet"})) != 0 {
+		t.Error("projects without targets should not queue anything")
+	}
+}
+
+func TestOutboxResumesSplitNotification(t *testing.T) {
+	var mu sync.Mutex
+	posts, failed := 0, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if posts == 1 && !failed {
+			failed = true
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		posts++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	db := connectSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	defer db.Close()
+	projects, err := NewProjectRegistry([]*Project{{Slug: "sky", Notify: []NotifyTarget{{Type: "discord", WebhookURL: srv.URL}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	w := NewOutboxWorker(db, projects, cfg)
+
+	raw, _ := json.Marshal(map[string]string{"additionalInformation": strings.Repeat("the page crashed again. ", 400)})
+	f := &Feedback{Project: "sky", Feedback: string(raw), Payload: JSONB(raw)}
+	if err := db.SaveFeedback(context.Background(), f, outboxMessagesFor(projects.Get("sky")), nil); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := discordFeedbackMessages(f)
+	if err != nil || len(messages) < 2 {
+		t.Fatalf("expected a split message, got %d parts, %v", len(messages), err)
+	}
+
+	msgs, err := db.ClaimOutboxMessages(1, time.Minute)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("claimed %v, %v", msgs, err)
+	}
+	w.deliver(msgs[0])
+	pending, _ := db.ListOutboxMessages(OutboxPending, 1)
+	if len(pending) != 1 || pending[0].Parts != 1 {
+		t.Fatalf("after the failed part: %+v", pending)
+	}
+
+	pending[0].NextAttemptAt = time.Now()
+	w.deliver(pending[0])
+	if posts != len(messages) {
+		t.Errorf("discord got %d posts for %d parts", posts, len(messages))
+	}
+	delivered, _ := db.ListOutboxMessages(OutboxDelivered, 1)
+	if len(delivered) != 1 || delivered[0].Parts != len(messages) {
+		t.Errorf("delivered = %+v", delivered)
+	}
+}
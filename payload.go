@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// JSONB holds a raw JSON document. It is stored as JSONB on Postgres and
+// CockroachDB, as text on SQLite, and rendered inline (not as a string) in
+// API responses.
+type JSONB []byte
+
+func (j JSONB) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSONB) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = JSONB(v)
+	default:
+		return fmt.Errorf("cannot scan %T into JSONB", src)
+	}
+	return nil
+}
+
+func (j JSONB) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j *JSONB) UnmarshalJSON(b []byte) error {
+	*j = append((*j)[:0], b...)
+	return nil
+}
+
+func (JSONB) GormDataType() string {
+	return "jsonb"
+}
+
+func (JSONB) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "JSONB"
+	case "sqlite":
+		// a JSON column would have numeric affinity and turn a payload
+		// like 4 into an integer
+		return "TEXT"
+	}
+	return "JSON"
+}
+
+// backfillPayload fills the payload column for rows stored before it existed,
+// see migrationBackfills. Rows whose raw feedback is not valid JSON get a
+// JSON null.
+func backfillPayload(ctx context.Context, db *gorm.DB) error {
+	total := 0
+	for {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		var rows []Feedback
+		res := db.Unscoped().Select("id", "feedback").Where("payload IS NULL").Order("id").Limit(retentionBatch).Find(&rows)
+		if res.Error != nil {
+			return res.Error
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, r := range rows {
+				payload := JSONB("null")
+				if json.Valid([]byte(r.Feedback)) {
+					payload = JSONB(r.Feedback)
+				}
+				if err := tx.Unscoped().Model(&Feedback{}).Where("id = ?", r.ID).UpdateColumn("payload", payload).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		total += len(rows)
+		if len(rows) < retentionBatch {
+			break
+		}
+	}
+	if total > 0 {
+		slog.Info("backfilled feedback payloads", "rows", total)
+	}
+	return nil
+}
+
+// PayloadFilter is a condition on a path inside the stored payload, given in
+// the query string as payload.<path>=<op>:<value>, e.g.
+// payload.rating=lt:3 or payload.errorLog.message=contains:timeout.
+type PayloadFilter struct {
+	Path  []string
+	Op    string
+	Value string
+}
+
+var payloadPathSegment = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// payloadOps are the supported comparison operators.
+var payloadOps = map[string]bool{
+	"eq": true, "ne": true, "lt": true, "lte": true, "gt": true, "gte": true,
+	"contains": true, "prefix": true, "exists": true,
+}
+
+// maxPayloadFilters bounds how many json conditions a single query may use.
+const maxPayloadFilters = 10
+
+func parsePayloadFilter(key, raw string) (*PayloadFilter, error) {
+	path := strings.Split(strings.TrimPrefix(key, "payload."), ".")
+	for _, seg := range path {
+		if !payloadPathSegment.MatchString(seg) {
+			return nil, fmt.Errorf("invalid payload path %q", key)
+		}
+	}
+
+	// a bare value means equality, also if it contains a colon like a url;
+	// eq: compares values that start with an operator
+	op, value, ok := strings.Cut(raw, ":")
+	if !ok || !payloadOps[op] {
+		op, value = "eq", raw
+	}
+	switch op {
+	case "lt", "lte", "gt", "gte":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("%s needs a numeric value", key)
+		}
+	case "exists":
+		if value != "" && value != "true" && value != "false" {
+			return nil, fmt.Errorf("%s: exists takes true or false", key)
+		}
+	}
+	return &PayloadFilter{Path: path, Op: op, Value: value}, nil
+}
+
+// parsePayloadFilters collects all payload.* query parameters.
+func parsePayloadFilters(c *fiber.Ctx) ([]PayloadFilter, error) {
+	var filters []PayloadFilter
+	var err error
+	c.Context().QueryArgs().VisitAll(func(k, v []byte) {
+		key := string(k)
+		if err != nil || !strings.HasPrefix(key, "payload.") {
+			return
+		}
+		var f *PayloadFilter
+		f, err = parsePayloadFilter(key, string(v))
+		if err == nil {
+			filters = append(filters, *f)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(filters) > maxPayloadFilters {
+		return nil, errors.New("too many payload filters")
+	}
+	return filters, nil
+}
+
+// textExpr returns the SQL expression selecting the path as text. The path
+// segments were validated against payloadPathSegment, so inlining them is
+// safe, and top level keys use ->> so the expression indexes apply.
+func (f PayloadFilter) textExpr() string {
+	if len(f.Path) == 1 {
+		return fmt.Sprintf("(payload->>'%s')", f.Path[0])
+	}
+	return fmt.Sprintf("(payload#>>'{%s}')", strings.Join(f.Path, ","))
+}
+
+// jsonExpr is like textExpr but keeps the JSON type.
+func (f PayloadFilter) jsonExpr() string {
+	if len(f.Path) == 1 {
+		return fmt.Sprintf("(payload->'%s')", f.Path[0])
+	}
+	return fmt.Sprintf("(payload#>'{%s}')", strings.Join(f.Path, ","))
+}
+
+// apply adds the condition to a feedback query.
+func (f PayloadFilter) apply(q *gorm.DB) *gorm.DB {
+	expr := f.textExpr()
+	number := "CASE WHEN jsonb_typeof(" + f.jsonExpr() + ") = 'number' THEN " + expr + "::FLOAT8 END"
+	strpos, left := "strpos(%s, ?)", "left(%s, ?)"
+	if isSQLite(q) {
+		expr, number = sqliteJSONText(f.Path), sqliteJSONNumber(f.Path)
+		strpos, left = "instr(%s, ?)", "substr(%s, 1, ?)"
+	}
+	switch f.Op {
+	case "eq":
+		return q.Where(expr+" = ?", f.Value)
+	case "ne":
+		return q.Where("("+expr+" IS NULL OR "+expr+" <> ?)", f.Value)
+	case "contains":
+		return q.Where(fmt.Sprintf(strpos, expr)+" > 0", f.Value)
+	case "prefix":
+		return q.Where(fmt.Sprintf(left, expr)+" = ?", len([]rune(f.Value)), f.Value)
+	case "exists":
+		if f.Value == "false" {
+			return q.Where(expr + " IS NULL")
+		}
+		return q.Where(expr + " IS NOT NULL")
+	}
+
+	// numeric comparison; the CASE keeps non numeric values from failing the cast
+	cmp := map[string]string{"lt": "<", "lte": "<=", "gt": ">", "gte": ">="}[f.Op]
+	num, _ := strconv.ParseFloat(f.Value, 64)
+	return q.Where(number+" "+cmp+" ?", num)
+}
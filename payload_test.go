@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsePayloadFilter(t *testing.T) {
+	f, err := parsePayloadFilter("payload.rating", "lt:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Op != "lt" || f.Value != "3" || f.textExpr() != "(payload->>'rating')" {
+		t.Errorf("unexpected filter %+v (%s)", f, f.textExpr())
+	}
+
+	f, err = parsePayloadFilter("payload.errorLog.message", "timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Op != "eq" || f.textExpr() != "(payload#>>'{errorLog,message}')" {
+		t.Errorf("unexpected nested filter %+v (%s)", f, f.textExpr())
+	}
+
+	for value, want := range map[string]string{
+		"https://sky.coflnet.com/x": "https://sky.coflnet.com/x",
+		"like:/auction":             "like:/auction",
+		"eq:lt:3":                   "lt:3",
+	} {
+		f, err := parsePayloadFilter("payload.href", value)
+		if err != nil || f.Op != "eq" || f.Value != want {
+			t.Errorf("payload.href=%s: got %+v, %v", value, f, err)
+		}
+	}
+
+	for key, value := range map[string]string{
+		"payload.rating":         "lt:three",
+		"payload.a'b":            "x",
+		"payload.":               "x",
+		"payload.somethingBroke": "exists:maybe",
+	} {
+		if _, err := parsePayloadFilter(key, value); err == nil {
+			t.Errorf("%s=%s: expected an error", key, value)
+		}
+	}
+}
+
+func TestJSONBRendersInline(t *testing.T) {
+	out, err := json.Marshal(struct {
+		Payload JSONB `json:"payload"`
+		Empty   JSONB `json:"empty"`
+	}{Payload: JSONB(`{"rating":2}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"payload":{"rating":2},"empty":null}` {
+		t.Errorf("unexpected json %s", out)
+	}
+}
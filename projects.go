@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// defaultAllowedOrigins is the CORS allow list used for every project that
+// doesn't declare its own origins, unless CORS_ORIGINS replaces it.
+var defaultAllowedOrigins = []string{
+	"https://pro.skyblock.bz",
+	"https://songvoter.coflnet.com",
+	"https://sky.coflnet.com",
+	"https://coflnet.com",
+	"https://www.coflnet.com",
+}
+
+// Project describes one product that submits feedback. Projects are served by
+// POST /api/{slug}/feedback and additionally under each of their aliases.
+type Project struct {
+	Slug           string           `json:"slug"`
+	Aliases        []string         `json:"aliases"`
+	AllowedOrigins []string         `json:"allowedOrigins"`
+	Notify         []NotifyTarget   `json:"notify"`
+	Validation     ValidationPolicy `json:"validation"`
+	Spam           SpamPolicy       `json:"spam"`
+	Retention      RetentionPolicy  `json:"retention"`
+	Redaction      RedactionPolicy  `json:"redaction"`
+	Errors         ErrorPolicy      `json:"errors"`
+}
+
+// NotifyTarget is a destination new feedback of a project is forwarded to.
+// Type is one of discord, slack, matrix, smtp or webhook and decides which of
+// the other fields apply. Secrets can be given inline or through the *Env
+// fields naming an env var, so they don't have to live in the projects file.
+type NotifyTarget struct {
+	Type string `json:"type"`
+
+	// discord, slack and webhook
+	WebhookURL    string `json:"webhookUrl,omitempty"`
+	WebhookURLEnv string `json:"webhookUrlEnv,omitempty"`
+	// webhook only; values are expanded with os.ExpandEnv
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// matrix
+	Homeserver     string `json:"homeserver,omitempty"`
+	RoomID         string `json:"roomId,omitempty"`
+	AccessToken    string `json:"accessToken,omitempty"`
+	AccessTokenEnv string `json:"accessTokenEnv,omitempty"`
+
+	// smtp
+	SMTPHost    string   `json:"smtpHost,omitempty"`
+	SMTPPort    int      `json:"smtpPort,omitempty"`
+	Username    string   `json:"username,omitempty"`
+	Password    string   `json:"password,omitempty"`
+	PasswordEnv string   `json:"passwordEnv,omitempty"`
+	From        string   `json:"from,omitempty"`
+	To          []string `json:"to,omitempty"`
+}
+
+// webhook resolves the configured webhook url.
+func (t NotifyTarget) webhook() string {
+	return secret(t.WebhookURL, t.WebhookURLEnv)
+}
+
+// ValidationPolicy decides which submissions a project accepts.
+type ValidationPolicy struct {
+	// RequireAdditionalInformation rejects feedback whose payload has no
+	// non-empty additionalInformation string.
+	RequireAdditionalInformation bool `json:"requireAdditionalInformation"`
+	// MaxFeedbackBytes caps the size of the raw feedback string, 0 = no limit.
+	MaxFeedbackBytes int `json:"maxFeedbackBytes"`
+	// AllowedFeedbackNames restricts fedbackName to the listed values when set.
+	AllowedFeedbackNames []string `json:"allowedFeedbackNames"`
+}
+
+// check validates a parsed feedback against the policy.
+func (p ValidationPolicy) check(f *Feedback) error {
+	if p.MaxFeedbackBytes > 0 && len(f.Feedback) > p.MaxFeedbackBytes {
+		return &FeedbackValidationError{Reason: fmt.Sprintf("feedback exceeds %d bytes", p.MaxFeedbackBytes)}
+	}
+	if len(p.AllowedFeedbackNames) > 0 && !slices.Contains(p.AllowedFeedbackNames, f.FeedbackName) {
+		return &FeedbackValidationError{Reason: fmt.Sprintf("feedback name %q is not allowed", f.FeedbackName)}
+	}
+	if p.RequireAdditionalInformation && f.AdditionalInformations == "" {
+		return &AdditionalInformationIsEmptyError{}
+	}
+	return nil
+}
+
+// SpamPolicy configures the spam scoring of a project's feedback. Spam is
+// stored for review but never notified.
+type SpamPolicy struct {
+	// Threshold is the score from which feedback counts as spam,
+	// 0 = spamRejectThreshold.
+	Threshold int `json:"threshold"`
+	// Disabled turns scoring off for the project.
+	Disabled bool `json:"disabled"`
+}
+
+// classify scores the feedback, records the verdict on it and reports
+// whether it is spam.
+func (p SpamPolicy) classify(f *Feedback) bool {
+	if p.Disabled {
+		return false
+	}
+	threshold := p.Threshold
+	if threshold <= 0 {
+		threshold = spamRejectThreshold
+	}
+
+	f.SpamScore, f.SpamReasons = spamScore("", "", feedbackSpamText(f))
+	f.Spam = f.SpamScore >= threshold
+	return f.Spam
+}
+
+// FeedbackValidationError is returned when a submission violates the
+// validation policy of its project.
+type FeedbackValidationError struct {
+	Reason string
+}
+
+func (e *FeedbackValidationError) Error() string {
+	return "invalid feedback: " + e.Reason
+}
+
+// defaultProjects mirrors the handlers that existed before projects were
+// configurable. It is used when no PROJECTS_FILE is set.
+func defaultProjects() []*Project {
+	strict := ValidationPolicy{RequireAdditionalInformation: true}
+	return []*Project{
+		{
+			Slug:       "sky",
+			Aliases:    []string{"/api"},
+			Notify:     []NotifyTarget{{Type: "discord", WebhookURLEnv: "WEBHOOK_URL"}},
+			Validation: strict,
+		},
+		{
+			Slug:       "songvoter",
+			Aliases:    []string{"/api/songvoter-feedback"},
+			Validation: strict,
+		},
+		{
+			Slug:       "pro-skyblock",
+			Aliases:    []string{"/api/pro-skyblock-feedback"},
+			Validation: strict,
+		},
+	}
+}
+
+// ProjectRegistry holds all configured projects keyed by slug.
+type ProjectRegistry struct {
+	projects map[string]*Project
+	ordered  []*Project
+	// defaultOrigins stay allowed by the global CORS middleware even if no
+	// project lists them, e.g. for the dashboard.
+	defaultOrigins []string
+}
+
+var slugRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// fixedRoutePrefixes are the paths below which newApp and registerDashboard
+// register their routes. Aliases may not lie below them, and a slug may not
+// be their first segment below /api.
+var fixedRoutePrefixes = []string{
+	"/health",
+	"/openapi.yaml",
+	"/admin",
+	"/api/feedback",
+	"/api/issues",
+	"/api/errors",
+	"/api/stats",
+	"/api/admin",
+	"/api/contact-form",
+}
+
+// reservedRoute reports whether path is one of the fixed routes or below one.
+func reservedRoute(path string) bool {
+	// fiber matches paths case-insensitively and with or without a trailing slash
+	path = strings.ToLower(strings.TrimSuffix(path, "/"))
+	for _, prefix := range fixedRoutePrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// NewProjectRegistry validates the given projects and indexes them.
+func NewProjectRegistry(projects []*Project) (*ProjectRegistry, error) {
+	r := &ProjectRegistry{projects: make(map[string]*Project), defaultOrigins: defaultAllowedOrigins}
+	aliases := make(map[string]string)
+	for _, p := range projects {
+		if !slugRegex.MatchString(p.Slug) || reservedRoute("/api/"+p.Slug) {
+			return nil, fmt.Errorf("invalid project slug %q", p.Slug)
+		}
+		if _, dup := r.projects[p.Slug]; dup {
+			return nil, fmt.Errorf("duplicate project slug %q", p.Slug)
+		}
+		for _, a := range p.Aliases {
+			if !strings.HasPrefix(a, "/") {
+				return nil, fmt.Errorf("project %q: alias %q must start with /", p.Slug, a)
+			}
+			if reservedRoute(a) {
+				return nil, fmt.Errorf("project %q: alias %q collides with a fixed route", p.Slug, a)
+			}
+			if other, dup := aliases[a]; dup {
+				return nil, fmt.Errorf("alias %q used by both %q and %q", a, other, p.Slug)
+			}
+			aliases[a] = p.Slug
+		}
+		for i, n := range p.Notify {
+			if err := n.validate(); err != nil {
+				return nil, fmt.Errorf("project %q notify target %d: %w", p.Slug, i, err)
+			}
+		}
+		if err := p.Retention.validate(); err != nil {
+			return nil, fmt.Errorf("project %q: %w", p.Slug, err)
+		}
+		if err := p.Redaction.compile(); err != nil {
+			return nil, fmt.Errorf("project %q: %w", p.Slug, err)
+		}
+		r.projects[p.Slug] = p
+		r.ordered = append(r.ordered, p)
+	}
+	return r, nil
+}
+
+// LoadProjectRegistry reads the projects from the JSON file named by
+// PROJECTS_FILE, or falls back to the built-in defaults. Browsers may call
+// projects without their own origins from the configured CORS allow list.
+func LoadProjectRegistry(cfg *Config) (*ProjectRegistry, error) {
+	projects, err := loadProjects(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewProjectRegistry(projects)
+	if err != nil {
+		return nil, err
+	}
+	r.defaultOrigins = cfg.HTTP.AllowedOrigins
+	return r, nil
+}
+
+func loadProjects(cfg *Config) ([]*Project, error) {
+	path := cfg.ProjectsFile
+	if path == "" {
+		slog.Info("PROJECTS_FILE not set; using built-in projects")
+		projects := defaultProjects()
+		// the sky project notifies WEBHOOK_URL
+		projects[0].Notify[0].WebhookURL = cfg.WebhookURL
+		return projects, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading projects file: %w", err)
+	}
+	var projects []*Project
+	if err := json.Unmarshal(b, &projects); err != nil {
+		return nil, fmt.Errorf("parsing projects file %s: %w", path, err)
+	}
+	return projects, nil
+}
+
+// ContactNotifiers returns the notifiers of the project slug, which receive
+// contact form messages. It returns nil when slug is empty.
+func (r *ProjectRegistry) ContactNotifiers(slug string) ([]Notifier, error) {
+	if slug == "" {
+		return nil, nil
+	}
+	p := r.Get(slug)
+	if p == nil {
+		return nil, fmt.Errorf("CONTACT_PROJECT %q is not a configured project", slug)
+	}
+	notifiers := make([]Notifier, 0, len(p.Notify))
+	for _, t := range p.Notify {
+		n, err := t.notifier()
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+// Get returns the project with the given slug or nil.
+func (r *ProjectRegistry) Get(slug string) *Project {
+	return r.projects[slug]
+}
+
+// metricLabel returns the slug for a project label of a metric. Names that
+// aren't registered, e.g. from a query string, all count as "" so they can't
+// create new series.
+func (r *ProjectRegistry) metricLabel(slug string) string {
+	if p := r.Get(slug); p != nil {
+		return p.Slug
+	}
+	return ""
+}
+
+// All returns the projects in configuration order.
+func (r *ProjectRegistry) All() []*Project {
+	return r.ordered
+}
+
+// originAllowed reports whether any project accepts requests from origin.
+// It backs the global CORS middleware.
+func (r *ProjectRegistry) originAllowed(origin string) bool {
+	for _, p := range r.ordered {
+		if len(p.AllowedOrigins) > 0 && p.allowsOrigin(origin) {
+			return true
+		}
+	}
+	return slices.Contains(r.defaultOrigins, origin)
+}
+
+// allowsOrigin reports whether browser requests from origin may submit
+// feedback to this project. Only projects that list their origins restrict
+// them; the others keep accepting every caller, as before projects existed.
+func (p *Project) allowsOrigin(origin string) bool {
+	if len(p.AllowedOrigins) == 0 {
+		return true
+	}
+	return slices.Contains(p.AllowedOrigins, origin) || slices.Contains(p.AllowedOrigins, "*")
+}
+
+// additionalInformationOf extracts the additionalInformation string from a
+// parsed feedback payload. Non-string values are ignored.
+func additionalInformationOf(data interface{}) string {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	v, ok := m["additionalInformation"]
+	if !ok {
+		slog.Warn("could not find additionalInformation in feedback data")
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		slog.Warn("additionalInformation is not a string, can't use it")
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultProjectsKeepLegacyUrls(t *testing.T) {
+	r, err := NewProjectRegistry(defaultProjects())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases := map[string]string{}
+	for _, p := range r.All() {
+		for _, a := range p.Aliases {
+			aliases[a] = p.Slug
+		}
+	}
+	for _, url := range []string{"/api", "/api/songvoter-feedback", "/api/pro-skyblock-feedback"} {
+		if aliases[url] == "" {
+			t.Errorf("legacy url %s is not served by any project", url)
+		}
+	}
+	// only the main feedback endpoint forwarded to discord
+	if n := len(r.Get(aliases["/api"]).Notify); n != 1 {
+		t.Errorf("expected /api to notify once, got %d", n)
+	}
+	if n := len(r.Get(aliases["/api/songvoter-feedback"]).Notify); n != 0 {
+		t.Errorf("expected songvoter not to notify, got %d", n)
+	}
+}
+
+func TestProjectRegistryRejectsBadConfig(t *testing.T) {
+	cases := map[string][]*Project{
+		"bad slug":      {{Slug: "Not Valid"}},
+		"reserved slug": {{Slug: "feedback"}},
+		"duplicate":     {{Slug: "a"}, {Slug: "a"}},
+		"alias clash":   {{Slug: "a", Aliases: []string{"/x"}}, {Slug: "b", Aliases: []string{"/x"}}},
+		"route slug":    {{Slug: "issues"}},
+		"admin alias":   {{Slug: "a", Aliases: []string{"/api/admin/keys"}}},
+		"replay alias":  {{Slug: "a", Aliases: []string{"/api/admin/outbox/replay"}}},
+		"login alias":   {{Slug: "a", Aliases: []string{"/Admin/login/"}}},
+		"stats alias":   {{Slug: "a", Aliases: []string{"/api/stats"}}},
+		"notify type":   {{Slug: "a", Notify: []NotifyTarget{{Type: "pigeon"}}}},
+	}
+	for name, projects := range cases {
+		if _, err := NewProjectRegistry(projects); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadProjectRegistryFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	err := os.WriteFile(path, []byte(`[
+		{"slug": "mod", "allowedOrigins": ["https://mod.example"], "validation": {"maxFeedbackBytes": 10}}
+	]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.ProjectsFile = path
+
+	r, err := LoadProjectRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := r.Get("mod")
+	if p == nil {
+		t.Fatal("project from file not loaded")
+	}
+	if !p.allowsOrigin("https://mod.example") || p.allowsOrigin("https://sky.coflnet.com") {
+		t.Error("project origins not applied")
+	}
+	if !r.originAllowed("https://sky.coflnet.com") {
+		t.Error("default origins should stay allowed globally")
+	}
+}
+
+func TestValidationPolicy(t *testing.T) {
+	p := ValidationPolicy{RequireAdditionalInformation: true, MaxFeedbackBytes: 20, AllowedFeedbackNames: []string{"bug"}}
+
+	if err := p.check(&Feedback{Feedback: "{}", FeedbackName: "bug", AdditionalInformations: "broken"}); err != nil {
+		t.Errorf("valid feedback rejected: %v", err)
+	}
+	var empty *AdditionalInformationIsEmptyError
+	if err := p.check(&Feedback{Feedback: "{}", FeedbackName: "bug"}); !errors.As(err, &empty) {
+		t.Errorf("expected empty additional information error, got %v", err)
+	}
+	var invalid *FeedbackValidationError
+	if err := p.check(&Feedback{Feedback: "{}", FeedbackName: "other", AdditionalInformations: "x"}); !errors.As(err, &invalid) {
+		t.Errorf("expected name to be rejected, got %v", err)
+	}
+	if err := p.check(&Feedback{Feedback: `{"additionalInformation":"much too long"}`, FeedbackName: "bug", AdditionalInformations: "x"}); !errors.As(err, &invalid) {
+		t.Errorf("expected size to be rejected, got %v", err)
+	}
+}
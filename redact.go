@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var redactionCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "feedback_redactions_total",
+	Help: "values redacted from feedback payloads before storage, by detector",
+}, []string{"project", "detector"})
+
+// RedactionMode is what happens to a detected value.
+type RedactionMode string
+
+const (
+	// RedactMask replaces the value with "[redacted:<detector>]".
+	RedactMask RedactionMode = "mask"
+	// RedactHash replaces the value with "[<detector>:<hash>]", so the same
+	// value can still be correlated across feedback without being readable.
+	RedactHash RedactionMode = "hash"
+	// RedactDrop removes the whole JSON value the detection was found in.
+	RedactDrop RedactionMode = "drop"
+)
+
+// built-in detector names
+const (
+	detectEmail  = "email"
+	detectJWT    = "jwt"
+	detectBearer = "bearer"
+	detectIP     = "ip"
+	detectUUID   = "uuid"
+	detectSecret = "secret"
+)
+
+var builtinDetectors = []string{detectEmail, detectJWT, detectBearer, detectIP, detectUUID, detectSecret}
+
+// redactor finds one kind of sensitive value in a string. When the regex
+// has a "value" group only that part of the match is redacted, e.g. the
+// token after "Bearer ".
+type redactor struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(string) bool
+}
+
+// builtinRedactors are applied in this order, JWTs before bearer tokens so
+// a "Bearer eyJ…" header is reported as the JWT it contains.
+var builtinRedactors = []redactor{
+	{name: detectJWT, re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]*`)},
+	{name: detectBearer, re: regexp.MustCompile(`(?i)\bbearer\s+(?P<value>[A-Za-z0-9\-._~+/]{8,}=*)`)},
+	{name: detectSecret, re: regexp.MustCompile(`\bfb[ap]_[A-Za-z0-9_-]{20,}`)},
+	{name: detectSecret, re: regexp.MustCompile(`(?i)(?:api[_-]?key|access[_-]?token|auth[_-]?token|refresh[_-]?token|client[_-]?secret|secret|password|passwd|session[_-]?id)["']?\s*[:=]\s*["']?(?P<value>[^\s"',;&]{6,})`)},
+	{name: detectEmail, re: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)},
+	{name: detectUUID, re: regexp.MustCompile(`(?i)\b[0-9a-f]{8}-?[0-9a-f]{4}-?[0-9a-f]{4}-?[0-9a-f]{4}-?[0-9a-f]{12}\b`)},
+	{name: detectIP, re: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+	// anything with at least two colons that doesn't start or end inside a
+	// word is a candidate, net.ParseIP sorts out times and the like
+	{name: detectIP, re: regexp.MustCompile(`(?i)(?:^|[^0-9a-z:])(?P<value>(?:[0-9a-f]{0,4}:){2,7}[0-9a-f]{0,4})(?:$|[^0-9a-z:])`), valid: func(s string) bool {
+		return len(s) > 2 && net.ParseIP(s) != nil
+	}},
+}
+
+// sensitiveKeys are JSON keys whose string value is redacted as a whole by
+// the secret detector, whatever it looks like.
+var sensitiveKeys = regexp.MustCompile(`(?i)^(password|passwd|secret|client_?secret|token|access_?token|refresh_?token|api_?key|authorization|cookie|session_?id)$`)
+
+// RedactionPattern is a project specific detector.
+type RedactionPattern struct {
+	Name  string `json:"name"`
+	Regex string `json:"regex"`
+}
+
+// RedactionPolicy controls how sensitive values are removed from a
+// project's feedback payload before it is stored or forwarded.
+type RedactionPolicy struct {
+	// Disabled turns redaction off.
+	Disabled bool `json:"disabled"`
+	// Mode is mask (default), hash or drop.
+	Mode RedactionMode `json:"mode"`
+	// Detectors lists the built-in detectors to use, default all of them.
+	Detectors []string `json:"detectors"`
+	// Patterns are additional regexes. A "value" group limits the
+	// redaction to that part of the match.
+	Patterns []RedactionPattern `json:"patterns"`
+
+	redactors []redactor
+	secretKey bool
+	compiled  bool
+}
+
+// compile validates the policy and prepares its detectors.
+func (r *RedactionPolicy) compile() error {
+	switch r.Mode {
+	case "":
+		r.Mode = RedactMask
+	case RedactMask, RedactHash, RedactDrop:
+	default:
+		return fmt.Errorf("unknown redaction mode %q", r.Mode)
+	}
+	detectors := r.Detectors
+	if detectors == nil {
+		detectors = builtinDetectors
+	}
+	for _, d := range detectors {
+		if !slices.Contains(builtinDetectors, d) {
+			return fmt.Errorf("unknown redaction detector %q, use one of %v", d, builtinDetectors)
+		}
+	}
+
+	r.redactors = nil
+	for _, red := range builtinRedactors {
+		if slices.Contains(detectors, red.name) {
+			r.redactors = append(r.redactors, red)
+		}
+	}
+	r.secretKey = slices.Contains(detectors, detectSecret)
+	for _, p := range r.Patterns {
+		if p.Name == "" {
+			return fmt.Errorf("redaction pattern %q needs a name", p.Regex)
+		}
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return fmt.Errorf("redaction pattern %q: %w", p.Name, err)
+		}
+		r.redactors = append(r.redactors, redactor{name: p.Name, re: re})
+	}
+	r.compiled = true
+	return nil
+}
+
+// redactionHashKey keys the hashes of hash mode. Emails and IPs are easy to
+// enumerate, so a plain hash would hardly hide them. Set from the config.
+var redactionHashKey []byte
+
+func (r *RedactionPolicy) replacement(detector, value string) string {
+	if r.Mode == RedactHash {
+		mac := hmac.New(sha256.New, redactionHashKey)
+		mac.Write([]byte(value))
+		return fmt.Sprintf("[%s:%s]", detector, hex.EncodeToString(mac.Sum(nil))[:12])
+	}
+	return fmt.Sprintf("[redacted:%s]", detector)
+}
+
+// redactString runs all detectors over s and counts the redactions by
+// detector into counts.
+func (r *RedactionPolicy) redactString(s string, counts map[string]int) string {
+	for _, red := range r.redactors {
+		group := red.re.SubexpIndex("value")
+		var out []byte
+		last := 0
+		for _, m := range red.re.FindAllStringSubmatchIndex(s, -1) {
+			start, end := m[0], m[1]
+			if group > 0 && m[2*group] >= 0 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			if red.valid != nil && !red.valid(s[start:end]) {
+				continue
+			}
+			out = append(out, s[last:start]...)
+			out = append(out, r.replacement(red.name, s[start:end])...)
+			last = end
+			counts[red.name]++
+		}
+		if out != nil {
+			s = string(append(out, s[last:]...))
+		}
+	}
+	return s
+}
+
+// redactValue walks a parsed JSON value. It returns the redacted value and
+// false if drop mode wants it removed.
+func (r *RedactionPolicy) redactValue(key string, v interface{}, counts map[string]int) (interface{}, bool) {
+	switch val := v.(type) {
+	case string:
+		before := sumCounts(counts)
+		if r.secretKey && val != "" && sensitiveKeys.MatchString(key) {
+			counts[detectSecret]++
+			val = r.replacement(detectSecret, val)
+		} else {
+			val = r.redactString(val, counts)
+		}
+		if r.Mode == RedactDrop && sumCounts(counts) > before {
+			return nil, false
+		}
+		return val, true
+	case map[string]interface{}:
+		for k, inner := range val {
+			redacted, keep := r.redactValue(k, inner, counts)
+			if keep {
+				val[k] = redacted
+			} else {
+				delete(val, k)
+			}
+		}
+		return val, true
+	case []interface{}:
+		kept := val[:0]
+		for _, inner := range val {
+			if redacted, keep := r.redactValue(key, inner, counts); keep {
+				kept = append(kept, redacted)
+			}
+		}
+		return kept, true
+	}
+	return v, true
+}
+
+func sumCounts(counts map[string]int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+// apply redacts the feedback payload in place and returns the redactions
+// made per detector. The raw feedback is only rewritten when something was
+// redacted.
+func (r *RedactionPolicy) apply(f *Feedback) map[string]int {
+	counts := make(map[string]int)
+	// the registry compiles every policy
+	if r.Disabled || !r.compiled {
+		return counts
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal([]byte(f.Feedback), &payload); err != nil {
+		return counts
+	}
+	redacted, keep := r.redactValue("", payload, counts)
+	total := sumCounts(counts)
+	if total == 0 {
+		return counts
+	}
+	if !keep {
+		redacted = nil
+	}
+	b, err := json.Marshal(redacted)
+	if err != nil {
+		slog.Error("could not marshal redacted feedback", "err", err)
+		return counts
+	}
+	f.Feedback = string(b)
+	f.Payload = JSONB(b)
+	f.AdditionalInformations = additionalInformationOf(redacted)
+	f.Redactions = total
+	return counts
+}
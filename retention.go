@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+var (
+	retentionRowsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedback_retention_rows_total",
+		Help: "feedback rows soft deleted or stripped by the retention job",
+	}, []string{"project", "action"})
+
+	retentionPurgedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "feedback_retention_purged_total",
+		Help: "soft deleted feedback rows removed for good by the retention job",
+	})
+
+	retentionLastRunRows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "feedback_retention_last_run_rows",
+		Help: "rows the last retention run deleted, stripped or purged",
+	}, []string{"action"})
+
+	retentionLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "feedback_retention_last_run_timestamp_seconds",
+		Help: "when the retention job last finished successfully",
+	})
+)
+
+const (
+	// defaultRetentionInterval is how often the retention job runs when
+	// RETENTION_INTERVAL is not set.
+	defaultRetentionInterval = time.Hour
+	// defaultPurgeAfter is how long soft deleted feedback can still be
+	// restored before it is removed for good.
+	defaultPurgeAfter = 30 * 24 * time.Hour
+	// retentionBatch bounds the rows touched per statement, so a first run
+	// over years of feedback doesn't become one huge transaction.
+	retentionBatch = 500
+)
+
+// strippable fields and how a RetentionPolicy refers to them
+const (
+	stripUser                  = "user"
+	stripContext               = "context"
+	stripErrorLog              = "errorLog"
+	stripAdditionalInformation = "additionalInformation"
+)
+
+var strippableFields = []string{stripUser, stripContext, stripErrorLog, stripAdditionalInformation}
+
+// RetentionPolicy limits how long a project's feedback is kept. Zero values
+// keep feedback forever.
+type RetentionPolicy struct {
+	// DeleteAfterDays soft deletes feedback older than this.
+	DeleteAfterDays int `json:"deleteAfterDays"`
+	// StripAfterDays removes the Strip fields from feedback older than
+	// this. Everything else, such as rating, href or status, stays for the
+	// statistics.
+	StripAfterDays int `json:"stripAfterDays"`
+	// Strip lists the fields to remove, any of "user", "context", "errorLog"
+	// and "additionalInformation". Defaults to user and errorLog.
+	Strip []string `json:"strip"`
+}
+
+func (r RetentionPolicy) validate() error {
+	if r.DeleteAfterDays < 0 || r.StripAfterDays < 0 {
+		return fmt.Errorf("retention days must not be negative")
+	}
+	for _, f := range r.Strip {
+		if !slices.Contains(strippableFields, f) {
+			return fmt.Errorf("can't strip %q, use one of %v", f, strippableFields)
+		}
+	}
+	return nil
+}
+
+func (r RetentionPolicy) stripFields() []string {
+	if len(r.Strip) == 0 {
+		return []string{stripUser, stripErrorLog}
+	}
+	return r.Strip
+}
+
+// stripFeedback removes the given fields from a feedback entry, from the
+// columns as well as from the payload and the raw JSON it was parsed from.
+// It returns the column changes to write.
+func stripFeedback(f *Feedback, fields []string, now time.Time) map[string]interface{} {
+	changes := map[string]interface{}{"stripped_at": now}
+	var payload map[string]interface{}
+	payloadOK := json.Unmarshal(f.Payload, &payload) == nil && payload != nil
+
+	for _, field := range fields {
+		switch field {
+		case stripUser:
+			f.User = ""
+			changes["user"] = ""
+		case stripContext:
+			f.Context = ""
+			changes["context"] = ""
+		case stripAdditionalInformation:
+			f.AdditionalInformations = ""
+			changes["additional_informations"] = ""
+		}
+		if payloadOK {
+			delete(payload, field)
+		}
+	}
+
+	if payloadOK {
+		if b, err := json.Marshal(payload); err == nil {
+			f.Payload = JSONB(b)
+			f.Feedback = string(b)
+			changes["payload"] = f.Payload
+			changes["feedback"] = f.Feedback
+		}
+	} else {
+		// can't tell what's inside, so don't keep it
+		f.Payload = JSONB("null")
+		f.Feedback = ""
+		changes["payload"] = f.Payload
+		changes["feedback"] = ""
+	}
+	f.StrippedAt = &now
+	// stripped text must not stay findable
+	f.indexForSearch()
+	changes["search_language"] = f.SearchLanguage
+	changes["search_vector"] = f.SearchVector
+	return changes
+}
+
+// SoftDeleteFeedbackBefore soft deletes a project's feedback created before
+// the cutoff.
+func (d *DatabaseHandler) SoftDeleteFeedbackBefore(project string, cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		ids := d.db.Model(&Feedback{}).Select("id").
+			Where("project = ? AND created_at < ?", project, cutoff).
+			Limit(retentionBatch)
+		res := d.db.Where("id IN (?)", ids).Delete(&Feedback{})
+		if res.Error != nil {
+			return total, res.Error
+		}
+		total += res.RowsAffected
+		if res.RowsAffected < retentionBatch {
+			return total, nil
+		}
+	}
+}
+
+// StripFeedbackBefore strips the fields from a project's feedback created
+// before the cutoff. Entries are only stripped once; a field added to the
+// policy later applies to newly expiring feedback only.
+func (d *DatabaseHandler) StripFeedbackBefore(project string, cutoff time.Time, fields []string) (int64, error) {
+	var total int64
+	for {
+		var batch []Feedback
+		err := d.db.Where("project = ? AND created_at < ? AND stripped_at IS NULL", project, cutoff).
+			Order("id").Limit(retentionBatch).Find(&batch).Error
+		if err != nil {
+			return total, err
+		}
+		now := time.Now()
+		err = d.db.Transaction(func(tx *gorm.DB) error {
+			ids := make([]uint, len(batch))
+			for i := range batch {
+				ids[i] = batch[i].ID
+				changes := stripFeedback(&batch[i], fields, now)
+				if err := tx.Model(&Feedback{}).Where("id = ?", batch[i].ID).Updates(changes).Error; err != nil {
+					return err
+				}
+			}
+			return detachStrippedFeedback(tx, ids, fields)
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int64(len(batch))
+		if len(batch) < retentionBatch {
+			return total, nil
+		}
+	}
+}
+
+// PurgeDeletedFeedback removes feedback soft deleted before the cutoff for
+// good, together with its notes, notifications and dedup keys.
+func (d *DatabaseHandler) PurgeDeletedFeedback(cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		var ids []uint
+		err := d.db.Unscoped().Model(&Feedback{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Limit(retentionBatch).Pluck("id", &ids).Error
+		if err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		err = d.db.Transaction(func(tx *gorm.DB) error {
+			return hardDeleteFeedback(tx, ids)
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int64(len(ids))
+		if len(ids) < retentionBatch {
+			return total, nil
+		}
+	}
+}
+
+// detachStrippedFeedback takes stripped feedback out of the issues and error
+// groups built from the fields it lost.
+func detachStrippedFeedback(tx *gorm.DB, ids []uint, fields []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if slices.Contains(fields, stripAdditionalInformation) {
+		if err := detachFromIssues(tx, ids); err != nil {
+			return err
+		}
+	}
+	if slices.Contains(fields, stripErrorLog) {
+		return detachFromErrorGroups(tx, ids)
+	}
+	return nil
+}
+
+// hardDeleteFeedback removes feedback rows and everything referring to them,
+// and takes them out of their issues and error groups.
+func hardDeleteFeedback(tx *gorm.DB, ids []uint) error {
+	if err := detachFromIssues(tx, ids); err != nil {
+		return err
+	}
+	if err := detachFromErrorGroups(tx, ids); err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&FeedbackNote{}, &OutboxMessage{}, &FeedbackDedupKey{}} {
+		if err := tx.Unscoped().Where("feedback_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&Feedback{}).Error
+}
+
+// RetentionJob applies the projects' retention policies.
+type RetentionJob struct {
+	db         RetentionStore
+	projects   *ProjectRegistry
+	interval   time.Duration
+	purgeAfter time.Duration
+}
+
+func NewRetentionJob(db RetentionStore, projects *ProjectRegistry, cfg *Config) *RetentionJob {
+	return &RetentionJob{
+		db:         db,
+		projects:   projects,
+		interval:   cfg.Retention.Interval,
+		purgeAfter: cfg.Retention.PurgeAfter,
+	}
+}
+
+// Run applies the policies once right away and then every interval until
+// ctx is done. Runs on several replicas at once are harmless, every step
+// only touches rows that still need it.
+func (j *RetentionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		if err := j.RunOnce(time.Now()); err != nil {
+			slog.Error("retention run failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies every project's policy and purges expired soft deletes.
+func (j *RetentionJob) RunOnce(now time.Time) error {
+	var deleted, stripped int64
+	for _, p := range j.projects.All() {
+		r := p.Retention
+		if r.StripAfterDays > 0 {
+			n, err := j.db.StripFeedbackBefore(p.Slug, now.AddDate(0, 0, -r.StripAfterDays), r.stripFields())
+			retentionRowsCounter.WithLabelValues(p.Slug, "stripped").Add(float64(n))
+			stripped += n
+			if err != nil {
+				return fmt.Errorf("stripping %s feedback: %w", p.Slug, err)
+			}
+		}
+		if r.DeleteAfterDays > 0 {
+			n, err := j.db.SoftDeleteFeedbackBefore(p.Slug, now.AddDate(0, 0, -r.DeleteAfterDays))
+			retentionRowsCounter.WithLabelValues(p.Slug, "deleted").Add(float64(n))
+			deleted += n
+			if err != nil {
+				return fmt.Errorf("deleting %s feedback: %w", p.Slug, err)
+			}
+		}
+	}
+
+	purged, err := j.db.PurgeDeletedFeedback(now.Add(-j.purgeAfter))
+	retentionPurgedCounter.Add(float64(purged))
+	if err != nil {
+		return fmt.Errorf("purging deleted feedback: %w", err)
+	}
+
+	retentionLastRunRows.WithLabelValues("deleted").Set(float64(deleted))
+	retentionLastRunRows.WithLabelValues("stripped").Set(float64(stripped))
+	retentionLastRunRows.WithLabelValues("purged").Set(float64(purged))
+	retentionLastRun.Set(float64(now.Unix()))
+	if deleted+stripped+purged > 0 {
+		slog.Info("retention run finished", "deleted", deleted, "stripped", stripped, "purged", purged)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripFeedback(t *testing.T) {
+	raw := `{"rating":2,"href":"https://sky.coflnet.com/","errorLog":["boom"],"additionalInformation":"my name is bob"}`
+	f := &Feedback{User: "bob", Context: "ctx", AdditionalInformations: "my name is bob", Feedback: raw, Payload: JSONB(raw)}
+	now := time.Now()
+
+	changes := stripFeedback(f, RetentionPolicy{}.stripFields(), now)
+	if f.User != "" || changes["user"] != "" {
+		t.Error("user not stripped")
+	}
+	if f.Context != "ctx" || f.AdditionalInformations == "" {
+		t.Error("fields outside the default strip list must stay")
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(f.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := payload["errorLog"]; ok {
+		t.Error("errorLog still in payload")
+	}
+	if payload["rating"] != 2.0 || payload["href"] == nil {
+		t.Error("aggregate fields must be kept")
+	}
+	if f.Feedback != string(f.Payload) {
+		t.Error("raw feedback must be rewritten too")
+	}
+	if f.StrippedAt == nil || changes["stripped_at"] != now {
+		t.Error("strip not recorded")
+	}
+
+	changes = stripFeedback(f, []string{stripAdditionalInformation}, now)
+	if f.AdditionalInformations != "" || string(f.Payload) != `{"href":"https://sky.coflnet.com/","rating":2}` {
+		t.Errorf("additionalInformation not stripped: %s", f.Payload)
+	}
+	if v, ok := changes["search_vector"].(SearchVector); !ok || strings.Contains(v.Text, "bob") {
+		t.Errorf("stripped text must leave the search index: %+v", changes["search_vector"])
+	}
+}
+
+func TestStripFeedbackUnparseable(t *testing.T) {
+	f := &Feedback{Feedback: "not json", User: "bob"}
+	changes := stripFeedback(f, []string{stripUser}, time.Now())
+	if f.Feedback != "" || changes["feedback"] != "" {
+		t.Error("unparseable raw feedback must be dropped")
+	}
+}
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	if err := (RetentionPolicy{DeleteAfterDays: 180, StripAfterDays: 30, Strip: []string{"user", "errorLog"}}).validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (RetentionPolicy{Strip: []string{"rating"}}).validate(); err == nil {
+		t.Error("stripping unknown fields must be rejected")
+	}
+	if err := (RetentionPolicy{DeleteAfterDays: -1}).validate(); err == nil {
+		t.Error("negative days must be rejected")
+	}
+
+	_, err := NewProjectRegistry([]*Project{{Slug: "sky", Retention: RetentionPolicy{Strip: []string{"timestamp"}}}})
+	if err == nil {
+		t.Error("registry must validate retention policies")
+	}
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Full-text search runs on tsvector columns, which CockroachDB supports since
+// v23.1 with inverted (GIN) indexes. CockroachDB lacks ts_headline,
+// websearch_to_tsquery and setweight, so highlighting is done here and
+// queries use plainto_tsquery.
+
+// text search configurations feedback is indexed with
+const (
+	searchEnglish = "english"
+	searchGerman  = "german"
+)
+
+// searchLanguages maps the lang query parameter to a configuration.
+var searchLanguages = map[string]string{"en": searchEnglish, "de": searchGerman}
+
+const (
+	// maxSearchTextBytes bounds the text indexed per feedback entry.
+	maxSearchTextBytes = 64 << 10
+	// maxSearchQueryLength bounds q, in characters.
+	maxSearchQueryLength = 256
+	// maxSearchHighlights is the number of snippets returned per hit.
+	maxSearchHighlights = 3
+	// searchSnippetContext is how many characters a snippet shows around the
+	// first match.
+	searchSnippetContext = 60
+)
+
+// searchStopwords are frequent words of each language. They decide the
+// language of a text and are never highlighted.
+var searchStopwords = map[string]map[string]bool{
+	searchEnglish: wordSet("the and is it to of that this for with not you on but are was have be my can when if there doesn't don't would should what please does i me"),
+	searchGerman:  wordSet("der die das und ist nicht ich es ein eine zu mit den dem auf für wenn bei aber auch kann sich wird noch wie mir mich bitte habe sind oder nur geht wurde"),
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// searchWords splits text into lower case words.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// detectSearchLanguage tells German from English text by its stop words and
+// umlauts. Anything undecided is indexed as English.
+func detectSearchLanguage(text string) string {
+	english, german := 0, 0
+	for _, w := range searchWords(text) {
+		if searchStopwords[searchEnglish][w] {
+			english++
+		}
+		if searchStopwords[searchGerman][w] || strings.ContainsAny(w, "äöüß") {
+			german++
+		}
+	}
+	if german > english {
+		return searchGerman
+	}
+	return searchEnglish
+}
+
+// SearchVector is written as to_tsvector(config, text). It is never read
+// back, the column only serves the search index. SQLite has no text search,
+// there it holds the words of the text, each with a space before and after.
+type SearchVector struct {
+	Config string
+	Text   string
+}
+
+func (SearchVector) GormDataType() string {
+	return "tsvector"
+}
+
+func (SearchVector) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if isSQLite(db) {
+		return "TEXT"
+	}
+	return "tsvector"
+}
+
+func (v SearchVector) GormValue(ctx context.Context, db *gorm.DB) clause.Expr {
+	if v.Config != searchEnglish && v.Config != searchGerman {
+		return clause.Expr{SQL: "NULL"}
+	}
+	if isSQLite(db) {
+		return clause.Expr{SQL: "?", Vars: []interface{}{" " + strings.Join(searchWords(v.Text), " ") + " "}}
+	}
+	// the configuration is one of the constants above, so inlining is safe
+	return clause.Expr{SQL: fmt.Sprintf("to_tsvector('%s', CAST(? AS TEXT))", v.Config), Vars: []interface{}{v.Text}}
+}
+
+// searchField is a piece of text feedback is searchable by, with its path in
+// the payload.
+type searchField struct {
+	Path string
+	Text string
+}
+
+// searchFields returns the additional information followed by every other
+// string in the payload, in a stable order.
+func searchFields(f *Feedback) []searchField {
+	var fields []searchField
+	if f.AdditionalInformations != "" {
+		fields = append(fields, searchField{Path: "additionalInformation", Text: f.AdditionalInformations})
+	}
+	var payload interface{}
+	if json.Unmarshal(f.Payload, &payload) != nil {
+		return fields
+	}
+
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		switch val := v.(type) {
+		case string:
+			if strings.TrimSpace(val) != "" {
+				fields = append(fields, searchField{Path: path, Text: val})
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(val))
+			for k := range val {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if path == "" && k == "additionalInformation" {
+					continue
+				}
+				walk(joinSearchPath(path, k), val[k])
+			}
+		case []interface{}:
+			for i, inner := range val {
+				walk(joinSearchPath(path, strconv.Itoa(i)), inner)
+			}
+		}
+	}
+	walk("", payload)
+	return fields
+}
+
+func joinSearchPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// indexForSearch sets the search columns from the feedback's text.
+func (f *Feedback) indexForSearch() {
+	var b strings.Builder
+	for _, field := range searchFields(f) {
+		if b.Len()+len(field.Text) >= maxSearchTextBytes {
+			rest := field.Text[:maxSearchTextBytes-b.Len()]
+			b.WriteString(strings.ToValidUTF8(rest, ""))
+			break
+		}
+		b.WriteString(field.Text)
+		b.WriteByte('\n')
+	}
+	text := b.String()
+	f.SearchLanguage = detectSearchLanguage(text)
+	f.SearchVector = SearchVector{Config: f.SearchLanguage, Text: text}
+}
+
+// SearchQuery is a parsed GET /api/feedback/search query.
+type SearchQuery struct {
+	Text string
+	// Configs are the text search configurations to search in.
+	Configs []string
+	// terms are the words to highlight.
+	terms []string
+}
+
+func parseSearchQuery(q, lang string) (*SearchQuery, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return nil, errors.New("q is required")
+	}
+	if utf8.RuneCountInString(q) > maxSearchQueryLength {
+		return nil, fmt.Errorf("q must not be longer than %d characters", maxSearchQueryLength)
+	}
+
+	s := &SearchQuery{Text: q, Configs: []string{searchEnglish, searchGerman}}
+	if lang != "" {
+		config, ok := searchLanguages[lang]
+		if !ok {
+			return nil, fmt.Errorf("unknown lang %q, use en or de", lang)
+		}
+		s.Configs = []string{config}
+	}
+
+	seen := make(map[string]bool)
+	for _, w := range searchWords(q) {
+		if seen[w] || searchStopwords[searchEnglish][w] || searchStopwords[searchGerman][w] {
+			continue
+		}
+		seen[w] = true
+		s.terms = append(s.terms, w)
+	}
+	if len(s.terms) == 0 {
+		return nil, errors.New("q has no searchable words")
+	}
+	return s, nil
+}
+
+// tsqueries returns one plainto_tsquery per configuration.
+func (s *SearchQuery) tsqueries() ([]string, []interface{}) {
+	exprs := make([]string, len(s.Configs))
+	args := make([]interface{}, len(s.Configs))
+	for i, config := range s.Configs {
+		exprs[i] = fmt.Sprintf("plainto_tsquery('%s', CAST(? AS TEXT))", config)
+		args[i] = s.Text
+	}
+	return exprs, args
+}
+
+// matchExpr matches feedback containing all words of the query.
+func (s *SearchQuery) matchExpr() (string, []interface{}) {
+	queries, args := s.tsqueries()
+	for i, q := range queries {
+		queries[i] = "search_vector @@ " + q
+	}
+	return "(" + strings.Join(queries, " OR ") + ")", args
+}
+
+// rankExpr ranks feedback by how well it matches, as FLOAT8 so the rank
+// survives the round trip through a cursor.
+func (s *SearchQuery) rankExpr() (string, []interface{}) {
+	queries, args := s.tsqueries()
+	for i, q := range queries {
+		queries[i] = "ts_rank(search_vector, " + q + ")"
+	}
+	rank := queries[0]
+	if len(queries) > 1 {
+		rank = "GREATEST(" + strings.Join(queries, ", ") + ")"
+	}
+	return "CAST(" + rank + " AS FLOAT8)", args
+}
+
+// sqliteMatchExpr matches feedback containing a word that matches each
+// term, by the same prefix as searchTermMatches.
+func (s *SearchQuery) sqliteMatchExpr() (string, []interface{}) {
+	conds := make([]string, len(s.terms))
+	args := make([]interface{}, len(s.terms))
+	for i, term := range s.terms {
+		// words have neither % nor _, see searchWords
+		conds[i] = "search_vector LIKE ?"
+		args[i] = "% " + searchTermPrefix(term) + "%"
+	}
+	return "(" + strings.Join(conds, " AND ") + ")", args
+}
+
+// searchCursor points at the last hit of the previous page. Hits are ordered
+// by (rank, id) descending.
+type searchCursor struct {
+	Rank float64
+	ID   uint
+}
+
+func (c searchCursor) encode() string {
+	raw := strconv.FormatFloat(c.Rank, 'g', -1, 64) + ":" + strconv.FormatUint(uint64(c.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSearchCursor(s string) (*searchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	rank, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+	r, err := strconv.ParseFloat(rank, 64)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	return &searchCursor{Rank: r, ID: uint(n)}, nil
+}
+
+// SearchHighlight is a snippet of a matching field. Snippet is HTML: the text
+// is escaped and the matches are wrapped in <mark>.
+type SearchHighlight struct {
+	Field   string `json:"field"`
+	Snippet string `json:"snippet"`
+}
+
+// FeedbackSearchHit is a search result.
+type FeedbackSearchHit struct {
+	Feedback   Feedback          `json:"feedback"`
+	Rank       float64           `json:"rank"`
+	Highlights []SearchHighlight `json:"highlights"`
+}
+
+// FeedbackSearchPage is one page of search results, best match first.
+type FeedbackSearchPage struct {
+	Items      []FeedbackSearchHit `json:"items"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+type searchRow struct {
+	Feedback
+	SearchRank float64
+}
+
+// SearchFeedback returns one page of feedback matching the query and the
+// filter, best match first.
+func (d *DatabaseHandler) SearchFeedback(filter *FeedbackFilter, s *SearchQuery, cursor *searchCursor) (*FeedbackSearchPage, error) {
+	rank, rankArgs := s.rankExpr()
+	match, matchArgs := s.matchExpr()
+	if isSQLite(d.db) {
+		// without ranking the newest hits come first
+		rank, rankArgs = "CAST(0 AS REAL)", nil
+		match, matchArgs = s.sqliteMatchExpr()
+	}
+
+	q := filter.apply(d.db.Model(&Feedback{})).
+		Select("feedbacks.*, "+rank+" AS search_rank", rankArgs...).
+		Where(match, matchArgs...)
+	if len(s.Configs) == 1 {
+		q = q.Where("search_language = ?", s.Configs[0])
+	}
+	if cursor != nil {
+		args := append(append([]interface{}{}, rankArgs...), cursor.Rank, cursor.ID)
+		q = q.Where("("+rank+", feedbacks.id) < (?, ?)", args...)
+	}
+
+	// fetch one extra row to know whether another page follows
+	var rows []searchRow
+	res := q.Order("search_rank DESC, feedbacks.id DESC").Limit(filter.Limit + 1).Find(&rows)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	page := &FeedbackSearchPage{Items: make([]FeedbackSearchHit, 0, len(rows))}
+	if len(rows) > filter.Limit {
+		rows = rows[:filter.Limit]
+		last := rows[len(rows)-1]
+		page.NextCursor = searchCursor{Rank: last.SearchRank, ID: last.ID}.encode()
+	}
+	for i := range rows {
+		page.Items = append(page.Items, FeedbackSearchHit{
+			Feedback:   rows[i].Feedback,
+			Rank:       rows[i].SearchRank,
+			Highlights: highlightFeedback(&rows[i].Feedback, s.terms),
+		})
+	}
+	return page, nil
+}
+
+// searchTermMatches tells whether a word of the text matches a query term.
+// The database matches stems, which aren't available here, so a shared
+// prefix of the term without a typical suffix counts as a match, e.g.
+// "flipping" for "flipper".
+func searchTermMatches(word, term string) bool {
+	return strings.HasPrefix(word, searchTermPrefix(term))
+}
+
+// searchTermPrefix is the part of the term a matching word starts with.
+func searchTermPrefix(term string) string {
+	n := utf8.RuneCountInString(term)
+	if n > 4 {
+		n = max(4, n-3)
+	}
+	return string([]rune(term)[:n])
+}
+
+// highlightFeedback returns snippets of the first fields matching a term.
+func highlightFeedback(f *Feedback, terms []string) []SearchHighlight {
+	highlights := []SearchHighlight{}
+	for _, field := range searchFields(f) {
+		if snippet, ok := highlightText(field.Text, terms); ok {
+			highlights = append(highlights, SearchHighlight{Field: field.Path, Snippet: snippet})
+			if len(highlights) == maxSearchHighlights {
+				break
+			}
+		}
+	}
+	return highlights
+}
+
+// highlightText cuts a snippet around the first match out of text and marks
+// all matches in it.
+func highlightText(text string, terms []string) (string, bool) {
+	runes := []rune(text)
+	type span struct{ start, end int }
+	var matches []span
+	for i := 0; i < len(runes); {
+		if !isSearchWordRune(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && isSearchWordRune(runes[j]) {
+			j++
+		}
+		word := strings.ToLower(string(runes[i:j]))
+		for _, term := range terms {
+			if searchTermMatches(word, term) {
+				matches = append(matches, span{i, j})
+				break
+			}
+		}
+		i = j
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	start := max(0, matches[0].start-searchSnippetContext)
+	end := min(len(runes), matches[0].end+searchSnippetContext)
+	// don't cut words in half
+	for start > 0 && isSearchWordRune(runes[start-1]) && start < matches[0].start {
+		start++
+	}
+	for end < len(runes) && isSearchWordRune(runes[end]) && end > matches[0].end {
+		end--
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, m := range matches {
+		if m.start < start || m.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:m.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[m.start:m.end])))
+		b.WriteString("</mark>")
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return strings.TrimSpace(b.String()), true
+}
+
+func isSearchWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\''
+}
+
+// backfillSearch indexes feedback stored before search existed, see
+// migrationBackfills.
+func backfillSearch(ctx context.Context, db *gorm.DB) error {
+	total := 0
+	for {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		var rows []Feedback
+		res := db.Unscoped().Where("search_vector IS NULL").Order("id").Limit(retentionBatch).Find(&rows)
+		if res.Error != nil {
+			return res.Error
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for i := range rows {
+				rows[i].indexForSearch()
+				err := tx.Unscoped().Model(&Feedback{}).Where("id = ?", rows[i].ID).UpdateColumns(map[string]interface{}{
+					"search_language": rows[i].SearchLanguage,
+					"search_vector":   rows[i].SearchVector,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		total += len(rows)
+		if len(rows) < retentionBatch {
+			break
+		}
+	}
+	if total > 0 {
+		slog.Info("indexed feedback for search", "rows", total)
+	}
+	return nil
+}
+
+func (h *ApiHandler) searchFeedbackRequest(c *fiber.Ctx) error {
+	s, err := parseSearchQuery(c.Query("q"), c.Query("lang"))
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	filter, err := parseFeedbackConditions(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	var cursor *searchCursor
+	if v := c.Query("cursor"); v != "" {
+		if cursor, err = decodeSearchCursor(v); err != nil {
+			return fiber.NewError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	page, err := h.store.SearchFeedback(filter, s, cursor)
+	if err != nil {
+		slog.Error("could not search feedback", "err", err)
+		errorsCounter.WithLabelValues(h.projects.metricLabel(filter.Project)).Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not search feedback")
+	}
+	return c.JSON(page)
+}
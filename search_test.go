@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestDetectSearchLanguage(t *testing.T) {
+	for text, want := range map[string]string{
+		"The bazaar flipper doesn't show the right prices":          searchEnglish,
+		"Der Bazaar Flipper zeigt die falschen Preise, bitte fixen": searchGerman,
+		"Übersicht lädt nicht":                                      searchGerman,
+		"404":                                                       searchEnglish,
+	} {
+		if got := detectSearchLanguage(text); got != want {
+			t.Errorf("%q: got %s, want %s", text, got, want)
+		}
+	}
+}
+
+func TestSearchFields(t *testing.T) {
+	f := &Feedback{
+		AdditionalInformations: "flipper broken",
+		Payload:                JSONB(`{"additionalInformation":"flipper broken","rating":1,"href":"/flipper","errorLog":[{"message":"timeout"}]}`),
+	}
+	var got []string
+	for _, field := range searchFields(f) {
+		got = append(got, field.Path+"="+field.Text)
+	}
+	want := "additionalInformation=flipper broken,errorLog.0.message=timeout,href=/flipper"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	f.indexForSearch()
+	if f.SearchLanguage != searchEnglish || !strings.Contains(f.SearchVector.Text, "timeout") {
+		t.Errorf("unexpected search columns %q %+v", f.SearchLanguage, f.SearchVector)
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	s, err := parseSearchQuery("  the Bazaar flipper ", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Configs) != 2 || strings.Join(s.terms, ",") != "bazaar,flipper" {
+		t.Errorf("unexpected query %+v", s)
+	}
+	s, err = parseSearchQuery("flipper", "de")
+	if err != nil || len(s.Configs) != 1 || s.Configs[0] != searchGerman {
+		t.Errorf("lang=de not applied: %+v %v", s, err)
+	}
+	for _, tc := range []struct{ q, lang string }{
+		{"", ""},
+		{"the and", ""},
+		{"flipper", "fr"},
+		{strings.Repeat("a", maxSearchQueryLength+1), ""},
+	} {
+		if _, err := parseSearchQuery(tc.q, tc.lang); err == nil {
+			t.Errorf("expected %q/%q to be rejected", tc.q, tc.lang)
+		}
+	}
+}
+
+func TestSearchCursorRoundTrip(t *testing.T) {
+	in := searchCursor{Rank: 0.0607927106320858, ID: 42}
+	out, err := decodeSearchCursor(in.encode())
+	if err != nil || *out != in {
+		t.Fatalf("cursor changed on round trip: %+v -> %+v (%v)", in, out, err)
+	}
+	if _, err := decodeSearchCursor(feedbackCursor{ID: 1}.encode() + "x"); err == nil {
+		t.Error("expected malformed cursor to be rejected")
+	}
+}
+
+func TestHighlightText(t *testing.T) {
+	got, ok := highlightText("The <b>bazaar</b> flipping is broken", []string{"flipper", "bazaar"})
+	want := "The &lt;b&gt;<mark>bazaar</mark>&lt;/b&gt; <mark>flipping</mark> is broken"
+	if !ok || got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	long := strings.Repeat("lorem ipsum ", 20) + "flipper" + strings.Repeat(" dolor sit", 20)
+	got, _ = highlightText(long, []string{"flipper"})
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") || !strings.Contains(got, "<mark>flipper</mark>") {
+		t.Errorf("snippet not cut around the match: %q", got)
+	}
+	if strings.Contains(got, "…m ") || strings.Contains(got, " d…") {
+		t.Errorf("snippet cuts words: %q", got)
+	}
+
+	if _, ok := highlightText("nothing here", []string{"flipper"}); ok {
+		t.Error("expected no highlight")
+	}
+}
+
+func TestSearchSQL(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &Feedback{AdditionalInformations: "Flipper kaputt", Payload: JSONB(`{"rating":1}`)}
+	f.indexForSearch()
+	stmt := db.Create(f).Statement
+	if !strings.Contains(stmt.SQL.String(), "to_tsvector('english', CAST($") {
+		t.Errorf("search vector not written: %s", stmt.SQL.String())
+	}
+
+	s, err := parseSearchQuery("flipper", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rank, rankArgs := s.rankExpr()
+	match, matchArgs := s.matchExpr()
+	stmt = (&FeedbackFilter{Project: "sky"}).apply(db.Model(&Feedback{})).
+		Select("feedbacks.*, "+rank+" AS search_rank", rankArgs...).
+		Where(match, matchArgs...).
+		Find(&[]searchRow{}).Statement
+	sql := stmt.SQL.String()
+	for _, part := range []string{
+		"GREATEST(ts_rank(search_vector, plainto_tsquery('english', CAST($1 AS TEXT)))",
+		"search_vector @@ plainto_tsquery('german', CAST($",
+		`"feedbacks"."deleted_at" IS NULL`,
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("missing %q in %s", part, sql)
+		}
+	}
+}
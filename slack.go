@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SlackNotifier posts to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, n *Notification) error {
+	if s.WebhookURL == "" {
+		return fmt.Errorf("no slack webhook configured")
+	}
+
+	// Slack's mrkdwn marks bold with a single asterisk and needs &, < and >
+	// escaped, everything else including code fences carries over.
+	text := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(n.Text)
+	text = strings.ReplaceAll(text, "**", "*")
+
+	return postJSON(ctx, http.MethodPost, s.WebhookURL, nil, map[string]interface{}{
+		"text": text,
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPNotifier sends the notification as a plain text email. The connection
+// is upgraded with STARTTLS whenever the server offers it.
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTPNotifier) Notify(ctx context.Context, n *Notification) error {
+	port := s.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	msg := s.message(n, time.Now())
+
+	// net/smtp has no context support, so run it aside and give up on the
+	// caller's deadline.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, s.From, s.To, msg)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("sending mail: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// message renders the RFC 5322 email.
+func (s *SMTPNotifier) message(n *Notification, now time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	if n.Contact != nil {
+		// answering the mail should reach the person who wrote in
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", n.Contact.Email)
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	body := strings.ReplaceAll(n.plainText(), "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes()
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"os"
 	"regexp"
+	"slices"
 	"strings"
 )
 
@@ -53,21 +54,9 @@ var urlRegex = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+|\b[a-z0-9-]+\.(?
 // contact traffic but are common in the localized "what is your price" spam.
 var nonLatinRegex = regexp.MustCompile(`[\p{Han}\p{Cyrillic}\p{Arabic}\p{Hangul}\p{Hiragana}\p{Katakana}]`)
 
-// extraBlocklist lets operators add keywords at runtime via the
-// CONTACT_BLOCKLIST env var (comma separated) without a redeploy.
-func extraBlocklist() []string {
-	v := strings.TrimSpace(os.Getenv("CONTACT_BLOCKLIST"))
-	if v == "" {
-		return nil
-	}
-	var out []string
-	for _, p := range strings.Split(v, ",") {
-		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
-			out = append(out, p)
-		}
-	}
-	return out
-}
+// extraBlocklist lets operators add lowercase keywords via
+// CONTACT_BLOCKLIST without a new build. Set from the config.
+var extraBlocklist []string
 
 // spamScore rates a submission. It returns the accumulated score and a short
 // human-readable reason for logging. Callers reject at spamRejectThreshold.
@@ -90,7 +79,7 @@ func spamScore(name, email, message string) (int, string) {
 	}
 
 	// Instant blocks: spam phrases (default + operator-supplied).
-	phrases := append(spamPhrases, extraBlocklist()...)
+	phrases := append(spamPhrases, extraBlocklist...)
 	for _, p := range phrases {
 		if strings.Contains(haystack, p) {
 			add(spamRejectThreshold, "phrase:"+p)
@@ -141,3 +130,32 @@ func runTogetherName(name string) bool {
 	}
 	return len([]rune(name)) > 20
 }
+
+// feedbackSpamIgnoredKeys are payload fields the client fills in itself; they
+// would only trip the link heuristics.
+var feedbackSpamIgnoredKeys = []string{"href", "timestamp", "subscriptionStatus", "errorLog"}
+
+// feedbackSpamText collects the free text a user typed into a feedback form:
+// every top level string of the payload except the ones set by the client.
+func feedbackSpamText(f *Feedback) string {
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(f.Feedback), &payload); err != nil {
+		return f.AdditionalInformations
+	}
+
+	keys := make([]string, 0, len(payload))
+	for k := range payload {
+		if !slices.Contains(feedbackSpamIgnoredKeys, k) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	var parts []string
+	for _, k := range keys {
+		if s, ok := payload[k].(string); ok && strings.TrimSpace(s) != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
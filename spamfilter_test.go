@@ -83,3 +83,30 @@ func TestSignatureBinding(t *testing.T) {
 		t.Error("signature did not change with challenge")
 	}
 }
+
+func TestFeedbackSpamClassification(t *testing.T) {
+	spam := &Feedback{Feedback: `{"additionalInformation":"Attract keyword-targeted visitors https://cutt.ly/Xt4CHP2t","href":"https://sky.coflnet.com/"}`}
+	if !(SpamPolicy{}).classify(spam) {
+		t.Errorf("spam feedback not classified (score %d, %q)", spam.SpamScore, spam.SpamReasons)
+	}
+	if !spam.Spam || spam.SpamReasons == "" {
+		t.Error("verdict not recorded on the feedback")
+	}
+
+	legit := &Feedback{Feedback: `{"additionalInformation":"The flipper shows wrong prices for enchanted books","href":"https://sky.coflnet.com/flipper","rating":2}`}
+	if (SpamPolicy{}).classify(legit) {
+		t.Errorf("legit feedback classified as spam (score %d, %q)", legit.SpamScore, legit.SpamReasons)
+	}
+
+	// one link is fine by default but a strict project can reject it
+	link := &Feedback{Feedback: `{"additionalInformation":"see the screenshot at https://imgur.com/abc for details on this problem"}`}
+	if (SpamPolicy{}).classify(link) {
+		t.Errorf("single link should pass the default threshold (score %d)", link.SpamScore)
+	}
+	if !(SpamPolicy{Threshold: 50}).classify(link) {
+		t.Errorf("single link should trip a threshold of 50 (score %d)", link.SpamScore)
+	}
+	if (SpamPolicy{Disabled: true}).classify(spam) {
+		t.Error("disabled policy must not classify")
+	}
+}
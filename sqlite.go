@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// Production runs on CockroachDB or Postgres. SQLite, on disk or in memory,
+// runs the service locally and the tests without a database server. The
+// queries are written for Postgres; the few using its JSON, date or text
+// search functions have an SQLite variant, picked by isSQLite.
+
+// database drivers, see DatabaseConfig
+const (
+	driverPostgres = "postgres"
+	driverSQLite   = "sqlite"
+	// driverMemory is a private in-memory SQLite database, gone on exit.
+	driverMemory = "memory"
+)
+
+// sqliteUTCDriver is the name the SQLite driver is registered under with
+// time arguments converted to UTC. SQLite stores times as text in the zone
+// they come in and compares them as text, so a mix of zones would sort
+// wrong.
+const sqliteUTCDriver = "sqlite-utc"
+
+func init() {
+	sql.Register(sqliteUTCDriver, utcDriver{&gosqlite.Driver{}})
+}
+
+type utcDriver struct {
+	driver.Driver
+}
+
+func (d utcDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return utcConn{c.(sqliteConn)}, nil
+}
+
+// sqliteConn is what the SQLite driver's connections implement and
+// database/sql looks for.
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+}
+
+type utcConn struct {
+	sqliteConn
+}
+
+// CheckNamedValue converts arguments like database/sql would and then
+// times to UTC.
+func (utcConn) CheckNamedValue(nv *driver.NamedValue) error {
+	v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := v.(time.Time); ok {
+		v = t.UTC()
+	}
+	nv.Value = v
+	return nil
+}
+
+// sqliteDialector opens the SQLite database at path, or a new in-memory
+// database if path is empty. The in-memory database lives as long as the
+// connection, Connect keeps a single one open.
+func sqliteDialector(path string) gorm.Dialector {
+	dsn := ":memory:"
+	if path != "" {
+		dsn = path
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	// the busy timeout lets a subcommand wait for the running server
+	dsn += sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	return &sqlite.Dialector{DriverName: sqliteUTCDriver, DSN: dsn}
+}
+
+func isSQLite(db *gorm.DB) bool {
+	return db.Dialector.Name() == "sqlite"
+}
+
+// sqliteJSONPath is the SQLite JSON path of a payload path. The segments
+// were validated against payloadPathSegment, so inlining them is safe.
+func sqliteJSONPath(path []string) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, seg := range path {
+		b.WriteString(`."` + seg + `"`)
+	}
+	return b.String()
+}
+
+// sqliteJSONText selects the path as text like ->> does on Postgres:
+// booleans become 'true' and 'false', numbers their decimal text.
+func sqliteJSONText(path []string) string {
+	return fmt.Sprintf(`(CASE json_type(payload, '%[1]s') WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(payload, '%[1]s') AS TEXT) END)`,
+		sqliteJSONPath(path))
+}
+
+// sqliteJSONNumber selects the path if it is a number, NULL otherwise.
+func sqliteJSONNumber(path []string) string {
+	return fmt.Sprintf(`(CASE WHEN json_type(payload, '%[1]s') IN ('integer', 'real') THEN json_extract(payload, '%[1]s') END)`,
+		sqliteJSONPath(path))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func connectSQLite(t *testing.T, path string) *DatabaseHandler {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Database.Driver = driverSQLite
+	cfg.Database.URL = path
+	cfg.Database.Migrate = migrateUp
+	db := NewDatabaseHandler(cfg)
+	if err := db.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLiteFileKeepsData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.db")
+	db := connectSQLite(t, path)
+	if _, err := db.CreateAPIKey(&APIKey{Name: "local", Role: RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = connectSQLite(t, path)
+	defer db.Close()
+	if n, err := db.CountActiveAdminKeys(); err != nil || n != 1 {
+		t.Fatalf("admin keys after reconnecting = %d, %v", n, err)
+	}
+}
+
+func TestSQLiteComparesTimesInUTC(t *testing.T) {
+	db := connectSQLite(t, filepath.Join(t.TempDir(), "feedback.db"))
+	defer db.Close()
+
+	// 10:00 UTC is 19:00 in Tokyo; as text in their own zones the Tokyo
+	// time would sort after 12:00 UTC
+	tokyo := time.FixedZone("JST", 9*60*60)
+	created := time.Date(2024, 5, 1, 19, 0, 0, 0, tokyo)
+	f := &Feedback{Project: "sky", Payload: JSONB(`{}`), TriageState: TriageState{Status: StatusNew}}
+	f.CreatedAt = created
+	if err := db.db.Create(f).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := db.ListFeedback(&FeedbackFilter{Limit: 10, CreatedTo: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || !page.Items[0].CreatedAt.Equal(created) {
+		t.Fatalf("feedback created before 12:00 UTC = %+v", page.Items)
+	}
+}
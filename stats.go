@@ -0,0 +1,463 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// statsDefaultRange is the period covered when from isn't given.
+	statsDefaultRange = 30 * 24 * time.Hour
+	// maxStatsBuckets bounds the volume series, an hourly series over a
+	// year is not something anyone graphs.
+	maxStatsBuckets   = 2000
+	statsPagesDefault = 10
+	statsPagesMax     = 100
+)
+
+// sql expressions over the payload; rating and somethingBroke have
+// expression indexes, see migrations/postgres
+const (
+	// ratingExpr is the numeric rating, NULL when the client sent none or
+	// something that isn't a number.
+	ratingExpr = `(CASE WHEN jsonb_typeof(payload->'rating') = 'number' THEN CAST(payload->>'rating' AS FLOAT8) END)`
+	brokeExpr  = `(payload->>'somethingBroke' = 'true')`
+	// hrefExpr is the page without query string and fragment, the
+	// separators are bound as parameters.
+	hrefExpr = `split_part(split_part(payload->>'href', ?, 1), ?, 1)`
+)
+
+// statsExprs are the payload expressions of the statistics in the dialect
+// of a database.
+type statsExprs struct {
+	rating, broke, href, rawHref string
+	hrefArgs                     []interface{}
+}
+
+func statsExprsOf(db *gorm.DB) statsExprs {
+	if !isSQLite(db) {
+		return statsExprs{rating: ratingExpr, broke: brokeExpr, href: hrefExpr, rawHref: "payload->>'href'", hrefArgs: []interface{}{"?", "#"}}
+	}
+	raw := sqliteJSONText([]string{"href"})
+	// cut at ? and # by their code points, a ? in a literal would be taken
+	// for a parameter
+	cut := func(s, sep string) string {
+		return fmt.Sprintf("substr(%[1]s, 1, instr(%[1]s || %[2]s, %[2]s) - 1)", s, sep)
+	}
+	return statsExprs{
+		rating:  sqliteJSONNumber([]string{"rating"}),
+		broke:   "(" + sqliteJSONText([]string{"somethingBroke"}) + " = 'true')",
+		href:    cut(cut(raw, "char(63)"), "char(35)"),
+		rawHref: raw,
+	}
+}
+
+// volume bucket sizes, named like the units of date_trunc
+var statsBuckets = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+// StatsQuery is the feedback the statistics are computed over.
+type StatsQuery struct {
+	*FeedbackFilter
+	From   time.Time
+	To     time.Time
+	Bucket string
+}
+
+// apply restricts q to the feedback of the query.
+func (s *StatsQuery) apply(q *gorm.DB) *gorm.DB {
+	return s.FeedbackFilter.apply(q).Where("created_at >= ? AND created_at < ?", s.From, s.To)
+}
+
+// StatsSummary describes the feedback of a period.
+type StatsSummary struct {
+	From                time.Time   `json:"from"`
+	To                  time.Time   `json:"to"`
+	Total               int64       `json:"total"`
+	SomethingBroke      int64       `json:"somethingBroke"`
+	SomethingBrokeShare float64     `json:"somethingBrokeShare"`
+	Ratings             RatingStats `json:"ratings"`
+	// NPS is only set for ratings on a 0-10 scale.
+	NPS *NPSStats `json:"nps,omitempty"`
+}
+
+// RatingStats aggregates the numeric ratings. Scale is 10 when a rating
+// above 5 occurs, 5 otherwise and 0 without ratings.
+type RatingStats struct {
+	Count        int64         `json:"count"`
+	Average      *float64      `json:"average"`
+	Scale        int           `json:"scale"`
+	Distribution []RatingCount `json:"distribution"`
+}
+
+type RatingCount struct {
+	Rating float64 `json:"rating"`
+	Count  int64   `json:"count"`
+}
+
+// NPSStats is the net promoter score: the percentage of promoters (9-10)
+// minus the percentage of detractors (0-6).
+type NPSStats struct {
+	Responses  int64   `json:"responses"`
+	Promoters  int64   `json:"promoters"`
+	Passives   int64   `json:"passives"`
+	Detractors int64   `json:"detractors"`
+	Score      float64 `json:"score"`
+}
+
+// VolumeBucket is the feedback submitted within one bucket.
+type VolumeBucket struct {
+	Bucket         time.Time `json:"time"`
+	Count          int64     `json:"count"`
+	SomethingBroke int64     `json:"somethingBroke"`
+	AverageRating  *float64  `json:"averageRating"`
+}
+
+// PageStats counts the negative feedback about one page.
+type PageStats struct {
+	Href           string `json:"href"`
+	Negative       int64  `json:"negative"`
+	SomethingBroke int64  `json:"somethingBroke"`
+}
+
+// ratingScale guesses the scale from the highest rating given.
+func ratingScale(max float64) int {
+	if max > 5 {
+		return 10
+	}
+	return 5
+}
+
+// negativeRating is the highest rating that counts as negative feedback,
+// matching the orange Discord embed on a 5 point scale and the NPS
+// detractors on a 10 point scale.
+func negativeRating(scale int) float64 {
+	if scale == 10 {
+		return 6
+	}
+	return 2
+}
+
+func npsOf(distribution []RatingCount) *NPSStats {
+	nps := &NPSStats{}
+	for _, d := range distribution {
+		if d.Rating < 0 || d.Rating > 10 {
+			continue
+		}
+		nps.Responses += d.Count
+		switch {
+		case d.Rating >= 9:
+			nps.Promoters += d.Count
+		case d.Rating >= 7:
+			nps.Passives += d.Count
+		default:
+			nps.Detractors += d.Count
+		}
+	}
+	if nps.Responses > 0 {
+		nps.Score = float64(nps.Promoters-nps.Detractors) * 100 / float64(nps.Responses)
+	}
+	return nps
+}
+
+type statsTotals struct {
+	Total          int64
+	SomethingBroke int64
+	Ratings        int64
+	Average        *float64
+}
+
+func (s *StatsQuery) totals(db *gorm.DB) *gorm.DB {
+	e := statsExprsOf(db)
+	return s.apply(db.Model(&Feedback{})).
+		Select("COUNT(*) AS total, " +
+			"COALESCE(SUM(CASE WHEN " + e.broke + " THEN 1 ELSE 0 END), 0) AS something_broke, " +
+			"COUNT(" + e.rating + ") AS ratings, " +
+			"AVG(" + e.rating + ") AS average")
+}
+
+func (s *StatsQuery) ratingDistribution(db *gorm.DB) *gorm.DB {
+	e := statsExprsOf(db)
+	return s.apply(db.Model(&Feedback{})).
+		Select(e.rating + " AS rating, COUNT(*) AS count").
+		Where(e.rating + " IS NOT NULL").
+		Group("rating").Order("rating")
+}
+
+// sqliteBuckets truncate created_at like date_trunc, SQLite stores it as
+// UTC text; weeks start on Monday.
+var sqliteBuckets = map[string]string{
+	"hour": "strftime('%Y-%m-%d %H:00:00', created_at)",
+	"day":  "strftime('%Y-%m-%d 00:00:00', created_at)",
+	"week": "strftime('%Y-%m-%d 00:00:00', created_at, 'weekday 0', '-6 days')",
+}
+
+func (s *StatsQuery) volume(db *gorm.DB) *gorm.DB {
+	e := statsExprsOf(db)
+	bucket, args := "date_trunc(?, created_at AT TIME ZONE 'UTC')", []interface{}{s.Bucket}
+	if isSQLite(db) {
+		bucket, args = sqliteBuckets[s.Bucket], nil
+	}
+	return s.apply(db.Model(&Feedback{})).
+		Select(bucket+" AS bucket, "+
+			"COUNT(*) AS count, "+
+			"COALESCE(SUM(CASE WHEN "+e.broke+" THEN 1 ELSE 0 END), 0) AS something_broke, "+
+			"AVG("+e.rating+") AS average_rating", args...).
+		Group("bucket").Order("bucket")
+}
+
+// negativePages counts the feedback with somethingBroke or a rating of at
+// most threshold per page.
+func (s *StatsQuery) negativePages(db *gorm.DB, threshold float64, limit int) *gorm.DB {
+	e := statsExprsOf(db)
+	return s.apply(db.Model(&Feedback{})).
+		Select(e.href+" AS href, COUNT(*) AS negative, "+
+			"COALESCE(SUM(CASE WHEN "+e.broke+" THEN 1 ELSE 0 END), 0) AS something_broke", e.hrefArgs...).
+		Where("COALESCE("+e.rawHref+", '') <> ''").
+		Where(e.broke+" OR "+e.rating+" <= ?", threshold).
+		Group("href").Order("negative DESC, href").Limit(limit)
+}
+
+// FeedbackStatsSummary computes counts, the rating distribution and the NPS
+// of the query's feedback.
+func (d *DatabaseHandler) FeedbackStatsSummary(s *StatsQuery) (*StatsSummary, error) {
+	var totals statsTotals
+	if err := s.totals(d.db).Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	distribution := []RatingCount{}
+	if err := s.ratingDistribution(d.db).Scan(&distribution).Error; err != nil {
+		return nil, err
+	}
+
+	summary := &StatsSummary{
+		From:           s.From,
+		To:             s.To,
+		Total:          totals.Total,
+		SomethingBroke: totals.SomethingBroke,
+		Ratings: RatingStats{
+			Count:        totals.Ratings,
+			Average:      totals.Average,
+			Distribution: distribution,
+		},
+	}
+	if totals.Total > 0 {
+		summary.SomethingBrokeShare = float64(totals.SomethingBroke) / float64(totals.Total)
+	}
+	if len(distribution) > 0 {
+		summary.Ratings.Scale = ratingScale(distribution[len(distribution)-1].Rating)
+		if summary.Ratings.Scale == 10 {
+			summary.NPS = npsOf(distribution)
+		}
+	}
+	return summary, nil
+}
+
+// FeedbackVolume counts the query's feedback per bucket, oldest first.
+// Buckets without feedback are included with zero counts so graphs don't
+// interpolate over them.
+func (d *DatabaseHandler) FeedbackVolume(s *StatsQuery) ([]VolumeBucket, error) {
+	var rows []volumeRow
+	if err := s.volume(d.db).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counted := make(map[int64]VolumeBucket, len(rows))
+	for _, r := range rows {
+		counted[r.Bucket.Unix()] = VolumeBucket{Count: r.Count, SomethingBroke: r.SomethingBroke, AverageRating: r.AverageRating}
+	}
+	buckets := []VolumeBucket{}
+	for t := truncateToBucket(s.From, s.Bucket); t.Before(s.To); t = nextBucket(t, s.Bucket) {
+		b := counted[t.Unix()]
+		b.Bucket = t
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// volumeRow is a VolumeBucket as the volume query returns it.
+type volumeRow struct {
+	Bucket         bucketTime
+	Count          int64
+	SomethingBroke int64
+	AverageRating  *float64
+}
+
+// bucketTime scans the start of a bucket, which SQLite returns as text.
+type bucketTime struct {
+	time.Time
+}
+
+func (b *bucketTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		b.Time = v
+	case string:
+		t, err := time.Parse(time.DateTime, v)
+		if err != nil {
+			return err
+		}
+		b.Time = t
+	default:
+		return fmt.Errorf("cannot scan %T into a bucket time", src)
+	}
+	return nil
+}
+
+// Value makes gorm take bucketTime for a column and not a relation.
+func (b bucketTime) Value() (driver.Value, error) {
+	return b.Time, nil
+}
+
+// truncateToBucket matches date_trunc in UTC; weeks start on Monday.
+func truncateToBucket(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	switch bucket {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case "hour":
+		return t.Add(time.Hour)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// FeedbackPageStats returns the pages with the most negative feedback: a
+// rating in the lower part of the scale or somethingBroke.
+func (d *DatabaseHandler) FeedbackPageStats(s *StatsQuery, limit int) ([]PageStats, error) {
+	var max *float64
+	err := s.apply(d.db.Model(&Feedback{})).Select("MAX(" + statsExprsOf(d.db).rating + ")").Scan(&max).Error
+	if err != nil {
+		return nil, err
+	}
+	threshold := negativeRating(5)
+	if max != nil {
+		threshold = negativeRating(ratingScale(*max))
+	}
+
+	pages := []PageStats{}
+	if err := s.negativePages(d.db, threshold, limit).Scan(&pages).Error; err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// parseStatsTime reads an RFC 3339 timestamp or, as Grafana's ${__from}
+// renders it, unix milliseconds.
+func parseStatsTime(v string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseStatsQuery reads the feedback filters of the listing plus from, to
+// and bucket. Spam is left out unless the spam filter is given.
+func parseStatsQuery(c *fiber.Ctx) (*StatsQuery, error) {
+	filter, err := parseFeedbackConditions(c)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Spam == nil {
+		noSpam := false
+		filter.Spam = &noSpam
+	}
+
+	s := &StatsQuery{FeedbackFilter: filter, To: time.Now().UTC(), Bucket: c.Query("bucket", "day")}
+	if v := c.Query("to"); v != "" {
+		if s.To, err = parseStatsTime(v); err != nil {
+			return nil, errors.New("to must be an RFC 3339 timestamp or unix milliseconds")
+		}
+	}
+	s.From = s.To.Add(-statsDefaultRange)
+	if v := c.Query("from"); v != "" {
+		if s.From, err = parseStatsTime(v); err != nil {
+			return nil, errors.New("from must be an RFC 3339 timestamp or unix milliseconds")
+		}
+	}
+	if !s.From.Before(s.To) {
+		return nil, errors.New("from must be before to")
+	}
+
+	size, ok := statsBuckets[s.Bucket]
+	if !ok {
+		return nil, fmt.Errorf("unknown bucket %q, use hour, day or week", s.Bucket)
+	}
+	if s.To.Sub(s.From)/size > maxStatsBuckets {
+		return nil, fmt.Errorf("more than %d buckets, use a larger bucket or a shorter range", maxStatsBuckets)
+	}
+	return s, nil
+}
+
+func (h *ApiHandler) statsSummaryRequest(c *fiber.Ctx) error {
+	s, err := parseStatsQuery(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	summary, err := h.store.FeedbackStatsSummary(s)
+	if err != nil {
+		slog.Error("could not compute feedback stats", "err", err)
+		errorsCounter.WithLabelValues(h.projects.metricLabel(s.Project)).Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not compute stats")
+	}
+	return c.JSON(summary)
+}
+
+func (h *ApiHandler) statsVolumeRequest(c *fiber.Ctx) error {
+	s, err := parseStatsQuery(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	buckets, err := h.store.FeedbackVolume(s)
+	if err != nil {
+		slog.Error("could not compute feedback volume", "err", err)
+		errorsCounter.WithLabelValues(h.projects.metricLabel(s.Project)).Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not compute stats")
+	}
+	return c.JSON(buckets)
+}
+
+func (h *ApiHandler) statsPagesRequest(c *fiber.Ctx) error {
+	s, err := parseStatsQuery(c)
+	if err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+	limit := statsPagesDefault
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fiber.NewError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+		limit = min(n, statsPagesMax)
+	}
+	pages, err := h.store.FeedbackPageStats(s, limit)
+	if err != nil {
+		slog.Error("could not compute page stats", "err", err)
+		errorsCounter.WithLabelValues(h.projects.metricLabel(s.Project)).Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not compute stats")
+	}
+	return c.JSON(pages)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestNPS(t *testing.T) {
+	nps := npsOf([]RatingCount{{0, 1}, {6, 1}, {7, 2}, {9, 3}, {10, 3}, {11, 5}})
+	if nps.Responses != 10 || nps.Promoters != 6 || nps.Passives != 2 || nps.Detractors != 2 || nps.Score != 40 {
+		t.Errorf("unexpected nps %+v", nps)
+	}
+	if ratingScale(5) != 5 || ratingScale(7) != 10 {
+		t.Error("scale detection broken")
+	}
+	if negativeRating(5) != 2 || negativeRating(10) != 6 {
+		t.Error("negative thresholds changed")
+	}
+}
+
+func TestStatsBuckets(t *testing.T) {
+	// a Sunday evening
+	sunday := time.Date(2025, 3, 9, 22, 15, 0, 0, time.UTC)
+	for bucket, want := range map[string]time.Time{
+		"hour": time.Date(2025, 3, 9, 22, 0, 0, 0, time.UTC),
+		"day":  time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC),
+		"week": time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC),
+	} {
+		if got := truncateToBucket(sunday, bucket); !got.Equal(want) {
+			t.Errorf("%s: got %s, want %s", bucket, got, want)
+		}
+	}
+	if got := nextBucket(time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC), "week"); got.Weekday() != time.Monday {
+		t.Errorf("weeks must start on monday, got %s", got)
+	}
+}
+
+func TestParseStatsQuery(t *testing.T) {
+	var got *StatsQuery
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		s, err := parseStatsQuery(c)
+		if err != nil {
+			return fiber.NewError(400, err.Error())
+		}
+		got = s
+		return nil
+	})
+
+	for query, status := range map[string]int{
+		"/?project=sky&from=1741478400000&to=2025-03-10T00:00:00Z&bucket=hour": 200,
+		"/?bucket=minute": 400,
+		"/?from=2025-03-10T00:00:00Z&to=2025-03-09T00:00:00Z": 400,
+		"/?from=2015-01-01T00:00:00Z&bucket=hour":             400,
+	} {
+		resp, err := app.Test(httptest.NewRequest("GET", query, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d", query, status, resp.StatusCode)
+		}
+	}
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/?project=sky&from=1741478400000&to=2025-03-10T00:00:00Z&bucket=hour", nil), -1)
+	if resp.StatusCode != 200 || got.Project != "sky" || got.Bucket != "hour" ||
+		!got.From.Equal(time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC)) || got.Spam == nil || *got.Spam {
+		t.Errorf("query not parsed: %+v", got)
+	}
+}
+
+func TestStatsSQL(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &StatsQuery{
+		FeedbackFilter: &FeedbackFilter{Project: "sky", FeedbackName: "rating"},
+		From:           time.Now().Add(-24 * time.Hour),
+		To:             time.Now(),
+		Bucket:         "hour",
+	}
+
+	for name, q := range map[string]*gorm.DB{
+		"totals":       s.totals(db).Find(&[]statsTotals{}),
+		"distribution": s.ratingDistribution(db).Find(&[]RatingCount{}),
+		"volume":       s.volume(db).Find(&[]VolumeBucket{}),
+		"pages":        s.negativePages(db, 2, 10).Find(&[]PageStats{}),
+	} {
+		sql := q.Statement.SQL.String()
+		for _, part := range []string{
+			"project = $",
+			"feedback_name = $",
+			"created_at >= $",
+			`"feedbacks"."deleted_at" IS NULL`,
+		} {
+			if !strings.Contains(sql, part) {
+				t.Errorf("%s: missing %q in %s", name, part, sql)
+			}
+		}
+	}
+
+	volume := s.volume(db).Find(&[]VolumeBucket{}).Statement
+	if sql := volume.SQL.String(); !strings.Contains(sql, "date_trunc($1, created_at AT TIME ZONE 'UTC') AS bucket") ||
+		!strings.Contains(sql, `GROUP BY "bucket"`) || volume.Vars[0] != "hour" {
+		t.Errorf("unexpected volume query %s", sql)
+	}
+	pages := s.negativePages(db, 2, 10).Find(&[]PageStats{}).Statement
+	if sql := pages.SQL.String(); !strings.Contains(sql, "split_part(split_part(payload->>'href', $1, 1), $2, 1) AS href") ||
+		!strings.Contains(sql, "THEN CAST(payload->>'rating' AS FLOAT8) END) <= $") ||
+		!strings.Contains(sql, "ORDER BY negative DESC, href LIMIT $") {
+		t.Errorf("unexpected pages query %s", sql)
+	}
+}
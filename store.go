@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Store is everything the api, the background jobs and the subcommands
+// keep. DatabaseHandler implements it on Postgres and CockroachDB, and on
+// SQLite for running locally and in tests, see DatabaseDriver.
+type Store interface {
+	FeedbackStore
+	IssueStore
+	ErrorGroupStore
+	OutboxStore
+	RetentionStore
+	ContactMessageStore
+	APIKeyStore
+	AuditStore
+
+	Close() error
+}
+
+// FeedbackStore stores, finds and triages feedback.
+type FeedbackStore interface {
+	SaveFeedback(ctx context.Context, f *Feedback, notifications []OutboxMessage, errs *ErrorCapture) error
+	ListFeedback(filter *FeedbackFilter) (*FeedbackPage, error)
+	EachFeedback(filter FeedbackFilter, fn func(*Feedback) error) error
+	SearchFeedback(filter *FeedbackFilter, s *SearchQuery, cursor *searchCursor) (*FeedbackSearchPage, error)
+	GetFeedback(id uint) (*Feedback, error)
+	UpdateFeedbackTriage(id uint, update *TriageUpdate) (*Feedback, error)
+	AddFeedbackNote(feedbackID uint, author, text string) (*FeedbackNote, error)
+	ListFeedbackNotes(feedbackID uint) ([]FeedbackNote, error)
+
+	FeedbackStatsSummary(s *StatsQuery) (*StatsSummary, error)
+	FeedbackVolume(s *StatsQuery) ([]VolumeBucket, error)
+	FeedbackPageStats(s *StatsQuery, limit int) ([]PageStats, error)
+}
+
+// IssueStore reads and triages the clusters of similar feedback.
+type IssueStore interface {
+	ListIssues(filter *IssueFilter) (*IssuePage, error)
+	GetIssue(id uint) (*Issue, error)
+	UpdateIssueTriage(id uint, update *IssueUpdate) (*IssueTriageResult, error)
+}
+
+// ErrorGroupStore reads the groups of reported client errors.
+type ErrorGroupStore interface {
+	ListErrorGroups(filter *ErrorGroupFilter) (*ErrorGroupPage, error)
+	GetErrorGroup(id uint) (*ErrorGroup, error)
+	GetErrorGroupDetail(id uint) (*ErrorGroupDetail, error)
+}
+
+// OutboxStore holds the pending notifications.
+type OutboxStore interface {
+	ClaimOutboxMessages(limit int, lease time.Duration) ([]OutboxMessage, error)
+	FinishOutboxMessage(msg *OutboxMessage) error
+	ListOutboxMessages(status OutboxStatus, limit int) ([]OutboxMessage, error)
+	ReplayOutboxMessages(id uint) (int64, error)
+}
+
+// RetentionStore removes data that is past its retention period, and the
+// data of a data subject on request.
+type RetentionStore interface {
+	SoftDeleteFeedbackBefore(project string, cutoff time.Time) (int64, error)
+	StripFeedbackBefore(project string, cutoff time.Time, fields []string) (int64, error)
+	PurgeDeletedFeedback(cutoff time.Time) (int64, error)
+	PurgeExpiredDedupKeys() (int64, error)
+
+	ExportDataSubject(s DataSubject, actor string) (*DataSubjectBundle, error)
+	EraseDataSubject(s DataSubject, mode ErasureMode, actor string) (*ErasureResult, error)
+}
+
+// ContactMessageStore keeps the messages of the contact form.
+type ContactMessageStore interface {
+	SaveContactMessage(c *ContactSubmission, delivered bool) error
+	ListContactMessages(before uint, limit int) ([]ContactMessage, error)
+	GetContactMessage(id uint) (*ContactMessage, error)
+}
+
+// APIKeyStore keeps the keys of the read and admin api and the dashboard
+// sessions opened with them.
+type APIKeyStore interface {
+	CreateAPIKey(k *APIKey) (string, error)
+	AuthenticateAPIKey(key string) (*APIKey, error)
+	ListAPIKeys() ([]APIKey, error)
+	RevokeAPIKey(id uint) error
+	CountActiveAdminKeys() (int64, error)
+
+	CreateDashboardSession(keyID uint, ttl time.Duration) (string, error)
+	AuthenticateDashboardSession(id string) (*APIKey, error)
+	DeleteDashboardSession(id string) error
+}
+
+// AuditStore reads the audit log. Events are recorded by the store itself,
+// in the transaction of the change they describe.
+type AuditStore interface {
+	ListAuditEvents(limit int) ([]AuditEvent, error)
+}
+
+var _ Store = (*DatabaseHandler)(nil)
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tracer is resolved through the global provider, so spans started before
+// setupTracing ran are no-ops rather than lost configuration.
+var tracer = otel.Tracer("github.com/Flou21/feedback")
+
+// tracingEnabled reports whether an OTLP endpoint is configured, following
+// the standard OpenTelemetry env vars.
+func tracingEnabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// setupTracing installs the OTLP/HTTP exporter and W3C trace context
+// propagation. Without an endpoint it leaves the global no-op provider in
+// place. The returned function flushes pending spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if !tracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// endpoint, headers, timeout and compression come from the
+	// OTEL_EXPORTER_OTLP_* env vars
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("feedback")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sampler follows OTEL_TRACES_SAMPLER, by default parent based so
+	// the frontend decides whether a submission is traced
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("opentelemetry error", "err", err)
+	}))
+
+	notifyClient.Transport = otelhttp.NewTransport(http.DefaultTransport)
+	slog.Info("exporting traces via otlp")
+	return provider.Shutdown, nil
+}
+
+// fiberCarrier reads and writes trace context headers of a fiber request.
+type fiberCarrier struct {
+	c *fiber.Ctx
+}
+
+func (f fiberCarrier) Get(key string) string {
+	return f.c.Get(key)
+}
+
+func (f fiberCarrier) Set(key, value string) {
+	f.c.Request().Header.Set(key, value)
+}
+
+func (f fiberCarrier) Keys() []string {
+	var keys []string
+	f.c.Request().Header.VisitAll(func(k, _ []byte) {
+		keys = append(keys, string(k))
+	})
+	return keys
+}
+
+// requestTracing starts a server span per request, continuing the trace of
+// the caller's traceparent header. Handlers find it in c.UserContext().
+func requestTracing(c *fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), fiberCarrier{c})
+	ctx, span := tracer.Start(ctx, c.Method(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Method()),
+			semconv.URLPath(c.Path()),
+			semconv.UserAgentOriginal(c.Get(fiber.HeaderUserAgent)),
+		))
+	defer span.End()
+	c.SetUserContext(ctx)
+
+	self := c.Route()
+	err := c.Next()
+
+	status := responseStatus(c, err)
+	route := routePattern(c, self)
+	span.SetName(c.Method() + " " + route)
+	span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
+
+// traceContextOf serializes the trace context of ctx as a W3C traceparent,
+// empty when ctx isn't traced.
+func traceContextOf(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier["traceparent"]
+}
+
+// withTraceContext continues the trace serialized by traceContextOf.
+func withTraceContext(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagation.TraceContext{}.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// dbTracing is a gorm plugin adding a client span per statement to the
+// trace of the statement's context.
+type dbTracing struct{}
+
+const dbTracingSpanKey = "tracing:span"
+
+func (dbTracing) Name() string {
+	return "tracing"
+}
+
+func (dbTracing) Initialize(db *gorm.DB) error {
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx := tx.Statement.Context
+			if !trace.SpanContextFromContext(ctx).IsValid() {
+				// don't start a trace for every background query
+				return
+			}
+			name := "db." + operation
+			if tx.Statement.Table != "" {
+				name += " " + tx.Statement.Table
+			}
+			_, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+			tx.InstanceSet(dbTracingSpanKey, span)
+		}
+	}
+	after := func(tx *gorm.DB) {
+		v, ok := tx.InstanceGet(dbTracingSpanKey)
+		if !ok {
+			return
+		}
+		span := v.(trace.Span)
+		span.SetAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBQueryText(tx.Statement.SQL.String()),
+			semconv.DBCollectionName(tx.Statement.Table),
+			attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+		)
+		if err := tx.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("tracing:before_create", before("create")),
+		cb.Create().After("gorm:create").Register("tracing:after_create", after),
+		cb.Query().Before("gorm:query").Register("tracing:before_query", before("query")),
+		cb.Query().After("gorm:query").Register("tracing:after_query", after),
+		cb.Update().Before("gorm:update").Register("tracing:before_update", before("update")),
+		cb.Update().After("gorm:update").Register("tracing:after_update", after),
+		cb.Delete().Before("gorm:delete").Register("tracing:before_delete", before("delete")),
+		cb.Delete().After("gorm:delete").Register("tracing:after_delete", after),
+		cb.Row().Before("gorm:row").Register("tracing:before_row", before("row")),
+		cb.Row().After("gorm:row").Register("tracing:after_row", after),
+		cb.Raw().Before("gorm:raw").Register("tracing:before_raw", before("raw")),
+		cb.Raw().After("gorm:raw").Register("tracing:after_raw", after),
+	)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+var (
+	spanRecorder    = tracetest.NewSpanRecorder()
+	installRecorder sync.Once
+)
+
+// recordSpans installs a tracer provider recording every ended span. The
+// package tracer binds to the first global provider for good, so all tests
+// share one recorder.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	installRecorder.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(prev) })
+	return spanRecorder
+}
+
+func spanNamed(rec *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, s := range rec.Ended() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestRequestTracingContinuesTrace(t *testing.T) {
+	rec := recordSpans(t)
+	app := fiber.New()
+	app.Use(requestTracing)
+	app.Get("/api/things/:id", func(c *fiber.Ctx) error {
+		_, span := tracer.Start(c.UserContext(), "work")
+		span.End()
+		return c.SendString("ok")
+	})
+
+	req := httptest.NewRequest("GET", "/api/things/1", nil)
+	req.Header.Set("traceparent", testTraceparent)
+	if _, err := app.Test(req, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	server := spanNamed(rec, "GET /api/things/:id")
+	if server == nil {
+		t.Fatalf("no server span among %d spans", len(rec.Ended()))
+	}
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("incoming trace not continued, got trace %s", got)
+	}
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("unexpected kind %s", server.SpanKind())
+	}
+	work := spanNamed(rec, "work")
+	if work == nil || work.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("handler spans must be children of the server span")
+	}
+}
+
+func TestTraceContextRoundTrip(t *testing.T) {
+	recordSpans(t)
+	ctx := withTraceContext(context.Background(), testTraceparent)
+	if got := traceContextOf(ctx); got != testTraceparent {
+		t.Errorf("got %q", got)
+	}
+	if traceContextOf(context.Background()) != "" || withTraceContext(context.Background(), "") != context.Background() {
+		t.Error("untraced contexts must stay untraced")
+	}
+}
+
+func TestDBTracing(t *testing.T) {
+	rec := recordSpans(t)
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(dbTracing{}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := len(rec.Ended())
+	db.Find(&[]Issue{})
+	if len(rec.Ended()) != before {
+		t.Error("statements outside a trace must not start one")
+	}
+
+	ctx, parent := tracer.Start(context.Background(), "request")
+	db.WithContext(ctx).Find(&[]Issue{})
+	parent.End()
+	span := spanNamed(rec, "db.query issues")
+	if span == nil || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("no child span for the query: %+v", rec.Ended())
+	}
+	found := false
+	for _, a := range span.Attributes() {
+		if a.Key == "db.query.text" && a.Value.AsString() != "" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("query text not recorded")
+	}
+}
+
+func TestSetupTracingIsNoopWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notifyClient.Transport != nil {
+		t.Error("tracing must stay disabled without an endpoint")
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
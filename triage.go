@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// FeedbackStatus is the triage state of a feedback entry.
+type FeedbackStatus string
+
+const (
+	StatusNew          FeedbackStatus = "new"
+	StatusAcknowledged FeedbackStatus = "acknowledged"
+	StatusInProgress   FeedbackStatus = "in_progress"
+	StatusResolved     FeedbackStatus = "resolved"
+	StatusWontFix      FeedbackStatus = "wont_fix"
+)
+
+// statusTransitions lists the states each state may move to. Closed entries
+// can only be reopened as acknowledged, never straight back to new.
+var statusTransitions = map[FeedbackStatus][]FeedbackStatus{
+	StatusNew:          {StatusAcknowledged, StatusInProgress, StatusResolved, StatusWontFix},
+	StatusAcknowledged: {StatusInProgress, StatusResolved, StatusWontFix},
+	StatusInProgress:   {StatusAcknowledged, StatusResolved, StatusWontFix},
+	StatusResolved:     {StatusAcknowledged},
+	StatusWontFix:      {StatusAcknowledged},
+}
+
+func (s FeedbackStatus) valid() bool {
+	_, ok := statusTransitions[s]
+	return ok
+}
+
+// closed reports whether the entry needs no further work.
+func (s FeedbackStatus) closed() bool {
+	return s == StatusResolved || s == StatusWontFix
+}
+
+// canTransition reports whether moving from s to next is a legal step.
+func (s FeedbackStatus) canTransition(next FeedbackStatus) bool {
+	return slices.Contains(statusTransitions[s], next)
+}
+
+// ErrConcurrentUpdate is returned when the entry changed between loading and
+// updating it.
+var ErrConcurrentUpdate = errors.New("feedback was modified concurrently")
+
+// TriageState is where a feedback entry or an issue stands in triage.
+type TriageState struct {
+	Status          FeedbackStatus `json:"status" gorm:"default:new;index"`
+	Assignee        string         `json:"assignee" gorm:"index"`
+	ResolutionNote  string         `json:"resolutionNote"`
+	StatusChangedAt *time.Time     `json:"statusChangedAt"`
+	AcknowledgedAt  *time.Time     `json:"acknowledgedAt"`
+	ResolvedAt      *time.Time     `json:"resolvedAt"`
+}
+
+// TriageUpdate is the body of PATCH /api/feedback/{id}. Omitted fields are
+// left untouched; an empty assignee unassigns.
+type TriageUpdate struct {
+	Status         *FeedbackStatus `json:"status"`
+	Assignee       *string         `json:"assignee"`
+	ResolutionNote *string         `json:"resolutionNote"`
+	// UpdatedAt is the UpdatedAt of the entry the change was made on. If
+	// set, the update fails with ErrConcurrentUpdate when the entry was
+	// written since.
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+// stale tells whether the entry changed since the version the client saw.
+func (u *TriageUpdate) stale(updatedAt time.Time) bool {
+	return u.UpdatedAt != nil && !u.UpdatedAt.Equal(updatedAt)
+}
+
+// apply validates the update against the current state f and writes the
+// changes into it. It returns the columns that changed.
+func (u *TriageUpdate) apply(f *TriageState, now time.Time) (map[string]interface{}, error) {
+	note := f.ResolutionNote
+	if u.ResolutionNote != nil {
+		note = strings.TrimSpace(*u.ResolutionNote)
+	}
+
+	// validate before touching f, a rejected update leaves it as it was
+	changeStatus := u.Status != nil && *u.Status != f.Status
+	if changeStatus {
+		next := *u.Status
+		if !next.valid() {
+			return nil, &FeedbackValidationError{Reason: fmt.Sprintf("unknown status %q", next)}
+		}
+		if !f.Status.canTransition(next) {
+			return nil, &FeedbackValidationError{Reason: fmt.Sprintf("illegal status transition %s -> %s", f.Status, next)}
+		}
+		if next == StatusWontFix && note == "" {
+			return nil, &FeedbackValidationError{Reason: "a resolution note is required to close as wont_fix"}
+		}
+	}
+
+	changes := make(map[string]interface{})
+	if u.Assignee != nil {
+		f.Assignee = strings.TrimSpace(*u.Assignee)
+		changes["assignee"] = f.Assignee
+	}
+	if u.ResolutionNote != nil {
+		f.ResolutionNote = note
+		changes["resolution_note"] = f.ResolutionNote
+	}
+
+	if changeStatus {
+		next := *u.Status
+		f.Status = next
+		f.StatusChangedAt = &now
+		changes["status"] = f.Status
+		changes["status_changed_at"] = f.StatusChangedAt
+
+		if f.AcknowledgedAt == nil {
+			f.AcknowledgedAt = &now
+			changes["acknowledged_at"] = f.AcknowledgedAt
+		}
+		if next.closed() {
+			f.ResolvedAt = &now
+		} else {
+			f.ResolvedAt = nil
+		}
+		changes["resolved_at"] = f.ResolvedAt
+	}
+
+	return changes, nil
+}
+
+func (h *ApiHandler) patchFeedbackRequest(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return fiber.NewError(http.StatusBadRequest, "id must be a positive integer")
+	}
+
+	var update TriageUpdate
+	if err := c.BodyParser(&update); err != nil {
+		return fiber.NewError(http.StatusBadRequest, "invalid body")
+	}
+
+	f, err := h.store.UpdateFeedbackTriage(uint(id), &update)
+	if err != nil {
+		var invalid *FeedbackValidationError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return fiber.NewError(http.StatusNotFound, "feedback not found")
+		case errors.Is(err, ErrConcurrentUpdate):
+			return fiber.NewError(http.StatusConflict, err.Error())
+		case errors.As(err, &invalid):
+			return fiber.NewError(http.StatusUnprocessableEntity, invalid.Reason)
+		}
+		slog.Error("could not update feedback", "id", id, "err", err)
+		errorsCounter.WithLabelValues("").Inc()
+		return fiber.NewError(http.StatusInternalServerError, "could not update feedback")
+	}
+
+	return c.JSON(f)
+}
+
+// FeedbackNote is a free text comment on a feedback entry, left while
+// triaging it.
+type FeedbackNote struct {
+	gorm.Model
+	FeedbackID uint   `json:"feedbackId" gorm:"index"`
+	Author     string `json:"author"`
+	Text       string `json:"text"`
+}
+
+// maxNoteLength bounds a single note.
+const maxNoteLength = 4000
+
+// noteText trims a note and checks its length, the same for every Store.
+func noteText(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", &FeedbackValidationError{Reason: "note is empty"}
+	}
+	if utf8.RuneCountInString(text) > maxNoteLength {
+		return "", &FeedbackValidationError{Reason: fmt.Sprintf("note is longer than %d characters", maxNoteLength)}
+	}
+	return text, nil
+}
+
+// AddFeedbackNote attaches a note to an existing feedback entry.
+func (d *DatabaseHandler) AddFeedbackNote(feedbackID uint, author, text string) (*FeedbackNote, error) {
+	text, err := noteText(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.GetFeedback(feedbackID); err != nil {
+		return nil, err
+	}
+	note := &FeedbackNote{FeedbackID: feedbackID, Author: author, Text: text}
+	if err := d.db.Create(note).Error; err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// ListFeedbackNotes returns the notes of a feedback entry, oldest first.
+func (d *DatabaseHandler) ListFeedbackNotes(feedbackID uint) ([]FeedbackNote, error) {
+	var notes []FeedbackNote
+	err := d.db.Where("feedback_id = ?", feedbackID).Order("id").Find(&notes).Error
+	return notes, err
+}
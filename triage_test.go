@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func statusPtr(s FeedbackStatus) *FeedbackStatus { return &s }
+func strPtr(s string) *string                    { return &s }
+
+func TestTriageLifecycle(t *testing.T) {
+	now := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+	f := &TriageState{Status: StatusNew}
+
+	changes, err := (&TriageUpdate{Status: statusPtr(StatusInProgress), Assignee: strPtr(" alice ")}).apply(f, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != StatusInProgress || f.Assignee != "alice" || f.AcknowledgedAt == nil {
+		t.Fatalf("unexpected state after starting work: %+v", f)
+	}
+	if changes["assignee"] != "alice" || changes["status"] != StatusInProgress {
+		t.Errorf("changes not reported: %v", changes)
+	}
+
+	later := now.Add(time.Hour)
+	if _, err := (&TriageUpdate{Status: statusPtr(StatusResolved), ResolutionNote: strPtr("fixed in 1.2")}).apply(f, later); err != nil {
+		t.Fatal(err)
+	}
+	if f.ResolvedAt == nil || !f.ResolvedAt.Equal(later) || !f.AcknowledgedAt.Equal(now) {
+		t.Errorf("timestamps not maintained: %+v", f)
+	}
+
+	// reopening clears the resolution time
+	if _, err := (&TriageUpdate{Status: statusPtr(StatusAcknowledged)}).apply(f, later); err != nil {
+		t.Fatal(err)
+	}
+	if f.ResolvedAt != nil {
+		t.Error("reopened entry still has a resolution time")
+	}
+}
+
+func TestTriageRejectsIllegalTransitions(t *testing.T) {
+	cases := []struct {
+		from   FeedbackStatus
+		update TriageUpdate
+	}{
+		{StatusResolved, TriageUpdate{Status: statusPtr(StatusNew), Assignee: strPtr("bob"), ResolutionNote: strPtr("reopen")}},
+		{StatusAcknowledged, TriageUpdate{Status: statusPtr(StatusNew)}},
+		{StatusNew, TriageUpdate{Status: statusPtr("done")}},
+		{StatusNew, TriageUpdate{Status: statusPtr(StatusWontFix)}},
+	}
+	for _, tc := range cases {
+		f := &TriageState{Status: tc.from}
+		_, err := tc.update.apply(f, time.Now())
+		var invalid *FeedbackValidationError
+		if !errors.As(err, &invalid) {
+			t.Errorf("%s -> %s: expected a validation error, got %v", tc.from, *tc.update.Status, err)
+		}
+		if *f != (TriageState{Status: tc.from}) {
+			t.Errorf("%s: state changed despite error: %+v", tc.from, f)
+		}
+	}
+}
+
+func TestTriageNoopUpdate(t *testing.T) {
+	f := &TriageState{Status: StatusAcknowledged}
+	changes, err := (&TriageUpdate{Status: statusPtr(StatusAcknowledged)}).apply(f, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func TestUpdateFeedbackTriageDetectsConcurrentWrites(t *testing.T) {
+	a := newTestApi(t)
+	db := a.store.(*DatabaseHandler)
+	f := &Feedback{Project: "sky", Payload: JSONB(`{}`), TriageState: TriageState{Status: StatusNew}}
+	if err := db.db.Create(f).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// a second triager assigns the entry right after the first one read it
+	raced := false
+	err := db.db.Callback().Query().After("gorm:query").Register("test:race", func(tx *gorm.DB) {
+		if raced || tx.Statement.Table != "feedbacks" {
+			return
+		}
+		raced = true
+		if _, err := db.UpdateFeedbackTriage(f.ID, &TriageUpdate{Assignee: strPtr("bob")}); err != nil {
+			t.Errorf("second triager: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpdateFeedbackTriage(f.ID, &TriageUpdate{Assignee: strPtr("alice")}); !errors.Is(err, ErrConcurrentUpdate) {
+		t.Fatalf("expected a concurrent update error, got %v", err)
+	}
+	db.db.Callback().Query().Remove("test:race")
+
+	got, err := db.UpdateFeedbackTriage(f.ID, &TriageUpdate{ResolutionNote: strPtr("seen")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Assignee != "bob" {
+		t.Errorf("assignee = %q, the second triager's change was lost", got.Assignee)
+	}
+}
+
+func TestUpdateFeedbackTriageRefusesStaleVersion(t *testing.T) {
+	a := newTestApi(t)
+	db := a.store.(*DatabaseHandler)
+	f := &Feedback{Project: "sky", Payload: JSONB(`{}`), TriageState: TriageState{Status: StatusNew}}
+	if err := db.db.Create(f).Error; err != nil {
+		t.Fatal(err)
+	}
+	seen := f.UpdatedAt
+
+	// bob assigns himself after alice loaded the entry
+	time.Sleep(time.Millisecond)
+	bob, err := db.UpdateFeedbackTriage(f.ID, &TriageUpdate{Assignee: strPtr("bob"), UpdatedAt: &seen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UpdateFeedbackTriage(f.ID, &TriageUpdate{Assignee: strPtr("alice"), UpdatedAt: &seen}); !errors.Is(err, ErrConcurrentUpdate) {
+		t.Fatalf("expected alice's stale update to conflict, got %v", err)
+	}
+
+	status, body := a.do("PATCH", fmt.Sprintf("/api/feedback/%d", f.ID), a.admin, map[string]interface{}{"assignee": "alice", "updatedAt": seen})
+	if status != http.StatusConflict {
+		t.Errorf("stale PATCH = %d: %s", status, body)
+	}
+	status, body = a.do("PATCH", fmt.Sprintf("/api/feedback/%d", f.ID), a.admin, map[string]interface{}{"assignee": "alice", "updatedAt": bob.UpdatedAt})
+	if status != http.StatusOK {
+		t.Errorf("PATCH with the current version = %d: %s", status, body)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// WebhookNotifier posts the notification as JSON to an arbitrary endpoint,
+// for integrations that want the structured record rather than a message.
+type WebhookNotifier struct {
+	URL     string
+	Headers map[string]string
+}
+
+// webhookPayload is the body the generic webhook receives.
+type webhookPayload struct {
+	ID       string             `json:"id"`
+	Subject  string             `json:"subject"`
+	Text     string             `json:"text"`
+	Project  string             `json:"project,omitempty"`
+	Feedback *Feedback          `json:"feedback,omitempty"`
+	Contact  *ContactSubmission `json:"contact,omitempty"`
+	Error    *ErrorGroup        `json:"error,omitempty"`
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	if w.URL == "" {
+		return fmt.Errorf("no webhook url configured")
+	}
+
+	return postJSON(ctx, http.MethodPost, w.URL, w.Headers, webhookPayload{
+		ID:       n.ID,
+		Subject:  n.Subject,
+		Text:     n.plainText(),
+		Project:  n.Project,
+		Feedback: n.Feedback,
+		Contact:  n.Contact,
+		Error:    n.Error,
+	})
+}